@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+func browseCommand() *cli.Command {
+	var (
+		queueName  string
+		limit      int
+		visibility int
+		logPath    string
+	)
+
+	return &cli.Command{
+		Name:      "browse",
+		Usage:     "browse and triage a queue in the terminal UI",
+		UsageText: `sqsdumper browse -s src_queue`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "queueName",
+				Aliases:     []string{"s"},
+				Usage:       "the source queue",
+				Destination: &queueName,
+				Required:    true,
+			},
+			&cli.IntFlag{
+				Name:        "limit",
+				Usage:       "hold up to N messages",
+				Destination: &limit,
+				Value:       100,
+			},
+			&cli.IntFlag{
+				Name:        "visibility",
+				Usage:       "the visibility timeout in seconds the held messages are extended to while browsing",
+				Destination: &visibility,
+				Value:       60,
+			},
+			&cli.StringFlag{
+				Name:        "log",
+				Usage:       "append the log to the file, the terminal is taken by the UI",
+				Destination: &logPath,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.Nop()
+			if logPath != "" {
+				f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				l = zerolog.New(f).With().Timestamp().Logger()
+			}
+			if visibility < 1 {
+				err := errors.New("--visibility must be at least 1 second")
+				l.Err(err).Msg("bad --visibility value")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+			client := sqs.NewFromConfig(cfg)
+
+			poller, err := aws.NewSQSPoller(
+				aws.SQSParam{
+					Client: client,
+					Logger: l,
+					QueueConfig: aws.ConfigQueue{
+						QueueName:               queueName,
+						MaxMessagesPerRetrieval: 10,
+						WaitTimeSeconds:         2,
+						VisibilityTimeout:       int32(visibility),
+					},
+					StopOnTotal: true,
+					Quiet:       true,
+				},
+			)
+			if err != nil {
+				l.Err(err).Msg("error creating SQS poller")
+				return err
+			}
+
+			browser := commands.NewSQSBrowser(commands.SQSBrowserParams{
+				Logger:     l,
+				Client:     client,
+				Poller:     poller,
+				Limit:      limit,
+				Visibility: time.Duration(visibility) * time.Second,
+			})
+
+			defer func() {
+				// the context may be canceled already
+				if err := browser.ReleaseAll(context.Background()); err != nil {
+					l.Err(err).Msg("error releasing the held messages")
+				}
+			}()
+
+			return browser.Browse(ctx.Context, func(ctx context.Context) error {
+				return poller.PollMessages(ctx, browser.ProcessMessages(ctx))
+			})
+		},
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// shutdownTimeout bounds the wait for the metrics requests in progress and the span export
+const shutdownTimeout = 5 * time.Second
+
+func daemonCommand() *cli.Command {
+	var (
+		queueName     string
+		deleteMessage bool
+		rawMessage    bool
+		onError       string
+		msgRate       float64
+		apiRate       float64
+		maxHold       time.Duration
+		dedupStore    string
+		redact        redactOptions
+		listen        string
+		depthInterval time.Duration
+		tracing       traceOptions
+		output        outputOptions
+	)
+
+	return &cli.Command{
+		Name:      "daemon",
+		Usage:     "dump a queue until signalled, serving the Prometheus /metrics and /healthz",
+		UsageText: `sqsdumper daemon -s src_queue --deleteMessage --output archive.jsonl --listen :9090`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "queueName",
+				Aliases:     []string{"s"},
+				Usage:       "the source queue",
+				Destination: &queueName,
+				Required:    true,
+			},
+			&cli.BoolFlag{
+				Name:        "deleteMessage",
+				Usage:       "delete the dumped messages",
+				Destination: &deleteMessage,
+			},
+			&cli.BoolFlag{
+				Name:        "raw",
+				Usage:       "dump entire raw messages",
+				Destination: &rawMessage,
+			},
+			&cli.StringFlag{
+				Name:        "on-error",
+				Usage:       "on processing error: skip, stop, retry=N or quarantine=<queue|file>",
+				Destination: &onError,
+				Value:       "skip",
+			},
+			&cli.Float64Flag{
+				Name:        "rate",
+				Usage:       "limit processed messages per second, 0 is unlimited",
+				Destination: &msgRate,
+			},
+			&cli.Float64Flag{
+				Name:        "api-rate",
+				Usage:       "limit SQS API calls per second, 0 is unlimited",
+				Destination: &apiRate,
+			},
+			&cli.DurationFlag{
+				Name:        "max-hold",
+				Usage:       "extend the visibility of a message being processed up to the duration, 0 disables the extension",
+				Destination: &maxHold,
+				Value:       aws.DefaultMaxHold,
+			},
+			&cli.StringFlag{
+				Name:        "dedup-store",
+				Usage:       "the file of the dumped MessageIds, the messages found there are skipped",
+				Destination: &dedupStore,
+			},
+			&cli.StringFlag{
+				Name:        "listen",
+				Usage:       "the address of the /metrics and /healthz endpoints",
+				Destination: &listen,
+				Value:       ":9090",
+			},
+			&cli.DurationFlag{
+				Name:        "depth-interval",
+				Usage:       "the interval of the queue depth updates",
+				Destination: &depthInterval,
+				Value:       30 * time.Second,
+			},
+		}, append(append(tracing.flags(), output.flags()...), redact.flags()...)...),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if depthInterval <= 0 {
+				err := errors.New("--depth-interval must be positive")
+				l.Err(err).Msg("bad --depth-interval value")
+				return err
+			}
+
+			errorPolicy, err := aws.ParseErrorPolicy(onError)
+			if err != nil {
+				l.Err(err).Msg("bad --on-error value")
+				return err
+			}
+			errorPolicy.Delete = deleteMessage
+
+			redactor, err := redact.redactor(ctx)
+			if err != nil {
+				l.Err(err).Msg("bad redaction flags")
+				return err
+			}
+			if redactor != nil {
+				errorPolicy.Redact = redactor.Redact
+			}
+
+			// the ids are kept in memory only with a store, a long run would grow them otherwise
+			dedupMode := aws.DedupNone
+			var store *aws.DedupStore
+			if dedupStore != "" {
+				if store, err = aws.OpenDedupStore(dedupStore); err != nil {
+					l.Err(err).Msg("bad --dedup-store value")
+					return err
+				}
+				defer store.Close()
+				dedupMode = aws.DedupMessageID
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+
+			tracer, flushSpans, err := tracing.tracing(ctx.Context, queueName)
+			if err != nil {
+				l.Err(err).Msg("bad --trace value")
+				return err
+			}
+			defer func() {
+				if err := flushSpans(); err != nil {
+					l.Err(err).Msg("error exporting the spans")
+				}
+			}()
+
+			dumpOutput, archive, closeOutput, err := output.open(ctx.Context, cfg, queueName, l)
+			if err != nil {
+				l.Err(err).Msg("bad --output value")
+				return err
+			}
+			defer func() {
+				if err := closeOutput(); err != nil {
+					l.Err(err).Msg("error closing the output")
+				}
+			}()
+
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+			metrics := aws.NewMetrics(reg, queueName)
+
+			poller, err := aws.NewSQSPoller(
+				aws.SQSParam{
+					Client: metrics.WrapSQSAPI(tracer.WrapSQSAPI(sqs.NewFromConfig(cfg))),
+					Logger: l,
+					QueueConfig: aws.ConfigQueue{
+						QueueName:               queueName,
+						MaxMessagesPerRetrieval: 10,
+						WaitTimeSeconds:         20,
+					},
+					ErrorPolicy: errorPolicy,
+					RateLimiter: aws.NewRateLimiter(msgRate, apiRate),
+					Quiet:       true,
+					MaxHold:     maxHold,
+					Dedup:       dedupMode,
+					DedupStore:  store,
+
+					DeleteDuplicates: deleteMessage,
+				},
+			)
+			if err != nil {
+				l.Err(err).Msg("error creating SQS poller")
+				return err
+			}
+
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				l.Err(err).Msg("bad --listen value")
+				return err
+			}
+			server := &http.Server{Handler: daemonHandler(reg, metrics), ReadHeaderTimeout: shutdownTimeout}
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					l.Err(err).Msg("metrics server error")
+					stop()
+				}
+			}()
+			defer func() {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				defer cancel()
+				if err := server.Shutdown(shutdownCtx); err != nil {
+					l.Err(err).Msg("error stopping the metrics server")
+				}
+			}()
+
+			go metrics.WatchDepth(runCtx, poller, depthInterval)
+
+			dumper := commands.NewSQSDumper(commands.SQSDumperParams{
+				Logger:        l,
+				DeleteMessage: deleteMessage,
+				RawMessage:    rawMessage,
+				Redactor:      redactor,
+				Output:        dumpOutput,
+				Archive:       archive,
+				Stream:        true,
+			})
+
+			l.Info().Str("listen", listener.Addr().String()).Msg("started the daemon")
+			defer func() {
+				l.Log().Msgf(" === %s", poller.GetSummary())
+			}()
+
+			return poller.PollMessages(runCtx, metrics.WrapHandler(tracer.WrapHandler(dumper.ProcessMessages(runCtx))))
+		},
+	}
+}
+
+// daemonHandler serves the metrics and the health check failing while the messages can't be received
+func daemonHandler(reg *prometheus.Registry, metrics *aws.Metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := metrics.Healthy(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "receive error: %v\n", err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"andboson/sqsdumper/internal/commands"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// decodeOptions holds the flags of the binary payload decoding
+type decodeOptions struct {
+	protoDescriptors string
+	protoType        string
+	protoTypeAttr    string
+	avroSchema       string
+}
+
+func (o *decodeOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "decode-proto",
+			Usage:       "decode base64 protobuf payloads by the descriptor set file of protoc --descriptor_set_out --include_imports",
+			Destination: &o.protoDescriptors,
+		},
+		&cli.StringFlag{
+			Name:        "proto-type",
+			Usage:       "the full protobuf message type name, like orders.v1.Order",
+			Destination: &o.protoType,
+		},
+		&cli.StringFlag{
+			Name:        "proto-type-attr",
+			Usage:       "the message attribute holding the protobuf message type name, --proto-type is the fallback",
+			Destination: &o.protoTypeAttr,
+		},
+		&cli.StringFlag{
+			Name:        "decode-avro",
+			Usage:       "decode base64 Avro binary payloads by the schema file",
+			Destination: &o.avroSchema,
+		},
+	}
+}
+
+// decoder returns the payload decoder, nil if decoding isn't requested
+func (o *decodeOptions) decoder() (commands.PayloadDecoder, error) {
+	switch {
+	case o.protoDescriptors != "" && o.avroSchema != "":
+		return nil, errors.New("--decode-proto and --decode-avro are mutually exclusive")
+	case o.protoDescriptors != "":
+		return commands.NewProtoDecoder(o.protoDescriptors, o.protoType, o.protoTypeAttr)
+	case o.avroSchema != "":
+		return commands.NewAvroDecoder(o.avroSchema)
+	default:
+		return nil, nil
+	}
+}
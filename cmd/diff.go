@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// diffOptions holds the flags of the diff command
+type diffOptions struct {
+	scan       scanOptions
+	decode     decodeOptions
+	key        string
+	details    bool
+	archiveDir string
+	s3Endpoint string
+}
+
+func diffCommand() *cli.Command {
+	var o diffOptions
+
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "compare two queues or a queue and an archive without consuming them",
+		UsageText: `sqsdumper diff your-queue-dead-letter-queue s3://bucket/prefix/20221019T150405Z/manifest.json --key body.orderId --details`,
+		ArgsUsage: "<queue|s3://bucket/key/manifest.json|manifest.json> <queue|s3://bucket/key/manifest.json|manifest.json>",
+		Flags: append(append(o.scan.scanFlags(), o.decode.flags()...),
+			&cli.StringFlag{
+				Name:        "key",
+				Usage:       "compare the messages by: id, body (a body hash) or a field path like body.orderId or msgattr.type",
+				Destination: &o.key,
+				Value:       commands.DiffKeyMessageID,
+			},
+			&cli.BoolFlag{
+				Name:        "details",
+				Usage:       "print the keys present on one side only as JSON lines",
+				Destination: &o.details,
+			},
+			&cli.StringFlag{
+				Name:        "archive-dir",
+				Usage:       "read the s3:// manifests from <dir>/<bucket>/<key> files instead of S3",
+				Destination: &o.archiveDir,
+			},
+			&cli.StringFlag{
+				Name:        "s3-endpoint",
+				Usage:       "the URL of an S3-compatible store, like http://localhost:9000",
+				Destination: &o.s3Endpoint,
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			if ctx.NArg() != 2 {
+				err := errors.New("two sides are required")
+				l.Err(err).Msg("bad arguments")
+				return err
+			}
+
+			decoder, err := o.decode.decoder()
+			if err != nil {
+				l.Err(err).Msg("bad payload decoding flags")
+				return err
+			}
+			filter, err := o.scan.window.filter(time.Now())
+			if err != nil {
+				l.Err(err).Msg("bad time window")
+				return err
+			}
+
+			if err := o.scan.check(); err != nil {
+				l.Err(err).Msg("bad --visibility value")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+
+			sets := make([]*commands.DiffSet, 0, 2)
+			for _, side := range ctx.Args().Slice() {
+				set, err := o.load(ctx.Context, cfg, side, commands.DiffSetParams{
+					Logger:  l.With().Str("side", side).Logger(),
+					Key:     o.key,
+					Decoder: decoder,
+					Filter:  filter,
+				})
+				if err != nil {
+					l.Err(err).Str("side", side).Msg("error reading the side")
+					return err
+				}
+				l.Log().Msgf(" === %s: %d keys, without the key: %d", side, set.Len(), set.Keyless())
+				sets = append(sets, set)
+			}
+
+			return commands.PrintDiff(nil, commands.Diff(sets[0], sets[1]), o.details)
+		},
+	}
+}
+
+// load returns the set of the archive or the queue side,
+// the scanned queue messages are released once the side is read
+func (o *diffOptions) load(ctx context.Context, cfg awssdk.Config, side string, p commands.DiffSetParams) (*commands.DiffSet, error) {
+	set := commands.NewDiffSet(p)
+	if isManifest(side) {
+		store, key := manifestStore(cfg, side, o.archiveDir, o.s3Endpoint)
+		return set, set.LoadArchive(ctx, store, key)
+	}
+
+	scan := o.scan
+	scan.queueName = side
+	poller, err := scan.newPoller(sqs.NewFromConfig(cfg), p.Logger, set.HoldFiltered())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the context may be canceled already
+		if err := set.Release(context.Background(), poller); err != nil {
+			p.Logger.Err(err).Msg("error releasing the scanned messages")
+		}
+	}()
+
+	return set, poller.PollMessages(ctx, set.ProcessMessages(ctx))
+}
+
+// isManifest reports whether the side is an archive manifest, the queue names can't hold the .json suffix
+func isManifest(side string) bool {
+	_, _, ok := aws.ParseS3URL(side)
+	return ok || strings.HasSuffix(side, ".json")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+func findCommand() *cli.Command {
+	var (
+		scan          scanOptions
+		decode        decodeOptions
+		matches       cli.StringSlice
+		limit         int
+		deleteMessage bool
+	)
+
+	return &cli.Command{
+		Name:      "find",
+		Usage:     "search a queue for messages without consuming them",
+		UsageText: `sqsdumper find -s src_queue --match 'body.orderId == "X"'`,
+		Flags: append(append(scan.flags(), decode.flags()...),
+			&cli.StringSliceFlag{
+				Name:        "match",
+				Usage:       "match expression like 'body.x.y == \"X\"', 'attr.SenderId != 42' or 'msgattr.type =~ \"^order\"', all must match",
+				Destination: &matches,
+				Required:    true,
+			},
+			&cli.IntFlag{
+				Name:        "limit",
+				Usage:       "stop after N matches, 0 scans the whole queue",
+				Destination: &limit,
+			},
+			&cli.BoolFlag{
+				Name:        "deleteMessage",
+				Usage:       "delete the matching messages",
+				Destination: &deleteMessage,
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			matchers, err := commands.ParseMatchers(matches.Value())
+			if err != nil {
+				l.Err(err).Msg("bad --match value")
+				return err
+			}
+
+			decoder, err := decode.decoder()
+			if err != nil {
+				l.Err(err).Msg("bad payload decoding flags")
+				return err
+			}
+
+			if err := scan.check(); err != nil {
+				l.Err(err).Msg("bad --visibility value")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+
+			finder := commands.NewSQSFinder(commands.SQSFinderParams{
+				Logger:        l,
+				Matchers:      matchers,
+				DeleteMessage: deleteMessage,
+				Limit:         limit,
+				Decoder:       decoder,
+			})
+
+			poller, err := scan.newPoller(sqs.NewFromConfig(cfg), l, finder.HoldFiltered())
+			if err != nil {
+				l.Err(err).Msg("error creating SQS poller")
+				return err
+			}
+
+			defer func() {
+				// the context may be canceled already
+				if err := finder.Release(context.Background(), poller); err != nil {
+					l.Err(err).Msg("error releasing the scanned messages")
+				}
+				l.Log().Msgf(" === found: %d, scanned: %d", finder.Matches(), poller.GetSummary().Processed)
+			}()
+
+			return poller.PollMessages(ctx.Context, finder.ProcessMessages(ctx.Context))
+		},
+	}
+}
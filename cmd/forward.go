@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/sinks"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// forward payloads
+const (
+	forwardPayloadBody   = "body"
+	forwardPayloadRecord = "record"
+)
+
+func forwardCommand() *cli.Command {
+	var (
+		queueName   string
+		sink        sinkOptions
+		transform   transformOptions
+		tracing     traceOptions
+		payload     string
+		concurrency int
+		retries     int
+		backoff     time.Duration
+		failures    string
+		msgRate     float64
+		apiRate     float64
+	)
+
+	return &cli.Command{
+		Name:      "forward",
+		Usage:     "forward the messages to a sink, a message is deleted only after the sink acknowledged it",
+		UsageText: `sqsdumper forward -s src_queue --to-http https://example.com/hook`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "queueName",
+				Aliases:     []string{"s"},
+				Usage:       "the source queue",
+				Destination: &queueName,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "payload",
+				Usage:       "send the message body or the full record with the attributes as JSON: body or record",
+				Destination: &payload,
+				Value:       forwardPayloadBody,
+			},
+			&cli.IntFlag{
+				Name:        "concurrency",
+				Usage:       "the number of the messages sent at once, a FIFO queue is sent one by one to keep the group order",
+				Destination: &concurrency,
+				Value:       1,
+			},
+			&cli.IntFlag{
+				Name:        "retries",
+				Usage:       "retry a failed send N times",
+				Destination: &retries,
+				Value:       3,
+			},
+			&cli.DurationFlag{
+				Name:        "backoff",
+				Usage:       "the delay before the first retry, doubled every retry",
+				Destination: &backoff,
+				Value:       time.Second,
+			},
+			&cli.StringFlag{
+				Name:        "failures",
+				Usage:       "append the messages which can't be sent to the file as JSON lines, they stay in the queue",
+				Destination: &failures,
+			},
+			&cli.Float64Flag{
+				Name:        "rate",
+				Usage:       "limit forwarded messages per second, 0 is unlimited",
+				Destination: &msgRate,
+			},
+			&cli.Float64Flag{
+				Name:        "api-rate",
+				Usage:       "limit SQS API calls per second, 0 is unlimited",
+				Destination: &apiRate,
+			},
+		}, append(append(sink.flags(), transform.flags()...), tracing.flags()...)...),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			if payload != forwardPayloadBody && payload != forwardPayloadRecord {
+				err := errors.Errorf("unknown payload %q, use body or record", payload)
+				l.Err(err).Msg("bad --payload value")
+				return err
+			}
+
+			if payload == forwardPayloadRecord && sink.toSNS != "" {
+				err := errors.New("--to-sns publishes the message payload, use --payload body")
+				l.Err(err).Msg("bad --payload value")
+				return err
+			}
+
+			transformer, err := transform.transformer()
+			if err != nil {
+				l.Err(err).Msg("bad transform flags")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+			tracer, flushSpans, err := tracing.tracing(ctx.Context, queueName)
+			if err != nil {
+				l.Err(err).Msg("bad --trace value")
+				return err
+			}
+			defer func() {
+				if err := flushSpans(); err != nil {
+					l.Err(err).Msg("error exporting the spans")
+				}
+			}()
+			client := tracer.WrapSQSAPI(sqs.NewFromConfig(cfg))
+
+			// a dry run sends nothing
+			var target sinks.Sink
+			if !transform.dryRun {
+				if target, err = sink.sink(sns.NewFromConfig(cfg)); err != nil {
+					l.Err(err).Msg("bad sink flags")
+					return err
+				}
+				defer target.Close()
+			}
+
+			var failed aws.Quarantine
+			if failures != "" {
+				if failed, err = aws.NewQuarantine(ctx.Context, client, "file:"+failures); err != nil {
+					l.Err(err).Msg("bad --failures value")
+					return err
+				}
+			}
+
+			forwarder := commands.NewSQSForwarder(commands.SQSForwarderParams{
+				Logger:      l,
+				Sink:        target,
+				FullRecord:  payload == forwardPayloadRecord,
+				Unwrap:      sink.toSNS != "",
+				Concurrency: concurrency,
+				Retries:     retries,
+				Backoff:     backoff,
+				Failures:    failed,
+				Transformer: transformer,
+				DryRun:      transform.dryRun,
+				Tracing:     tracer,
+			})
+
+			poller, err := aws.NewSQSPoller(
+				aws.SQSParam{
+					Client: client,
+					Logger: l,
+					QueueConfig: aws.ConfigQueue{
+						QueueName:               queueName,
+						MaxMessagesPerRetrieval: 10,
+						WaitTimeSeconds:         2,
+					},
+					StopOnTotal: true,
+					RateLimiter: aws.NewRateLimiter(msgRate, apiRate),
+					// a message is held while it's sent with the retries
+					MaxHold: aws.DefaultMaxHold,
+					// a message in flight or failed may be received again
+					Dedup: aws.DedupMessageID,
+				},
+			)
+			if err != nil {
+				l.Err(err).Msg("error creating SQS poller")
+				return err
+			}
+			if concurrency > 1 && poller.IsFIFO() {
+				// the concurrent sends of a message group would be reordered
+				err := errors.New("a FIFO queue is forwarded in order, --concurrency must be 1")
+				l.Err(err).Msg("bad --concurrency value")
+				return err
+			}
+
+			defer func() {
+				forwarder.Wait()
+				if transform.dryRun {
+					l.Log().Msgf(" === dry run, checked: %d, failed: %d", poller.GetSummary().Processed, forwarder.Failed())
+					return
+				}
+				l.Log().Msgf(" === forwarded: %d, failed: %d, duplicates: %d",
+					forwarder.Forwarded(), forwarder.Failed(), poller.GetSummary().Duplicates)
+			}()
+
+			return poller.PollMessages(ctx.Context, tracer.WrapHandler(forwarder.ProcessMessages(ctx.Context)))
+		},
+	}
+}
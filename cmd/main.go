@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"andboson/sqsdumper/internal/commands"
 	"andboson/sqsdumper/internal/wrappers/aws"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	cli "github.com/urfave/cli/v2"
 )
@@ -15,6 +20,9 @@ import (
 // Version holds the application version
 var Version string
 
+// sampleVisibility holds the sampled messages invisible until the pass is over, like the scan default
+const sampleVisibility = 300
+
 func main() {
 	var (
 		stopAfter     int
@@ -23,6 +31,33 @@ func main() {
 		rawMessage    bool
 		queueName     string
 		jsonPath      string
+		query         string
+		rawOutput     bool
+		onError       string
+		msgRate       float64
+		apiRate       float64
+		groups        cli.StringSlice
+		fetchPayload  bool
+		payloadDir    string
+		deletePayload bool
+		schemaPath    string
+		schemaKey     string
+		invalidPath   string
+		decode        decodeOptions
+		tracing       traceOptions
+		output        outputOptions
+		window        windowOptions
+		transform     transformOptions
+		olderThan     string
+		minReceives   int
+		maxReceives   int
+		moveTo        string
+		sampleSize    int
+		sampleRate    float64
+		redact        redactOptions
+		maxHold       time.Duration
+		dedup         string
+		dedupStore    string
 	)
 
 	app := &cli.App{
@@ -30,7 +65,7 @@ func main() {
 		Version:              Version,
 		Usage:                "sqsdumper -s src_queue",
 		EnableBashCompletion: true,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:        "stopOnTotal",
 				Usage:       "stop when all messages processed",
@@ -63,19 +98,234 @@ func main() {
 			&cli.StringFlag{
 				Name:        "jsonPath",
 				Aliases:     []string{"jp"},
-				Usage:       "json path, like x.y, see https://github.com/sinhashubham95/jsonic for more",
+				Usage:       "json path, like x.y[0].z, a shorthand for --query .x.y[0].z --raw-output",
 				Destination: &jsonPath,
 				DefaultText: ".",
 			},
-		},
+			&cli.StringFlag{
+				Name:        "query",
+				Aliases:     []string{"q"},
+				Usage:       "jq query run against the decoded payload, $meta holds the MessageId, attributes and SNS envelope fields",
+				Destination: &query,
+			},
+			&cli.BoolFlag{
+				Name:        "raw-output",
+				Aliases:     []string{"r"},
+				Usage:       "print string query results without quotes",
+				Destination: &rawOutput,
+			},
+			&cli.StringFlag{
+				Name:        "on-error",
+				Usage:       "on processing error: skip, stop, retry=N or quarantine=<queue|file>",
+				Destination: &onError,
+				Value:       "skip",
+			},
+			&cli.Float64Flag{
+				Name:        "rate",
+				Usage:       "limit processed messages per second, 0 is unlimited",
+				Destination: &msgRate,
+			},
+			&cli.Float64Flag{
+				Name:        "api-rate",
+				Usage:       "limit SQS API calls per second, 0 is unlimited",
+				Destination: &apiRate,
+			},
+			&cli.DurationFlag{
+				Name:        "max-hold",
+				Usage:       "extend the visibility of a message being processed up to the duration, 0 disables the extension",
+				Destination: &maxHold,
+				Value:       aws.DefaultMaxHold,
+			},
+			&cli.StringFlag{
+				Name:        "dedup",
+				Usage:       "skip the messages received again in the run by: id, body (a body hash) or none",
+				Destination: &dedup,
+				Value:       string(aws.DedupMessageID),
+			},
+			&cli.StringFlag{
+				Name:        "dedup-store",
+				Usage:       "the file of the handled message keys, so repeated runs skip the messages handled already",
+				Destination: &dedupStore,
+			},
+			&cli.StringFlag{
+				Name:        "older-than",
+				Usage:       "delete only the messages sent before the RFC3339 time or the duration ago, like 168h, requires --deleteMessage",
+				Destination: &olderThan,
+			},
+			&cli.IntFlag{
+				Name:        "min-receive-count",
+				Usage:       "select the messages received at least N times, the ApproximateReceiveCount includes this receive",
+				Destination: &minReceives,
+			},
+			&cli.IntFlag{
+				Name:        "max-receive-count",
+				Usage:       "select the messages received at most N times, 0 is unlimited",
+				Destination: &maxReceives,
+			},
+			&cli.StringFlag{
+				Name:        "move-to",
+				Usage:       "send the selected messages to the queue before deleting them, implies --deleteMessage",
+				Destination: &moveTo,
+			},
+			&cli.IntFlag{
+				Name:        "sample",
+				Usage:       "print N messages picked at random in a full pass of the queue, the messages aren't consumed",
+				Destination: &sampleSize,
+			},
+			&cli.Float64Flag{
+				Name:        "sample-rate",
+				Usage:       "print each message of a full pass of the queue with the chance, like 0.01, the messages aren't consumed",
+				Destination: &sampleRate,
+			},
+			&cli.StringSliceFlag{
+				Name:        "group",
+				Usage:       "process only the messages of the FIFO queue message group, can be repeated",
+				Destination: &groups,
+			},
+			&cli.BoolFlag{
+				Name:        "s3-payload",
+				Usage:       "fetch payloads offloaded to S3 by the SQS Extended Client Library and print them in place",
+				Destination: &fetchPayload,
+			},
+			&cli.StringFlag{
+				Name:        "payload-dir",
+				Usage:       "read offloaded payloads from <dir>/<bucket>/<key> files instead of S3",
+				Destination: &payloadDir,
+			},
+			&cli.BoolFlag{
+				Name:        "delete-s3-payload",
+				Usage:       "delete an offloaded payload along with the message, requires --deleteMessage",
+				Destination: &deletePayload,
+			},
+			&cli.StringFlag{
+				Name:        "schema",
+				Usage:       "validate payloads against the JSON Schema file or the schemas of the directory selected by --schema-key",
+				Destination: &schemaPath,
+			},
+			&cli.StringFlag{
+				Name:        "schema-key",
+				Usage:       "the field selecting the <key>.json schema of the --schema directory, like sns.TopicArn or msgattr.type",
+				Destination: &schemaKey,
+				Value:       commands.DefaultSchemaKey,
+			},
+			&cli.StringFlag{
+				Name:        "invalid-output",
+				Usage:       "append the messages not matching the schema to the file as JSON lines instead of printing them",
+				Destination: &invalidPath,
+			},
+		}, append(append(append(append(append(decode.flags(), tracing.flags()...), output.flags()...), window.flags()...), transform.flags()...), redact.flags()...)...),
 		Action: func(ctx *cli.Context) error {
 			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
-			commander := commands.NewSQSDumper(commands.SQSDumperParams{
-				Logger:        l,
-				DeleteMessage: deleteMessage,
-				RawMessage:    rawMessage,
-				JsonPath:      jsonPath,
-			})
+			if moveTo != "" {
+				deleteMessage = true
+			}
+			transformer, err := transform.transformer()
+			if err != nil {
+				l.Err(err).Msg("bad transform flags")
+				return err
+			}
+			if (transformer != nil || transform.dryRun) && moveTo == "" {
+				err := errors.New("--transform, --set and --dry-run require --move-to")
+				l.Err(err).Msg("bad transform flags")
+				return err
+			}
+			if minReceives < 0 || maxReceives < 0 || (maxReceives > 0 && maxReceives < minReceives) {
+				err := errors.New("the receive counts must be positive and --max-receive-count at least --min-receive-count")
+				l.Err(err).Msg("bad receive count selection")
+				return err
+			}
+
+			sampling := sampleSize != 0 || sampleRate != 0
+			if sampleSize < 0 || sampleRate < 0 || sampleRate > 1 || (sampleSize > 0 && sampleRate > 0) {
+				err := errors.New("set either --sample to a positive size or --sample-rate between 0 and 1")
+				l.Err(err).Msg("bad sample flags")
+				return err
+			}
+			if sampling && (deleteMessage || stopAfter > 0) {
+				err := errors.New("the sample is picked in a full pass without consuming the queue, --deleteMessage, --move-to and --stopAfter can't be used")
+				l.Err(err).Msg("bad sample flags")
+				return err
+			}
+
+			errorPolicy, err := aws.ParseErrorPolicy(onError)
+			if err != nil {
+				l.Err(err).Msg("bad --on-error value")
+				return err
+			}
+			errorPolicy.Delete = deleteMessage
+
+			dedupMode, err := aws.ParseDedupMode(dedup)
+			if err != nil {
+				l.Err(err).Msg("bad --dedup value")
+				return err
+			}
+			if sampling {
+				if dedupStore != "" {
+					err = errors.New("the sample pass doesn't handle the messages, --dedup-store can't be used")
+					l.Err(err).Msg("bad sample flags")
+					return err
+				}
+				// a message received again ends the pass
+				dedupMode = aws.DedupNone
+			}
+			var store *aws.DedupStore
+			if dedupStore != "" {
+				if dedupMode == aws.DedupNone {
+					err = errors.New("--dedup-store requires --dedup id or body")
+					l.Err(err).Msg("bad --dedup-store value")
+					return err
+				}
+				if store, err = aws.OpenDedupStore(dedupStore); err != nil {
+					l.Err(err).Msg("bad --dedup-store value")
+					return err
+				}
+				defer store.Close()
+				l.Info().Int("keys", store.Len()).Msg("loaded the dedup store")
+			}
+
+			if query == "" && jsonPath != "" {
+				if query, err = commands.JSONPathQuery(jsonPath); err != nil {
+					l.Err(err).Msg("bad --jsonPath value")
+					return err
+				}
+				rawOutput = true
+			}
+			now := time.Now()
+			windowFilter, err := window.filter(now)
+			if err != nil {
+				l.Err(err).Msg("bad time window")
+				return err
+			}
+			if deletePayload && !deleteMessage {
+				err = errors.New("--delete-s3-payload requires --deleteMessage")
+				l.Err(err).Msg("bad --delete-s3-payload value")
+				return err
+			}
+			var deleteBefore time.Time
+			if olderThan != "" {
+				if !deleteMessage {
+					err = errors.New("--older-than requires --deleteMessage")
+					l.Err(err).Msg("bad --older-than value")
+					return err
+				}
+				if deleteBefore, err = aws.ParseTimeBound(olderThan, now); err != nil {
+					l.Err(err).Msg("bad --older-than value")
+					return err
+				}
+			}
+
+			if query != "" && output.isArchive() {
+				err = errors.New("the archive keeps the full messages, --query and --jsonPath can't be used with the s3:// --output")
+				l.Err(err).Msg("bad --output value")
+				return err
+			}
+			var payloadQuery *commands.Query
+			if query != "" {
+				if payloadQuery, err = commands.ParseQuery(query); err != nil {
+					l.Err(err).Msg("bad --query value")
+					return err
+				}
+			}
 
 			// Init AWS
 			client := aws.NewAWSClient()
@@ -85,38 +335,207 @@ func main() {
 				return err
 			}
 
+			tracer, flushSpans, err := tracing.tracing(ctx.Context, queueName)
+			if err != nil {
+				l.Err(err).Msg("bad --trace value")
+				return err
+			}
+			defer func() {
+				if err := flushSpans(); err != nil {
+					l.Err(err).Msg("error exporting the spans")
+				}
+			}()
+
+			dumpOutput, archive, closeOutput, err := output.open(ctx.Context, cfg, queueName, l)
+			if err != nil {
+				l.Err(err).Msg("bad --output value")
+				return err
+			}
+
+			sqsClient := tracer.WrapSQSAPI(sqs.NewFromConfig(cfg))
+			var moveQueueURL *string
+			if moveTo != "" {
+				out, err := sqsClient.GetQueueUrl(ctx.Context, &sqs.GetQueueUrlInput{QueueName: &moveTo})
+				if err != nil {
+					l.Err(err).Msg("bad --move-to value")
+					return err
+				}
+				moveQueueURL = out.QueueUrl
+			}
+
+			var payloadStore aws.PayloadStore
+			switch {
+			case payloadDir != "":
+				payloadStore = aws.NewDirPayloadStore(payloadDir)
+			case fetchPayload:
+				payloadStore = aws.NewS3PayloadStore(s3.NewFromConfig(cfg))
+			}
+
+			decoder, err := decode.decoder()
+			if err != nil {
+				l.Err(err).Msg("bad payload decoding flags")
+				return err
+			}
+
+			redactor, err := redact.redactor(ctx)
+			if err != nil {
+				l.Err(err).Msg("bad redaction flags")
+				return err
+			}
+			if redactor != nil {
+				errorPolicy.Redact = redactor.Redact
+			}
+
+			var schema *commands.SchemaValidator
+			if schemaPath != "" {
+				if schema, err = commands.NewSchemaValidator(schemaPath, schemaKey); err != nil {
+					l.Err(err).Msg("bad --schema value")
+					return err
+				}
+			}
+			var invalidOutput io.Writer
+			if invalidPath != "" {
+				f, err := os.OpenFile(invalidPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					l.Err(err).Msg("can't open the --invalid-output file")
+					return err
+				}
+				defer f.Close()
+				invalidOutput = f
+			}
+
+			commander := commands.NewSQSDumper(commands.SQSDumperParams{
+				Logger:        l,
+				DeleteMessage: deleteMessage,
+				RawMessage:    rawMessage,
+				Query:         payloadQuery,
+				RawOutput:     rawOutput,
+				PayloadStore:  payloadStore,
+				DeletePayload: deletePayload,
+				Decoder:       decoder,
+				Redactor:      redactor,
+				Schema:        schema,
+				InvalidOutput: invalidOutput,
+				Output:        dumpOutput,
+				Archive:       archive,
+				DeleteBefore:  deleteBefore,
+				MoveTo:        moveQueueURL,
+				Client:        sqsClient,
+				Transformer:   transformer,
+				DryRun:        transform.dryRun,
+			})
+
+			sampler := commands.NewSQSSampler(commands.SQSSamplerParams{Logger: l, Size: sampleSize, Rate: sampleRate})
+			var filtered aws.MessageHandler
+			if sampling {
+				filtered = sampler.HoldFiltered()
+			}
+
 			stop := true
-			if stopOnTotal != nil {
+			if stopOnTotal != nil && !sampling {
 				stop = *stopOnTotal
 			}
+			var visibility int32
+			if sampling {
+				visibility = sampleVisibility
+			}
+
+			var filters []aws.MessageFilter
+			if len(groups.Value()) > 0 {
+				filters = append(filters, aws.GroupFilter(groups.Value()))
+			}
+			if windowFilter != nil {
+				filters = append(filters, windowFilter)
+			}
+			countSelection := minReceives > 0 || maxReceives > 0
+			if countSelection {
+				filters = append(filters, aws.ReceiveCountFilter(minReceives, maxReceives))
+			}
 
 			// Init BCQueue client and run poller
 			poller, err := aws.NewSQSPoller(
 				aws.SQSParam{
-					Client: sqs.NewFromConfig(cfg),
+					Client: sqsClient,
 					Logger: l,
 					QueueConfig: aws.ConfigQueue{
 						QueueName:               queueName,
 						MaxMessagesPerRetrieval: 2,
 						WaitTimeSeconds:         2,
+						VisibilityTimeout:       visibility,
 					},
 					StopOnTotal: stop,
 					StopAfter:   stopAfter,
 
 					CounterChan: nil,
+					ErrorPolicy: errorPolicy,
+					RateLimiter: aws.NewRateLimiter(msgRate, apiRate),
+					Filters:     filters,
+					MaxHold:     maxHold,
+					Dedup:       dedupMode,
+					DedupStore:  store,
+
+					DeleteDuplicates: deleteMessage,
+					// the messages outside of the window are left to the other consumers,
+					// unless a release would raise the receive counts of the skipped ones
+					// or the sample pass would see them again, it holds them with the sampled ones
+					ReleaseFiltered: windowFilter != nil && !countSelection && !sampling,
+					FilteredHandler: filtered,
 				},
 			)
 			if err != nil {
 				l.Err(err).Msg("error creating SQS poller")
 				return err
 			}
-			total := poller.GetTotal()
+			if len(groups.Value()) > 0 && !poller.IsFIFO() {
+				err = fmt.Errorf("--group requires a FIFO queue, %s is a standard queue", queueName)
+				l.Err(err).Msg("bad --group value")
+				return err
+			}
+
+			defer func() {
+				l.Log().Msgf(" === %s", poller.GetSummary())
+				if schema != nil {
+					l.Log().Msgf(" === invalid: %d", commander.Invalid())
+				}
+			}()
+			defer func() {
+				if err := commander.Flush(); err != nil {
+					l.Err(err).Msg("error printing the grouped messages")
+				}
+			}()
+
+			defer func() {
+				if err := closeOutput(); err != nil {
+					l.Err(err).Msg("error closing the output")
+				}
+			}()
+
+			if !sampling {
+				return poller.PollMessages(ctx.Context, tracer.WrapHandler(commander.ProcessMessages(ctx.Context)))
+			}
 
 			defer func() {
-				l.Log().Msgf(" === total processed: %d", total)
+				// the context may be canceled already
+				if err := sampler.Release(context.Background(), poller); err != nil {
+					l.Err(err).Msg("error releasing the scanned messages")
+				}
 			}()
 
-			return poller.PollMessages(ctx.Context, commander.ProcessMessages(ctx.Context))
+			if err := poller.PollMessages(ctx.Context, sampler.ProcessMessages(ctx.Context)); err != nil {
+				return err
+			}
+			l.Log().Msgf(" === sampled: %d of %d seen", len(sampler.Sampled()), sampler.Seen())
+
+			return sampler.Print(poller, tracer.WrapHandler(commander.ProcessMessages(ctx.Context)))
+		},
+		Commands: []*cli.Command{
+			findCommand(),
+			reportCommand(),
+			browseCommand(),
+			forwardCommand(),
+			daemonCommand(),
+			replayCommand(),
+			diffCommand(),
 		},
 		Before: func(context *cli.Context) error {
 			return nil
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// outputOptions holds the flags of the dump output
+type outputOptions struct {
+	output        string
+	archiveDir    string
+	s3Endpoint    string
+	chunkMessages int
+	chunkAge      time.Duration
+}
+
+func (o *outputOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "output",
+			Usage:       "append the dumped messages to the file instead of stdout, or archive them to s3://bucket/prefix/ in gzip chunks",
+			Destination: &o.output,
+		},
+		&cli.StringFlag{
+			Name:        "archive-dir",
+			Usage:       "write the s3:// --output archive to <dir>/<bucket>/<key> files instead of S3",
+			Destination: &o.archiveDir,
+		},
+		&cli.StringFlag{
+			Name:        "s3-endpoint",
+			Usage:       "the URL of an S3-compatible store for the s3:// --output, like http://localhost:9000",
+			Destination: &o.s3Endpoint,
+		},
+		&cli.IntFlag{
+			Name:        "chunk-messages",
+			Usage:       "upload an archive chunk after the number of messages",
+			Destination: &o.chunkMessages,
+			Value:       commands.DefaultChunkMessages,
+		},
+		&cli.DurationFlag{
+			Name:        "chunk-age",
+			Usage:       "upload an archive chunk holding a message for the duration",
+			Destination: &o.chunkAge,
+			Value:       commands.DefaultChunkAge,
+		},
+	}
+}
+
+// isArchive reports whether the messages are archived to S3
+func (o *outputOptions) isArchive() bool {
+	_, _, ok := aws.ParseS3URL(o.output)
+	return ok
+}
+
+// open returns the output file or the archive and the func closing them, a nil output is stdout
+func (o *outputOptions) open(ctx context.Context, cfg awssdk.Config, queue string, l zerolog.Logger) (io.Writer, *commands.Archive, func() error, error) {
+	if o.output == "" {
+		return nil, nil, func() error { return nil }, nil
+	}
+
+	bucket, prefix, ok := aws.ParseS3URL(o.output)
+	if !ok {
+		f, err := os.OpenFile(o.output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "can't open the --output file")
+		}
+		return f, nil, f.Close, nil
+	}
+
+	var store aws.ObjectStore
+	if o.archiveDir != "" {
+		store = aws.NewDirObjectStore(o.archiveDir, bucket)
+	} else {
+		store = aws.NewS3ObjectStore(newS3Client(cfg, o.s3Endpoint), bucket)
+	}
+
+	archive := commands.NewArchive(commands.ArchiveParams{
+		Store:         store,
+		Prefix:        prefix,
+		Queue:         queue,
+		Logger:        l,
+		ChunkMessages: o.chunkMessages,
+		ChunkAge:      o.chunkAge,
+	})
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go archive.Watch(watchCtx)
+
+	closeArchive := func() error {
+		stopWatch()
+		// the run may be interrupted, the last chunk is uploaded anyway
+		if err := archive.Close(context.Background()); err != nil {
+			return err
+		}
+		if chunks := len(archive.Manifest().Chunks); chunks > 0 {
+			l.Log().Msgf(" === archived: %d chunks, %s%s/%s", chunks, aws.S3URLPrefix, bucket, archive.ManifestKey())
+		}
+		return nil
+	}
+
+	return nil, archive, closeArchive, nil
+}
+
+// newS3Client returns the S3 client of the endpoint, an empty endpoint is AWS
+func newS3Client(cfg awssdk.Config, endpoint string) *s3.Client {
+	return s3.NewFromConfig(cfg, func(opts *s3.Options) {
+		if endpoint != "" {
+			opts.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			// the S3-compatible stores rarely serve the bucket subdomains
+			opts.UsePathStyle = true
+		}
+	})
+}
+
+// manifestStore returns the store and the key of the s3:// or the local archive manifest,
+// archiveDir stands in for S3 like with the s3:// --output
+func manifestStore(cfg awssdk.Config, manifest, archiveDir, s3Endpoint string) (aws.ObjectStore, string) {
+	bucket, key, ok := aws.ParseS3URL(manifest)
+	switch {
+	case ok && archiveDir != "":
+		return aws.NewDirObjectStore(archiveDir, bucket), key
+	case ok:
+		return aws.NewS3ObjectStore(newS3Client(cfg, s3Endpoint), bucket), key
+	default:
+		return aws.NewDirObjectStore(filepath.Dir(manifest), ""), filepath.Base(manifest)
+	}
+}
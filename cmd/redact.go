@@ -0,0 +1,68 @@
+package main
+
+import (
+	"andboson/sqsdumper/internal/commands"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// redactOptions holds the flags of the payload redaction
+type redactOptions struct {
+	rules      cli.StringSlice
+	mode       string
+	configPath string
+	salt       string
+}
+
+func (o *redactOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "redact",
+			Usage:       "redact a payload path like body.user.email, re:<regex> matches or pattern:email|card|ipv4 matches, can be repeated",
+			Destination: &o.rules,
+		},
+		&cli.StringFlag{
+			Name:        "redact-mode",
+			Usage:       "mask or hash the redacted values, hashes keep equal values correlated",
+			Destination: &o.mode,
+			Value:       commands.RedactModeMask,
+		},
+		&cli.StringFlag{
+			Name:        "redact-config",
+			Usage:       "the YAML file with the redaction mode, salt and rules",
+			Destination: &o.configPath,
+		},
+		&cli.StringFlag{
+			Name:        "redact-salt",
+			Usage:       "the secret key of the hash redaction mode, overrides the salt of --redact-config",
+			EnvVars:     []string{"SQSDUMPER_REDACT_SALT"},
+			Destination: &o.salt,
+		},
+	}
+}
+
+// redactor returns the redactor of the config file and the --redact rules, nil without rules
+func (o *redactOptions) redactor(ctx *cli.Context) (*commands.Redactor, error) {
+	var cfg commands.RedactConfig
+	if o.configPath != "" {
+		var err error
+		if cfg, err = commands.LoadRedactConfig(o.configPath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Mode == "" || ctx.IsSet("redact-mode") {
+		cfg.Mode = o.mode
+	}
+	if o.salt != "" {
+		cfg.Salt = o.salt
+	}
+
+	for _, src := range o.rules.Value() {
+		cfg.Rules = append(cfg.Rules, commands.ParseRedactRule(src))
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	return commands.NewRedactor(cfg)
+}
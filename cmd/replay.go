@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/sinks"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+func replayCommand() *cli.Command {
+	var (
+		sink       sinkOptions
+		transform  transformOptions
+		payload    string
+		retries    int
+		backoff    time.Duration
+		resume     string
+		archiveDir string
+		s3Endpoint string
+	)
+
+	return &cli.Command{
+		Name:      "replay",
+		Usage:     "send the messages of an archive to a sink in order, verifying the chunks against the manifest",
+		UsageText: `sqsdumper replay s3://bucket/prefix/20221019T150405Z/manifest.json --to-http https://example.com/hook --resume replay.json`,
+		ArgsUsage: "<s3://bucket/key/manifest.json|manifest.json>",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "payload",
+				Usage:       "send the message body or the full record with the attributes as JSON: body or record",
+				Destination: &payload,
+				Value:       forwardPayloadBody,
+			},
+			&cli.IntFlag{
+				Name:        "retries",
+				Usage:       "retry a failed send N times",
+				Destination: &retries,
+				Value:       3,
+			},
+			&cli.DurationFlag{
+				Name:        "backoff",
+				Usage:       "the delay before the first retry, doubled every retry",
+				Destination: &backoff,
+				Value:       time.Second,
+			},
+			&cli.StringFlag{
+				Name:        "resume",
+				Usage:       "the file of the replay progress, a replay continues after the messages sent already",
+				Destination: &resume,
+			},
+			&cli.StringFlag{
+				Name:        "archive-dir",
+				Usage:       "read the s3:// manifest from <dir>/<bucket>/<key> files instead of S3",
+				Destination: &archiveDir,
+			},
+			&cli.StringFlag{
+				Name:        "s3-endpoint",
+				Usage:       "the URL of an S3-compatible store, like http://localhost:9000",
+				Destination: &s3Endpoint,
+			},
+		}, append(sink.flags(), transform.flags()...)...),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			if ctx.NArg() != 1 {
+				err := errors.New("the manifest is required")
+				l.Err(err).Msg("bad arguments")
+				return err
+			}
+			manifest := ctx.Args().First()
+
+			if payload != forwardPayloadBody && payload != forwardPayloadRecord {
+				err := errors.Errorf("unknown payload %q, use body or record", payload)
+				l.Err(err).Msg("bad --payload value")
+				return err
+			}
+			if payload == forwardPayloadRecord && sink.toSNS != "" {
+				err := errors.New("--to-sns publishes the message payload, use --payload body")
+				l.Err(err).Msg("bad --payload value")
+				return err
+			}
+
+			transformer, err := transform.transformer()
+			if err != nil {
+				l.Err(err).Msg("bad transform flags")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+
+			store, key := manifestStore(cfg, manifest, archiveDir, s3Endpoint)
+
+			// a dry run sends nothing
+			var target sinks.Sink
+			if !transform.dryRun {
+				if target, err = sink.sink(sns.NewFromConfig(cfg)); err != nil {
+					l.Err(err).Msg("bad sink flags")
+					return err
+				}
+				defer target.Close()
+			}
+
+			replayer := commands.NewReplayer(commands.ReplayParams{
+				Logger:      l,
+				Store:       store,
+				ManifestKey: key,
+				Forward: commands.SQSForwarderParams{
+					Sink:        target,
+					FullRecord:  payload == forwardPayloadRecord,
+					Unwrap:      sink.toSNS != "",
+					Retries:     retries,
+					Backoff:     backoff,
+					Transformer: transformer,
+					DryRun:      transform.dryRun,
+				},
+				ProgressPath: resume,
+			})
+
+			defer func() {
+				l.Log().Msgf(" === replayed: %d, sent before: %d", replayer.Sent(), replayer.Skipped())
+			}()
+
+			if err := replayer.Run(ctx.Context); err != nil {
+				l.Err(err).Msg("replay stopped")
+				return err
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"andboson/sqsdumper/internal/commands"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+func reportCommand() *cli.Command {
+	var (
+		scan    scanOptions
+		decode  decodeOptions
+		groupBy cli.StringSlice
+		format  string
+	)
+
+	return &cli.Command{
+		Name:      "report",
+		Usage:     "aggregate a queue's contents by the group key without consuming them",
+		UsageText: `sqsdumper report -s src_queue --group-by body.errorType,attr.SenderId`,
+		Flags: append(append(scan.flags(), decode.flags()...),
+			&cli.StringSliceFlag{
+				Name:        "group-by",
+				Usage:       "comma separated field paths like body.x.y, attr.SenderId, msgattr.type or sns.TopicArn",
+				Destination: &groupBy,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "the report format: table or json",
+				Destination: &format,
+				Value:       commands.ReportFormatTable,
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+			if format != commands.ReportFormatTable && format != commands.ReportFormatJSON {
+				err := errors.Errorf("unknown report format %q", format)
+				l.Err(err).Msg("bad --format value")
+				return err
+			}
+
+			decoder, err := decode.decoder()
+			if err != nil {
+				l.Err(err).Msg("bad payload decoding flags")
+				return err
+			}
+
+			if err := scan.check(); err != nil {
+				l.Err(err).Msg("bad --visibility value")
+				return err
+			}
+
+			// Init AWS
+			cfg, err := aws.NewAWSClient().LoadDefaultConfig(ctx.Context)
+			if err != nil {
+				l.Err(err).Msg("can't load the AWS config")
+				return err
+			}
+
+			reporter := commands.NewSQSReporter(commands.SQSReporterParams{
+				Logger:  l,
+				GroupBy: groupBy.Value(),
+				Format:  format,
+				Decoder: decoder,
+			})
+
+			poller, err := scan.newPoller(sqs.NewFromConfig(cfg), l, reporter.HoldFiltered())
+			if err != nil {
+				l.Err(err).Msg("error creating SQS poller")
+				return err
+			}
+
+			defer func() {
+				// the context may be canceled already
+				if err := reporter.Release(context.Background(), poller); err != nil {
+					l.Err(err).Msg("error releasing the scanned messages")
+				}
+			}()
+
+			if err := poller.PollMessages(ctx.Context, reporter.ProcessMessages(ctx.Context)); err != nil {
+				return err
+			}
+			l.Log().Msgf(" === scanned: %d, groups: %d", poller.GetSummary().Processed, len(reporter.Groups()))
+
+			return reporter.Print()
+		},
+	}
+}
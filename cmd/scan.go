@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	cli "github.com/urfave/cli/v2"
+)
+
+// maxVisibility is the SQS limit of the visibility timeout, 12 hours
+const maxVisibility = 43200
+
+// scanOptions holds the flags of the commands scanning a queue without consuming it
+type scanOptions struct {
+	queueName  string
+	visibility int
+	msgRate    float64
+	apiRate    float64
+	window     windowOptions
+}
+
+func (o *scanOptions) flags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "queueName",
+			Aliases:     []string{"s"},
+			Usage:       "the source queue",
+			Destination: &o.queueName,
+			Required:    true,
+		},
+	}, o.scanFlags()...)
+}
+
+// scanFlags returns the flags without the queue, for the commands taking the queues as arguments
+func (o *scanOptions) scanFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.IntFlag{
+			Name:        "visibility",
+			Usage:       "seconds to hold the scanned messages invisible, the scan stops once a message is received again",
+			Destination: &o.visibility,
+			Value:       300,
+		},
+		&cli.Float64Flag{
+			Name:        "rate",
+			Usage:       "limit scanned messages per second, 0 is unlimited",
+			Destination: &o.msgRate,
+		},
+		&cli.Float64Flag{
+			Name:        "api-rate",
+			Usage:       "limit SQS API calls per second, 0 is unlimited",
+			Destination: &o.apiRate,
+		},
+	}, o.window.flags()...)
+}
+
+// check validates the flags before the scan starts
+func (o *scanOptions) check() error {
+	if o.visibility < 1 || o.visibility > maxVisibility {
+		return errors.Errorf("--visibility must be between 1 and %d seconds, got %d", maxVisibility, o.visibility)
+	}
+
+	return nil
+}
+
+// newPoller returns a poller holding the scanned messages invisible, the messages outside of the window
+// are passed to the filtered handler, which holds them with the scanned ones, so the scan sees each message once
+// and releases them at the end
+func (o *scanOptions) newPoller(client aws.SQSAPI, l zerolog.Logger, filtered aws.MessageHandler) (aws.SQSPoller, error) {
+	var filters []aws.MessageFilter
+	filter, err := o.window.filter(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		filters = append(filters, filter)
+	}
+
+	return aws.NewSQSPoller(
+		aws.SQSParam{
+			Client: client,
+			Logger: l,
+			QueueConfig: aws.ConfigQueue{
+				QueueName:               o.queueName,
+				MaxMessagesPerRetrieval: 10,
+				WaitTimeSeconds:         2,
+				VisibilityTimeout:       int32(o.visibility),
+			},
+			StopOnTotal: true,
+			RateLimiter: aws.NewRateLimiter(o.msgRate, o.apiRate),
+			Filters:     filters,
+
+			FilteredHandler: filtered,
+		},
+	)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"andboson/sqsdumper/internal/sinks"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// snsOriginalTopic publishes a message to the SNS topic it was published to
+const snsOriginalTopic = "original"
+
+// sinkOptions holds the flags selecting the forward sink
+type sinkOptions struct {
+	toHTTP    string
+	method    string
+	headers   cli.StringSlice
+	timeout   time.Duration
+	toKafka   cli.StringSlice
+	toNATS    string
+	jetStream bool
+	toRedis   string
+	maxLen    int64
+	toSNS     string
+	topic     string
+}
+
+func (o *sinkOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "to-http",
+			Usage:       "the webhook URL, a 2xx response acknowledges the message",
+			Destination: &o.toHTTP,
+		},
+		&cli.StringFlag{
+			Name:        "method",
+			Usage:       "the webhook HTTP method",
+			Destination: &o.method,
+			Value:       http.MethodPost,
+		},
+		&cli.StringSliceFlag{
+			Name:        "header",
+			Usage:       "the webhook request header like 'Authorization: Bearer X', can be repeated",
+			Destination: &o.headers,
+		},
+		&cli.DurationFlag{
+			Name:        "timeout",
+			Usage:       "the webhook request timeout",
+			Destination: &o.timeout,
+			Value:       10 * time.Second,
+		},
+		&cli.StringSliceFlag{
+			Name:        "to-kafka",
+			Usage:       "the Kafka broker like host:9092, can be repeated",
+			Destination: &o.toKafka,
+		},
+		&cli.StringFlag{
+			Name:        "to-nats",
+			Usage:       "the NATS server URL like nats://host:4222",
+			Destination: &o.toNATS,
+		},
+		&cli.BoolFlag{
+			Name:        "jetstream",
+			Usage:       "publish to the NATS JetStream stream of the subject and await its acknowledgement",
+			Destination: &o.jetStream,
+		},
+		&cli.StringFlag{
+			Name:        "to-redis",
+			Usage:       "the Redis address like host:6379 or a redis:// URL",
+			Destination: &o.toRedis,
+		},
+		&cli.Int64Flag{
+			Name:        "maxlen",
+			Usage:       "trim the Redis stream to about N entries, 0 keeps all the entries",
+			Destination: &o.maxLen,
+		},
+		&cli.StringFlag{
+			Name:        "to-sns",
+			Usage:       "the SNS topic ARN or 'original' for the topic of the SNS envelope, publishes the envelope Message",
+			Destination: &o.toSNS,
+		},
+		&cli.StringFlag{
+			Name:        "topic",
+			Usage:       "the Kafka topic, the NATS subject or the Redis stream",
+			Destination: &o.topic,
+		},
+	}
+}
+
+// sink returns the sink selected by the flags
+func (o *sinkOptions) sink(snsClient aws.SNSAPI) (sinks.Sink, error) {
+	selected := 0
+	for _, set := range []bool{o.toHTTP != "", len(o.toKafka.Value()) > 0, o.toNATS != "", o.toRedis != "", o.toSNS != ""} {
+		if set {
+			selected++
+		}
+	}
+	switch {
+	case selected == 0:
+		return nil, errors.New("a sink is required: --to-http, --to-kafka, --to-nats, --to-redis or --to-sns")
+	case selected > 1:
+		return nil, errors.New("only one sink can be used")
+	case o.toHTTP == "" && o.toSNS == "" && o.topic == "":
+		return nil, errors.New("--topic is required")
+	}
+
+	switch {
+	case len(o.toKafka.Value()) > 0:
+		return sinks.NewKafkaSink(sinks.KafkaSinkParams{Brokers: o.toKafka.Value(), Topic: o.topic}), nil
+	case o.toNATS != "":
+		return sinks.NewNATSSink(sinks.NATSSinkParams{URL: o.toNATS, Subject: o.topic, JetStream: o.jetStream})
+	case o.toRedis != "":
+		return sinks.NewRedisSink(sinks.RedisSinkParams{Addr: o.toRedis, Stream: o.topic, MaxLen: o.maxLen})
+	case o.toSNS != "":
+		topicARN := o.toSNS
+		if topicARN == snsOriginalTopic {
+			topicARN = ""
+		}
+		return sinks.NewSNSSink(sinks.SNSSinkParams{Client: snsClient, TopicARN: topicARN, BatchSize: sinks.SNSMaxBatchSize}), nil
+	}
+
+	header := http.Header{}
+	for _, h := range o.headers.Value() {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, errors.Errorf("bad header %q, use 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return sinks.NewHTTPSink(sinks.HTTPSinkParams{
+		URL:     o.toHTTP,
+		Method:  o.method,
+		Headers: header,
+		Timeout: o.timeout,
+	}), nil
+}
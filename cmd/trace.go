@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// trace exporters
+const (
+	traceExporterOTLP = "otlp"
+	traceExporterFile = "file:"
+)
+
+// traceOptions holds the flags of the tracing
+type traceOptions struct {
+	exporter string
+}
+
+func (o *traceOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "trace",
+			Usage:       "export the spans: otlp to OTEL_EXPORTER_OTLP_ENDPOINT or file:<path> as JSON lines",
+			Destination: &o.exporter,
+		},
+	}
+}
+
+// tracing returns the tracing of the queue and the func flushing the spans, nil if tracing isn't requested
+func (o *traceOptions) tracing(ctx context.Context, queue string) (*aws.Tracing, func() error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		file     *os.File
+		err      error
+	)
+	switch {
+	case o.exporter == "":
+		return nil, func() error { return nil }, nil
+	case o.exporter == traceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case strings.HasPrefix(o.exporter, traceExporterFile):
+		if file, err = os.OpenFile(strings.TrimPrefix(o.exporter, traceExporterFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return nil, nil, errors.Wrap(err, "can't open the trace file")
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, nil, errors.Errorf("unknown trace exporter %q, use otlp or file:<path>", o.exporter)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating the trace exporter")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("sqsdumper"),
+			semconv.ServiceVersionKey.String(Version),
+		)),
+	)
+	shutdown := func() error {
+		// the context may be canceled already
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if cErr := file.Close(); err == nil {
+				err = cErr
+			}
+		}
+		return err
+	}
+
+	return aws.NewTracing(provider, queue), shutdown, nil
+}
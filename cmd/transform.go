@@ -0,0 +1,43 @@
+package main
+
+import (
+	"andboson/sqsdumper/internal/commands"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// transformOptions holds the flags of the payload transformation
+type transformOptions struct {
+	transforms cli.StringSlice
+	sets       cli.StringSlice
+	dryRun     bool
+}
+
+func (o *transformOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "transform",
+			Usage:       "rewrite the payload by a jq expression or a Go template prefixed by tmpl:, can be repeated",
+			Destination: &o.transforms,
+		},
+		&cli.StringSliceFlag{
+			Name:        "set",
+			Usage:       "set a payload field like body.status=pending, the value is a JSON literal or a bare word, can be repeated",
+			Destination: &o.sets,
+		},
+		&cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       "print the diffs of the original and the transformed payloads, nothing is sent or deleted",
+			Destination: &o.dryRun,
+		},
+	}
+}
+
+// transformer returns the payload transformer, nil if no transformation is requested
+func (o *transformOptions) transformer() (*commands.Transformer, error) {
+	if len(o.transforms.Value()) == 0 && len(o.sets.Value()) == 0 {
+		return nil, nil
+	}
+
+	return commands.NewTransformer(o.transforms.Value(), o.sets.Value())
+}
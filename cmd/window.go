@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+)
+
+// windowOptions holds the flags selecting the messages by the SentTimestamp
+type windowOptions struct {
+	since string
+	until string
+}
+
+func (o *windowOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "since",
+			Usage:       "select the messages sent since the RFC3339 time or the duration ago, like 2h",
+			Destination: &o.since,
+		},
+		&cli.StringFlag{
+			Name:        "until",
+			Usage:       "select the messages sent before the RFC3339 time or the duration ago, like 30m",
+			Destination: &o.until,
+		},
+	}
+}
+
+// filter returns the filter of the time window, nil without the window
+func (o *windowOptions) filter(now time.Time) (aws.MessageFilter, error) {
+	if o.since == "" && o.until == "" {
+		return nil, nil
+	}
+
+	var since, until time.Time
+	var err error
+	if o.since != "" {
+		if since, err = aws.ParseTimeBound(o.since, now); err != nil {
+			return nil, errors.Wrap(err, "bad --since value")
+		}
+	}
+	if o.until != "" {
+		if until, err = aws.ParseTimeBound(o.until, now); err != nil {
+			return nil, errors.Wrap(err, "bad --until value")
+		}
+	}
+	if !since.IsZero() && !until.IsZero() && !since.Before(until) {
+		return nil, errors.New("--since must be before --until")
+	}
+
+	return aws.TimeWindowFilter(since, until), nil
+}
@@ -0,0 +1,293 @@
+package commands
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// ArchiveManifestName is the manifest object of an archive run
+const ArchiveManifestName = "manifest.json"
+
+// archive defaults
+const (
+	DefaultChunkMessages = 10000
+	// DefaultChunkAge keeps the deletes of a chunk within the default visibility timeout of 30s
+	DefaultChunkAge = 20 * time.Second
+)
+
+// ArchiveManifest lists the uploaded chunks of an archive run in order
+type ArchiveManifest struct {
+	Queue   string    `json:"queue"`
+	Started time.Time `json:"started"`
+	// Redacted marks a run archived with the redaction rules, its masked payloads aren't replayed
+	Redacted bool           `json:"redacted,omitempty"`
+	Chunks   []ArchiveChunk `json:"chunks"`
+}
+
+// ArchiveChunk is a gzipped JSON lines object of the ForwardedMessage records
+type ArchiveChunk struct {
+	// Name is the object key relative to the manifest
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Bytes    int    `json:"bytes"`
+	// SHA256 is the hex checksum of the compressed object
+	SHA256 string `json:"sha256"`
+}
+
+// ArchiveParams holds the archive settings
+type ArchiveParams struct {
+	Store aws.ObjectStore
+	// Prefix is the key prefix of the run directories, like dumps/
+	Prefix string
+	Queue  string
+	Logger zerolog.Logger
+	// ChunkMessages rotates the chunk after the number of messages, 0 is DefaultChunkMessages
+	ChunkMessages int
+	// ChunkAge rotates the chunk holding a message for longer with the next message, 0 is DefaultChunkAge
+	ChunkAge time.Duration
+}
+
+// Archive writes the messages to the rotated gzip chunks of the <prefix><run>/ directory
+// and keeps the manifest of the run up to date, the callbacks of a chunk's messages,
+// like their deletes, are run only after the chunk and the manifest are uploaded
+type Archive struct {
+	store         aws.ObjectStore
+	dir           string
+	logger        zerolog.Logger
+	chunkMessages int
+	chunkAge      time.Duration
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	gz       *gzip.Writer
+	count    int
+	opened   time.Time
+	pending  []archivedCallback
+	manifest ArchiveManifest
+}
+
+// archivedCallback is run after the chunk of the message is uploaded, release ends the hold of the message
+type archivedCallback struct {
+	uploaded func(ctx context.Context) error
+	release  func()
+}
+
+// NewArchive returns a new archive run
+func NewArchive(p ArchiveParams) *Archive {
+	started := time.Now().UTC()
+	a := &Archive{
+		store:         p.Store,
+		dir:           p.Prefix + started.Format("20060102T150405Z") + "/",
+		logger:        p.Logger,
+		chunkMessages: p.ChunkMessages,
+		chunkAge:      p.ChunkAge,
+		manifest:      ArchiveManifest{Queue: p.Queue, Started: started, Chunks: []ArchiveChunk{}},
+	}
+	if a.chunkMessages <= 0 {
+		a.chunkMessages = DefaultChunkMessages
+	}
+	if a.chunkAge <= 0 {
+		a.chunkAge = DefaultChunkAge
+	}
+	a.gz = gzip.NewWriter(&a.buf)
+
+	return a
+}
+
+// ManifestKey returns the key of the run manifest
+func (a *Archive) ManifestKey() string {
+	return a.dir + ArchiveManifestName
+}
+
+// Manifest returns the manifest of the uploaded chunks
+func (a *Archive) Manifest() ArchiveManifest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.manifest
+}
+
+// markRedacted records the redaction of the archived payloads in the manifest
+func (a *Archive) markRedacted() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.manifest.Redacted = true
+}
+
+// Add writes the message record to the current chunk, uploaded is called after the chunk upload, nil skips it,
+// release is called after uploaded or once the chunk can't be uploaded on Close, nil skips it;
+// a failed upload is only logged once the record is in the chunk, the next Add or Watch retries it
+func (a *Archive) Add(ctx context.Context, msg types.Message, uploaded func(ctx context.Context) error, release func()) error {
+	line, err := json.Marshal(ForwardedMessage{
+		MessageID:         aws.MessageID(msg),
+		Body:              aws.MessageBody(msg),
+		Attributes:        msg.Attributes,
+		MessageAttributes: msg.MessageAttributes,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error marshaling the message")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.count == 0 {
+		a.opened = time.Now()
+	}
+	if _, err := a.gz.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "error compressing the message")
+	}
+	a.count++
+	if uploaded != nil || release != nil {
+		a.pending = append(a.pending, archivedCallback{uploaded: uploaded, release: release})
+	}
+
+	if a.count < a.chunkMessages && time.Since(a.opened) < a.chunkAge {
+		return nil
+	}
+	if err := a.rotate(ctx); err != nil {
+		a.logger.Err(err).Msg("error uploading the archive chunk")
+	}
+
+	return nil
+}
+
+// Watch uploads the chunk holding a message for longer than the chunk age until ctx is done,
+// so the messages of an idle queue aren't held past their visibility timeout
+func (a *Archive) Watch(ctx context.Context) {
+	ticker := time.NewTicker(a.chunkAge / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		a.mu.Lock()
+		if a.count > 0 && time.Since(a.opened) >= a.chunkAge {
+			if err := a.rotate(ctx); err != nil {
+				a.logger.Err(err).Msg("error uploading the archive chunk")
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// Close uploads the last chunk, the messages of a chunk which can't be uploaded are released
+// and stay in the queue
+func (a *Archive) Close(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.count == 0 {
+		return nil
+	}
+	if err := a.rotate(ctx); err != nil {
+		for _, callback := range a.pending {
+			if callback.release != nil {
+				callback.release()
+			}
+		}
+		a.pending = nil
+		return err
+	}
+
+	return nil
+}
+
+// rotate uploads the chunk and the manifest listing it, then runs the callbacks of the chunk,
+// a failed upload keeps the chunk to be retried by the next rotation
+func (a *Archive) rotate(ctx context.Context) error {
+	if err := a.gz.Close(); err != nil {
+		return errors.Wrap(err, "error compressing the chunk")
+	}
+	body := a.buf.Bytes()
+	sum := sha256.Sum256(body)
+	chunk := ArchiveChunk{
+		Name:     fmt.Sprintf("chunk-%05d.jsonl.gz", len(a.manifest.Chunks)+1),
+		Messages: a.count,
+		Bytes:    len(body),
+		SHA256:   hex.EncodeToString(sum[:]),
+	}
+	if err := a.store.Put(ctx, a.dir+chunk.Name, body); err != nil {
+		return a.reopen(err)
+	}
+
+	manifest := a.manifest
+	manifest.Chunks = append(manifest.Chunks[:len(manifest.Chunks):len(manifest.Chunks)], chunk)
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshaling the manifest")
+	}
+	if err := a.store.Put(ctx, a.ManifestKey(), b); err != nil {
+		return a.reopen(err)
+	}
+	a.manifest = manifest
+
+	pending := a.pending
+	a.buf.Reset()
+	a.gz.Reset(&a.buf)
+	a.count = 0
+	a.pending = nil
+
+	for _, callback := range pending {
+		// the message is archived already, so a failure leaves it in the queue only
+		if callback.uploaded != nil {
+			if err := callback.uploaded(ctx); err != nil {
+				a.logger.Err(err).Str("chunk", chunk.Name).Msg("error completing the archived message")
+			}
+		}
+		if callback.release != nil {
+			callback.release()
+		}
+	}
+
+	return nil
+}
+
+// reopen restores the compressed chunk after a failed upload, so the next messages are appended to it
+func (a *Archive) reopen(cause error) error {
+	content, err := gunzip(a.buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "error reopening the chunk")
+	}
+
+	a.buf.Reset()
+	a.gz.Reset(&a.buf)
+	if _, err := a.gz.Write(content); err != nil {
+		return errors.Wrap(err, "error reopening the chunk")
+	}
+
+	return cause
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
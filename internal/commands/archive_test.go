@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyStore fails the first puts
+type flakyStore struct {
+	aws.ObjectStore
+	failures int
+}
+
+func (s *flakyStore) Put(ctx context.Context, key string, body []byte) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("slow down")
+	}
+	return s.ObjectStore.Put(ctx, key, body)
+}
+
+func archivedMessage(id string) types.Message {
+	return types.Message{
+		MessageId: ptr.String(id),
+		Body:      ptr.String(`{"orderId":"` + id + `"}`),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp): "1666000000000",
+		},
+	}
+}
+
+func readArchive(t *testing.T, dir string, archive *Archive) (ArchiveManifest, [][]string) {
+	manifestPath := filepath.Join(dir, "bucket", filepath.FromSlash(archive.ManifestKey()))
+	b, err := os.ReadFile(manifestPath)
+	assert.NoError(t, err)
+	var manifest ArchiveManifest
+	assert.NoError(t, json.Unmarshal(b, &manifest))
+
+	var chunks [][]string
+	for _, chunk := range manifest.Chunks {
+		body, err := os.ReadFile(filepath.Join(filepath.Dir(manifestPath), chunk.Name))
+		assert.NoError(t, err)
+		sum := sha256.Sum256(body)
+		assert.Equal(t, hex.EncodeToString(sum[:]), chunk.SHA256)
+		assert.Equal(t, len(body), chunk.Bytes)
+
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		assert.NoError(t, err)
+		var ids []string
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var record ForwardedMessage
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			ids = append(ids, record.MessageID)
+		}
+		assert.Equal(t, chunk.Messages, len(ids))
+		chunks = append(chunks, ids)
+	}
+
+	return manifest, chunks
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	archive := NewArchive(ArchiveParams{
+		Store:         aws.NewDirObjectStore(dir, "bucket"),
+		Prefix:        "dumps/",
+		Queue:         "orders-dlq",
+		Logger:        log,
+		ChunkMessages: 2,
+	})
+	assert.Regexp(t, `^dumps/\d{8}T\d{6}Z/manifest.json$`, archive.ManifestKey())
+
+	var deleted []string
+	deleteFn := func(id string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			deleted = append(deleted, id)
+			return nil
+		}
+	}
+
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#1"), deleteFn("#1"), nil))
+	assert.Empty(t, deleted, "deleted before the upload")
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#2"), deleteFn("#2"), nil))
+	assert.Equal(t, []string{"#1", "#2"}, deleted)
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#3"), nil, nil))
+	assert.NoError(t, archive.Close(ctx))
+
+	manifest, chunks := readArchive(t, dir, archive)
+	assert.Equal(t, "orders-dlq", manifest.Queue)
+	assert.Equal(t, []string{"chunk-00001.jsonl.gz", "chunk-00002.jsonl.gz"},
+		[]string{manifest.Chunks[0].Name, manifest.Chunks[1].Name})
+	assert.Equal(t, [][]string{{"#1", "#2"}, {"#3"}}, chunks)
+	assert.Equal(t, manifest, archive.Manifest())
+}
+
+func TestArchive_failedUpload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := &flakyStore{ObjectStore: aws.NewDirObjectStore(dir, "bucket"), failures: 1}
+	archive := NewArchive(ArchiveParams{Store: store, Logger: log, ChunkMessages: 1})
+
+	deleted, released := 0, 0
+	deleteFn := func(ctx context.Context) error {
+		deleted++
+		return nil
+	}
+	// the message is held until it's deleted
+	release := func() {
+		assert.Equal(t, deleted, released+1)
+		released++
+	}
+
+	// the record is in the chunk, so the message isn't handled again
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#1"), deleteFn, release))
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 0, released)
+	// the chunk is kept and uploaded with the next message
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#2"), deleteFn, release))
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 2, released)
+
+	_, chunks := readArchive(t, dir, archive)
+	assert.Equal(t, [][]string{{"#1", "#2"}}, chunks)
+
+	t.Run("not uploaded on close", func(t *testing.T) {
+		store := &flakyStore{ObjectStore: aws.NewDirObjectStore(t.TempDir(), "bucket"), failures: 1}
+		archive := NewArchive(ArchiveParams{Store: store, Logger: log})
+
+		released := false
+		assert.NoError(t, archive.Add(ctx, archivedMessage("#1"), func(ctx context.Context) error {
+			assert.Fail(t, "deleted without the upload")
+			return nil
+		}, func() { released = true }))
+		// the message is released and stays in the queue
+		assert.EqualError(t, archive.Close(ctx), "slow down")
+		assert.True(t, released)
+	})
+}
+
+func TestSQSDumper_ProcessMessagesArchive(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	dir := t.TempDir()
+
+	archive := NewArchive(ArchiveParams{Store: aws.NewDirObjectStore(dir, "bucket"), Logger: log})
+	redactor, err := NewRedactor(RedactConfig{Rules: []RedactRule{ParseRedactRule("body.email")}})
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	dumper := NewSQSDumper(SQSDumperParams{
+		Logger:        log,
+		DeleteMessage: true,
+		Redactor:      redactor,
+		Output:        &out,
+		Archive:       archive,
+	})
+	msg := types.Message{
+		MessageId:     ptr.String("#1"),
+		ReceiptHandle: ptr.String("rh-1"),
+		Body:          ptr.String(`{"email":"john@example.com"}`),
+	}
+
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+	released := false
+	poller.EXPECT().Hold(gomock.Any(), msg).Return(func() { released = true })
+	assert.NoError(t, dumper.ProcessMessages(ctx)(poller, msg))
+	// the message is held until its chunk is uploaded
+	assert.False(t, released)
+
+	// the message is deleted after the upload only
+	poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+	poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+	assert.NoError(t, archive.Close(ctx))
+	assert.True(t, released)
+
+	assert.Empty(t, out.String())
+	manifestPath := filepath.Join(dir, "bucket", filepath.FromSlash(archive.ManifestKey()))
+	body, err := os.ReadFile(filepath.Join(filepath.Dir(manifestPath), archive.Manifest().Chunks[0].Name))
+	assert.NoError(t, err)
+	content, err := gunzip(body)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "john@example.com")
+	// the redaction is recorded, so the archive isn't replayed
+	assert.True(t, archive.Manifest().Redacted)
+}
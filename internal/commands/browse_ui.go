@@ -0,0 +1,362 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pkg/errors"
+)
+
+// browse UI prompts
+const (
+	promptDelete = "delete"
+	promptMove   = "move"
+	promptSave   = "save"
+)
+
+const browseHelp = "↑/↓ select · d delete · m move · s save · r release · q quit"
+
+// messagesUpdated is sent when the held messages change
+type messagesUpdated struct{}
+
+// pollDone is sent when the background polling is over
+type pollDone struct {
+	err error
+}
+
+// actionDone is the result of a message action
+type actionDone struct {
+	status string
+	err    error
+}
+
+// browseModel is the terminal UI listing the held messages with the selected message details
+type browseModel struct {
+	ctx      context.Context
+	browser  *SQSBrowser
+	messages []types.Message
+	previews map[string]string
+	cursor   int
+	width    int
+	height   int
+	prompt   string
+	input    string
+	status   string
+}
+
+// Browse runs the terminal UI until it is quit, poll receives the messages in the background
+func (b *SQSBrowser) Browse(ctx context.Context, poll func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	program := tea.NewProgram(newBrowseModel(ctx, b), tea.WithAltScreen(), tea.WithContext(ctx))
+	go func() {
+		program.Send(pollDone{err: poll(ctx)})
+	}()
+	go b.KeepHeld(ctx)
+
+	_, err := program.Run()
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+func newBrowseModel(ctx context.Context, browser *SQSBrowser) browseModel {
+	return browseModel{
+		ctx:      ctx,
+		browser:  browser,
+		previews: map[string]string{},
+		width:    120,
+		height:   40,
+		status:   "receiving messages..",
+	}
+}
+
+func (m browseModel) Init() tea.Cmd {
+	return m.waitUpdates()
+}
+
+func (m browseModel) waitUpdates() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-m.browser.Updates():
+			return messagesUpdated{}
+		}
+	}
+}
+
+func (m browseModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case messagesUpdated:
+		m.setMessages(m.browser.Messages())
+		return m, m.waitUpdates()
+	case pollDone:
+		m.status = fmt.Sprintf("received %d messages", len(m.messages))
+		if msg.err != nil && m.ctx.Err() == nil {
+			m.status = "error receiving messages: " + msg.err.Error()
+		}
+	case actionDone:
+		m.status = msg.status
+		if msg.err != nil {
+			m.status = "error: " + msg.err.Error()
+		}
+	case tea.KeyMsg:
+		if m.prompt != "" {
+			return m.updatePrompt(msg)
+		}
+		return m.updateList(msg)
+	}
+
+	return m, nil
+}
+
+func (m browseModel) updateList(key tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch key.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(m.messages)-1 {
+			m.cursor++
+		}
+	}
+
+	id, ok := m.selected()
+	if !ok {
+		return m, nil
+	}
+
+	switch key.String() {
+	case "d":
+		m.prompt = promptDelete
+	case "m":
+		m.prompt, m.input = promptMove, ""
+	case "s":
+		m.prompt, m.input = promptSave, id+".json"
+	case "r":
+		return m, m.action(func() (string, error) {
+			return "released " + id, m.browser.Release(m.ctx, id)
+		})
+	}
+
+	return m, nil
+}
+
+func (m browseModel) updatePrompt(key tea.KeyMsg) (tea.Model, tea.Cmd) {
+	id, _ := m.selected()
+	prompt, input := m.prompt, m.input
+
+	if prompt == promptDelete {
+		m.prompt = ""
+		if key.String() != "y" {
+			return m, nil
+		}
+		return m, m.action(func() (string, error) {
+			return "deleted " + id, m.browser.Delete(m.ctx, id)
+		})
+	}
+
+	switch key.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.prompt = ""
+	case tea.KeyBackspace:
+		if len(m.input) > 0 {
+			runes := []rune(m.input)
+			m.input = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.input += string(key.Runes)
+	case tea.KeyEnter:
+		m.prompt = ""
+		if input == "" {
+			return m, nil
+		}
+		if prompt == promptMove {
+			return m, m.action(func() (string, error) {
+				return fmt.Sprintf("moved %s to %s", id, input), m.browser.Move(m.ctx, id, input)
+			})
+		}
+		return m, m.action(func() (string, error) {
+			return fmt.Sprintf("saved %s to %s", id, input), m.browser.Save(id, input)
+		})
+	}
+
+	return m, nil
+}
+
+func (m browseModel) action(run func() (string, error)) tea.Cmd {
+	return func() tea.Msg {
+		status, err := run()
+		return actionDone{status: status, err: err}
+	}
+}
+
+func (m *browseModel) setMessages(messages []types.Message) {
+	selected, _ := m.selected()
+	m.messages = messages
+
+	// keep the selection when the list changes
+	if m.cursor >= len(messages) {
+		m.cursor = len(messages) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	for i, msg := range messages {
+		if aws.MessageID(msg) == selected {
+			m.cursor = i
+		}
+	}
+}
+
+func (m browseModel) selected() (string, bool) {
+	if m.cursor >= len(m.messages) {
+		return "", false
+	}
+
+	return aws.MessageID(m.messages[m.cursor]), true
+}
+
+func (m browseModel) View() string {
+	var view strings.Builder
+
+	fmt.Fprintf(&view, "%d held messages · %s\n", len(m.messages), browseHelp)
+	view.WriteString(strings.Repeat("─", m.width) + "\n")
+
+	// the list takes up to the half of the screen around the cursor
+	rows := m.height/2 - 2
+	if rows < 1 {
+		rows = 1
+	}
+	first := m.cursor - rows/2
+	if first > len(m.messages)-rows {
+		first = len(m.messages) - rows
+	}
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i < len(m.messages) && i < first+rows; i++ {
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		view.WriteString(truncate(cursor+m.row(m.messages[i]), m.width) + "\n")
+	}
+
+	view.WriteString(strings.Repeat("─", m.width) + "\n")
+	if m.cursor < len(m.messages) {
+		details := strings.Split(messageDetails(m.messages[m.cursor]), "\n")
+		for i, line := range details {
+			if i >= m.height-rows-5 {
+				view.WriteString("…\n")
+				break
+			}
+			view.WriteString(truncate(line, m.width) + "\n")
+		}
+	}
+
+	view.WriteString(strings.Repeat("─", m.width) + "\n")
+	switch m.prompt {
+	case promptDelete:
+		view.WriteString("delete the message? y/n")
+	case promptMove:
+		view.WriteString("move to the queue: " + m.input + "█")
+	case promptSave:
+		view.WriteString("save to the file: " + m.input + "█")
+	default:
+		view.WriteString(m.status)
+	}
+
+	return view.String()
+}
+
+// row returns the list row: MessageId, SentTimestamp, receive count and the payload preview
+func (m browseModel) row(msg types.Message) string {
+	id := aws.MessageID(msg)
+	sent := "-"
+	if t, ok := aws.SentTimestamp(msg); ok {
+		sent = t.Format("2006-01-02 15:04:05")
+	}
+	receives := "-"
+	if count, ok := aws.ReceiveCount(msg); ok {
+		receives = fmt.Sprint(count)
+	}
+
+	preview, ok := m.previews[id]
+	if !ok {
+		preview = payloadPreview(msg)
+		m.previews[id] = preview
+	}
+
+	return fmt.Sprintf("%-36s  %s  %2s  %s", id, sent, receives, preview)
+}
+
+// payloadPreview returns the payload on a single line
+func payloadPreview(msg types.Message) string {
+	payload := newMessageFields(msg).Payload()
+	if s, ok := payload.(string); ok {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	b, _ := json.Marshal(payload)
+	return string(b)
+}
+
+// messageDetails returns the pretty-printed payload and the attributes
+func messageDetails(msg types.Message) string {
+	saved := newSavedMessage(msg)
+
+	var details strings.Builder
+	details.WriteString("MessageId: " + saved.MessageID + "\n")
+	for _, name := range sortedKeys(saved.Attributes) {
+		fmt.Fprintf(&details, "%s: %s\n", name, saved.Attributes[name])
+	}
+	for _, name := range sortedKeys(saved.MessageAttributes) {
+		fmt.Fprintf(&details, "msgattr.%s: %v\n", name, saved.MessageAttributes[name])
+	}
+	details.WriteString("\n")
+
+	if s, ok := saved.Message.(string); ok {
+		details.WriteString(s)
+		return details.String()
+	}
+	b, _ := json.MarshalIndent(saved.Message, "", "  ")
+	details.Write(b)
+
+	return details.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if width <= 0 || len(runes) <= width {
+		return s
+	}
+
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go/ptr"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrowseModel_Update(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+	poller.EXPECT().GetQueueURL().Return(ptr.String("url")).AnyTimes()
+	browser := NewSQSBrowser(SQSBrowserParams{Logger: log, Poller: poller})
+	handler := browser.ProcessMessages(ctx)
+	for _, id := range []string{"#1", "#2", "#3"} {
+		assert.NoError(t, handler(poller, browsedMessage(id)))
+	}
+
+	var model tea.Model = newBrowseModel(ctx, browser)
+	// update runs the model update and the resulting command as the program does,
+	// except for waiting for the list updates which are sent explicitly
+	var update func(msg tea.Msg)
+	update = func(msg tea.Msg) {
+		var cmd tea.Cmd
+		model, cmd = model.Update(msg)
+		if _, ok := msg.(messagesUpdated); ok || cmd == nil {
+			return
+		}
+		if next := cmd(); next != nil {
+			update(next)
+		}
+	}
+	keys := func(s string) tea.KeyMsg {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+	}
+
+	update(model.Init()())
+	update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	view := model.View()
+	assert.Contains(t, view, "3 held messages")
+	assert.Contains(t, view, `> #1`)
+	assert.Contains(t, view, `2020-09-13 12:26:41   2  {"orderId":"#1"}`)
+	assert.Contains(t, view, "\"orderId\": \"#1\"")
+
+	// select #2 and delete it
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	assert.Contains(t, model.View(), `> #2`)
+	poller.EXPECT().DeleteMessage(gomock.Any(), &sqs.DeleteMessageInput{
+		QueueUrl:      ptr.String("url"),
+		ReceiptHandle: ptr.String("rh-#2"),
+	}).Return(&sqs.DeleteMessageOutput{}, nil)
+	update(keys("d"))
+	assert.Contains(t, model.View(), "delete the message? y/n")
+	update(keys("y"))
+	assert.Contains(t, model.View(), "deleted #2")
+	update(messagesUpdated{})
+	view = model.View()
+	assert.Contains(t, view, "2 held messages")
+	assert.Contains(t, view, `> #3`)
+
+	// canceled delete
+	update(keys("d"))
+	update(keys("n"))
+	assert.Contains(t, model.View(), "2 held messages")
+
+	// save the selected message
+	path := filepath.Join(t.TempDir(), "saved.json")
+	update(keys("s"))
+	assert.Contains(t, model.View(), "save to the file: #3.json")
+	for range "#3.json" {
+		update(tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	update(keys(path))
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Contains(t, model.View(), "saved #3 to "+path)
+	assert.FileExists(t, path)
+
+	// release
+	poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+	update(keys("r"))
+	assert.Contains(t, model.View(), "released #3")
+
+	// an empty target queue is ignored
+	update(keys("m"))
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.NotContains(t, model.View(), "move to the queue")
+
+	_, cmd := model.Update(keys("q"))
+	assert.Equal(t, tea.Quit(), cmd())
+}
@@ -0,0 +1,292 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SQSBrowserParams holds SQSBrowser params
+type SQSBrowserParams struct {
+	Logger zerolog.Logger
+	// Client sends the moved messages
+	Client aws.SQSAPI
+	Poller aws.SQSPoller
+	// Limit stops receiving after N held messages
+	Limit int
+	// Visibility is the visibility timeout the held messages are extended to
+	Visibility time.Duration
+}
+
+// SavedMessage is a message saved to a file while browsing
+type SavedMessage struct {
+	MessageID         string                 `json:"MessageId"`
+	Attributes        map[string]string      `json:"Attributes,omitempty"`
+	MessageAttributes map[string]interface{} `json:"MessageAttributes,omitempty"`
+	Message           interface{}            `json:"Message"`
+}
+
+// SQSBrowser holds the received messages invisible while they are browsed and triaged,
+// a message leaves the list when it is deleted, moved or released
+type SQSBrowser struct {
+	logger     zerolog.Logger
+	client     aws.SQSAPI
+	poller     aws.SQSPoller
+	limit      int
+	visibility time.Duration
+	queueURLs  map[string]*string
+	updates    chan struct{}
+
+	mu       sync.Mutex
+	messages []types.Message
+}
+
+// NewSQSBrowser returns a new instance
+func NewSQSBrowser(p SQSBrowserParams) *SQSBrowser {
+	return &SQSBrowser{
+		logger:     p.Logger,
+		client:     p.Client,
+		poller:     p.Poller,
+		limit:      p.Limit,
+		visibility: p.Visibility,
+		queueURLs:  map[string]*string{},
+		updates:    make(chan struct{}, 1),
+	}
+}
+
+// ProcessMessages returns aws.MessageHandler type func which adds the incoming message to the list
+func (b *SQSBrowser) ProcessMessages(_ context.Context) aws.MessageHandler {
+	return func(_ aws.SQSPoller, msg types.Message) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if i := b.index(aws.MessageID(msg)); i >= 0 {
+			// the hold has expired, keep the latest receipt handle
+			b.messages[i] = msg
+		} else {
+			b.messages = append(b.messages, msg)
+		}
+		b.notify()
+
+		if b.limit > 0 && len(b.messages) >= b.limit {
+			return aws.ErrStopPolling
+		}
+
+		return nil
+	}
+}
+
+// Updates notifies about the list changes
+func (b *SQSBrowser) Updates() <-chan struct{} {
+	return b.updates
+}
+
+// Messages returns the held messages in the receive order
+func (b *SQSBrowser) Messages() []types.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]types.Message(nil), b.messages...)
+}
+
+// Delete deletes the message from the queue
+func (b *SQSBrowser) Delete(ctx context.Context, id string) error {
+	msg, err := b.message(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.poller.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      b.poller.GetQueueURL(),
+		ReceiptHandle: msg.ReceiptHandle,
+	}); err != nil {
+		return errors.Wrap(err, "error deleting the message")
+	}
+	b.remove(id)
+
+	return nil
+}
+
+// Move sends the message to the queue and deletes it from the browsed one
+func (b *SQSBrowser) Move(ctx context.Context, id, queueName string) error {
+	msg, err := b.message(id)
+	if err != nil {
+		return err
+	}
+
+	queueURL, err := b.queueURL(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.client.SendMessage(ctx, aws.NewSendMessageInput(queueURL, msg)); err != nil {
+		return errors.Wrapf(err, "error sending the message to %s", queueName)
+	}
+
+	return b.Delete(ctx, id)
+}
+
+// Save writes the message with the decoded payload to the file, the message stays held
+func (b *SQSBrowser) Save(id, path string) error {
+	msg, err := b.message(id)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(newSavedMessage(msg), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshaling the message")
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "error saving the message")
+	}
+
+	return nil
+}
+
+// Release makes the message visible again
+func (b *SQSBrowser) Release(ctx context.Context, id string) error {
+	msg, err := b.message(id)
+	if err != nil {
+		return err
+	}
+
+	if err := b.changeVisibility(ctx, msg, 0); err != nil {
+		return errors.Wrap(err, "error releasing the message")
+	}
+	b.remove(id)
+
+	return nil
+}
+
+// ReleaseAll makes all the held messages visible again
+func (b *SQSBrowser) ReleaseAll(ctx context.Context) error {
+	var failed int
+	for _, msg := range b.Messages() {
+		if err := b.Release(ctx, aws.MessageID(msg)); err != nil {
+			failed++
+			b.logger.Err(err).Str("messageId", aws.MessageID(msg)).Msg("error releasing the message")
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d messages are not released and stay invisible until the visibility timeout", failed)
+	}
+
+	return nil
+}
+
+// KeepHeld extends the visibility of the held messages every half of the visibility timeout until ctx is done
+func (b *SQSBrowser) KeepHeld(ctx context.Context) {
+	if b.visibility <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.visibility / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, msg := range b.Messages() {
+				if err := b.changeVisibility(ctx, msg, b.visibility); err != nil {
+					b.logger.Err(err).Str("messageId", aws.MessageID(msg)).Msg("error extending the message visibility")
+				}
+			}
+		}
+	}
+}
+
+func (b *SQSBrowser) queueURL(ctx context.Context, queueName string) (*string, error) {
+	b.mu.Lock()
+	queueURL, ok := b.queueURLs[queueName]
+	b.mu.Unlock()
+	if ok {
+		return queueURL, nil
+	}
+
+	out, err := b.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: &queueName})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting the %s queue URL", queueName)
+	}
+
+	b.mu.Lock()
+	b.queueURLs[queueName] = out.QueueUrl
+	b.mu.Unlock()
+
+	return out.QueueUrl, nil
+}
+
+func (b *SQSBrowser) changeVisibility(ctx context.Context, msg types.Message, timeout time.Duration) error {
+	_, err := b.poller.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          b.poller.GetQueueURL(),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+
+	return err
+}
+
+func (b *SQSBrowser) message(id string) (types.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.index(id)
+	if i < 0 {
+		return types.Message{}, errors.Errorf("the message %s is not held", id)
+	}
+
+	return b.messages[i], nil
+}
+
+func (b *SQSBrowser) remove(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if i := b.index(id); i >= 0 {
+		b.messages = append(b.messages[:i], b.messages[i+1:]...)
+		b.notify()
+	}
+}
+
+// index returns the message position, -1 if it isn't held, the lock must be held
+func (b *SQSBrowser) index(id string) int {
+	for i, msg := range b.messages {
+		if aws.MessageID(msg) == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// notify signals the list change without blocking, the lock must be held
+func (b *SQSBrowser) notify() {
+	select {
+	case b.updates <- struct{}{}:
+	default:
+	}
+}
+
+func newSavedMessage(msg types.Message) SavedMessage {
+	fields := newMessageFields(msg)
+	meta := fields.Meta()
+	msgAttrs, _ := meta["MessageAttributes"].(map[string]interface{})
+
+	return SavedMessage{
+		MessageID:         aws.MessageID(msg),
+		Attributes:        msg.Attributes,
+		MessageAttributes: msgAttrs,
+		Message:           fields.Payload(),
+	}
+}
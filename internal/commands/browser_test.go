@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mock_apis "andboson/sqsdumper/internal/mocks/mock_aws"
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func browsedMessage(id string) types.Message {
+	return types.Message{
+		MessageId:     ptr.String(id),
+		ReceiptHandle: ptr.String("rh-" + id),
+		Body:          ptr.String(`{"orderId":"` + id + `"}`),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "2",
+			string(types.MessageSystemAttributeNameSentTimestamp):           "1600000001000",
+		},
+	}
+}
+
+func messageIDs(messages []types.Message) []string {
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, aws.MessageID(msg))
+	}
+
+	return ids
+}
+
+func TestSQSBrowser_ProcessMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	browser := NewSQSBrowser(SQSBrowserParams{Logger: log, Poller: poller, Limit: 2})
+	handler := browser.ProcessMessages(ctx)
+
+	assert.NoError(t, handler(poller, browsedMessage("#1")))
+	<-browser.Updates()
+
+	// received again, the receipt handle is updated
+	again := browsedMessage("#1")
+	again.ReceiptHandle = ptr.String("rh-again")
+	assert.NoError(t, handler(poller, again))
+
+	assert.ErrorIs(t, handler(poller, browsedMessage("#2")), aws.ErrStopPolling)
+	assert.Equal(t, []string{"#1", "#2"}, messageIDs(browser.Messages()))
+	assert.Equal(t, "rh-again", *browser.Messages()[0].ReceiptHandle)
+}
+
+func TestSQSBrowser_Actions(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	newBrowser := func(poller aws.SQSPoller, client aws.SQSAPI) *SQSBrowser {
+		browser := NewSQSBrowser(SQSBrowserParams{Logger: log, Client: client, Poller: poller})
+		handler := browser.ProcessMessages(ctx)
+		for _, id := range []string{"#1", "#2", "#3"} {
+			assert.NoError(t, handler(poller, browsedMessage(id)))
+		}
+		return browser
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		poller.EXPECT().DeleteMessage(gomock.Any(), &sqs.DeleteMessageInput{
+			QueueUrl:      ptr.String("url"),
+			ReceiptHandle: ptr.String("rh-#2"),
+		}).Return(&sqs.DeleteMessageOutput{}, nil)
+
+		browser := newBrowser(poller, nil)
+		assert.NoError(t, browser.Delete(ctx, "#2"))
+		assert.Equal(t, []string{"#1", "#3"}, messageIDs(browser.Messages()))
+		assert.Error(t, browser.Delete(ctx, "#2"))
+	})
+
+	t.Run("move", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url")).Times(2)
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil).Times(2)
+		client := mock_apis.NewMockSQSAPI(ctrl)
+		client.EXPECT().GetQueueUrl(gomock.Any(), &sqs.GetQueueUrlInput{QueueName: ptr.String("target")}).
+			Return(&sqs.GetQueueUrlOutput{QueueUrl: ptr.String("target-url")}, nil)
+		client.EXPECT().SendMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+				assert.Equal(t, "target-url", *in.QueueUrl)
+				return &sqs.SendMessageOutput{}, nil
+			}).Times(2)
+
+		browser := newBrowser(poller, client)
+		assert.NoError(t, browser.Move(ctx, "#1", "target"))
+		// the queue URL is cached
+		assert.NoError(t, browser.Move(ctx, "#3", "target"))
+		assert.Equal(t, []string{"#2"}, messageIDs(browser.Messages()))
+	})
+
+	t.Run("move failed", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		client := mock_apis.NewMockSQSAPI(ctrl)
+		client.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).Return(&sqs.GetQueueUrlOutput{QueueUrl: ptr.String("target-url")}, nil)
+		client.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil, errors.New("denied"))
+
+		browser := newBrowser(poller, client)
+		assert.Error(t, browser.Move(ctx, "#1", "target"))
+		assert.Equal(t, []string{"#1", "#2", "#3"}, messageIDs(browser.Messages()))
+	})
+
+	t.Run("save", func(t *testing.T) {
+		browser := newBrowser(mock_aws.NewMockSQSPoller(ctrl), nil)
+		path := filepath.Join(t.TempDir(), "msg.json")
+		assert.NoError(t, browser.Save("#1", path))
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"MessageId": "#1",
+			"Attributes": {"ApproximateReceiveCount": "2", "SentTimestamp": "1600000001000"},
+			"Message": {"orderId": "#1"}
+		}`, string(content))
+		assert.Len(t, browser.Messages(), 3)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url")).AnyTimes()
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          ptr.String("url"),
+			ReceiptHandle:     ptr.String("rh-#1"),
+			VisibilityTimeout: 0,
+		}).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(nil, errors.New("expired"))
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+
+		browser := newBrowser(poller, nil)
+		assert.NoError(t, browser.Release(ctx, "#1"))
+		assert.Error(t, browser.ReleaseAll(ctx))
+		assert.Equal(t, []string{"#2"}, messageIDs(browser.Messages()))
+	})
+}
+
+func TestSQSBrowser_KeepHeld(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctrl := gomock.NewController(t)
+
+	extended := make(chan *sqs.ChangeMessageVisibilityInput, 10)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+	poller.EXPECT().GetQueueURL().Return(ptr.String("url")).AnyTimes()
+	poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+			extended <- in
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}).MinTimes(1)
+
+	browser := NewSQSBrowser(SQSBrowserParams{Logger: log, Poller: poller, Visibility: 20 * time.Millisecond})
+	assert.NoError(t, browser.ProcessMessages(ctx)(poller, browsedMessage("#1")))
+
+	done := make(chan struct{})
+	go func() {
+		browser.KeepHeld(ctx)
+		close(done)
+	}()
+
+	in := <-extended
+	assert.Equal(t, "rh-#1", *in.ReceiptHandle)
+	cancel()
+	<-done
+}
@@ -0,0 +1,229 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// diff keys, any other key is a field path like body.orderId
+const (
+	DiffKeyMessageID = "id"
+	DiffKeyBody      = "body"
+)
+
+// DiffEntry is a key of the messages of a diff side
+type DiffEntry struct {
+	Key string `json:"key"`
+	// MessageID is the first message of the key
+	MessageID string `json:"messageId"`
+	Count     int    `json:"count"`
+}
+
+// DiffSetParams holds DiffSet params
+type DiffSetParams struct {
+	Logger zerolog.Logger
+	// Key is id, body (a body hash) or a field path like body.orderId or msgattr.type
+	Key string
+	// Decoder renders base64 binary payloads as JSON for the field paths, nil keeps the payloads as is
+	Decoder PayloadDecoder
+	// Filter skips the messages it rejects, nil keeps all of them
+	Filter aws.MessageFilter
+}
+
+// DiffSet collects the keys of the messages of a queue or an archive, the messages without the key are counted only
+type DiffSet struct {
+	logger  zerolog.Logger
+	key     string
+	decoder PayloadDecoder
+	filter  aws.MessageFilter
+	entries map[string]*DiffEntry
+	keyless int
+	scanner *queueScanner
+}
+
+// NewDiffSet returns a new instance
+func NewDiffSet(p DiffSetParams) *DiffSet {
+	return &DiffSet{
+		logger:  p.Logger,
+		key:     p.Key,
+		decoder: p.Decoder,
+		filter:  p.Filter,
+		entries: map[string]*DiffEntry{},
+		scanner: newQueueScanner(p.Logger),
+	}
+}
+
+// ProcessMessages returns aws.MessageHandler type func which adds the incoming message to the set
+func (s *DiffSet) ProcessMessages(_ context.Context) aws.MessageHandler {
+	s.logger.Info().Str("key", s.key).Msg("started scanning")
+	return func(_ aws.SQSPoller, msg types.Message) error {
+		if s.scanner.Seen(msg) {
+			return aws.ErrReceivedAgain
+		}
+		s.add(msg)
+
+		return nil
+	}
+}
+
+// HoldFiltered returns aws.MessageHandler type func which holds the filtered messages until the Release
+func (s *DiffSet) HoldFiltered() aws.MessageHandler {
+	return s.scanner.holdFiltered()
+}
+
+// Release makes the held messages visible again
+func (s *DiffSet) Release(ctx context.Context, sqsPoller aws.SQSPoller) error {
+	return s.scanner.Release(ctx, sqsPoller)
+}
+
+// LoadArchive adds the messages of the archive run, the chunks are verified against the manifest
+func (s *DiffSet) LoadArchive(ctx context.Context, store aws.ObjectStore, manifestKey string) error {
+	manifest, err := loadManifest(ctx, store, manifestKey)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range manifest.Chunks {
+		content, err := loadChunk(ctx, store, manifestKey, chunk)
+		if err != nil {
+			return err
+		}
+
+		scanner := recordScanner(content)
+		for line := 0; scanner.Scan(); line++ {
+			var archived ForwardedMessage
+			if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+				return errors.Wrapf(err, "bad record %d of %s", line+1, chunk.Name)
+			}
+			s.add(archived.message())
+		}
+		if err := scanner.Err(); err != nil {
+			return errors.Wrapf(err, "error reading %s", chunk.Name)
+		}
+	}
+
+	return nil
+}
+
+func (s *DiffSet) add(msg types.Message) {
+	if s.filter != nil && !s.filter(msg) {
+		return
+	}
+
+	key, ok := s.keyOf(msg)
+	if !ok {
+		s.keyless++
+		return
+	}
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &DiffEntry{Key: key, MessageID: aws.MessageID(msg)}
+		s.entries[key] = entry
+	}
+	entry.Count++
+}
+
+func (s *DiffSet) keyOf(msg types.Message) (string, bool) {
+	switch s.key {
+	case DiffKeyMessageID:
+		key := aws.DedupMessageID.Key(msg)
+		return key, key != ""
+	case DiffKeyBody:
+		key := aws.DedupBody.Key(msg)
+		return key, key != ""
+	}
+
+	v, ok := newMessageFields(decodeOrKeep(s.logger, msg, s.decoder)).Get(s.key)
+	if !ok {
+		return "", false
+	}
+
+	return stringify(v), true
+}
+
+// Len returns the number of the keys
+func (s *DiffSet) Len() int {
+	return len(s.entries)
+}
+
+// Keyless returns the number of the messages without the key
+func (s *DiffSet) Keyless() int {
+	return s.keyless
+}
+
+// DiffResult holds the keys present on one side only, sorted by the key
+type DiffResult struct {
+	OnlyA  []DiffEntry
+	OnlyB  []DiffEntry
+	Common int
+}
+
+// Diff compares the keys of the sides
+func Diff(a, b *DiffSet) DiffResult {
+	var result DiffResult
+	for key, entry := range a.entries {
+		if _, ok := b.entries[key]; ok {
+			result.Common++
+			continue
+		}
+		result.OnlyA = append(result.OnlyA, *entry)
+	}
+	for key, entry := range b.entries {
+		if _, ok := a.entries[key]; !ok {
+			result.OnlyB = append(result.OnlyB, *entry)
+		}
+	}
+
+	sortEntries(result.OnlyA)
+	sortEntries(result.OnlyB)
+
+	return result
+}
+
+func sortEntries(entries []DiffEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+}
+
+// diffLine is the detail output record of a key present on one side only
+type diffLine struct {
+	Side string `json:"side"`
+	DiffEntry
+}
+
+// PrintDiff prints the counts of the keys, details adds the keys present on one side only as JSON lines
+func PrintDiff(out io.Writer, result DiffResult, details bool) error {
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if details {
+		enc := json.NewEncoder(out)
+		for _, side := range []struct {
+			name    string
+			entries []DiffEntry
+		}{{"A", result.OnlyA}, {"B", result.OnlyB}} {
+			for _, entry := range side.entries {
+				if err := enc.Encode(diffLine{Side: side.name, DiffEntry: entry}); err != nil {
+					return errors.Wrap(err, "error printing the diff")
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "only in A: %d, only in B: %d, in both: %d\n", len(result.OnlyA), len(result.OnlyB), result.Common)
+
+	return nil
+}
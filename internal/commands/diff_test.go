@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	// the redriven copies get new MessageIds, the payloads are kept
+	redriven := func(id, orderID string) types.Message {
+		return types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(`{"orderId":"` + orderID + `"}`),
+		}
+	}
+	queue := []types.Message{
+		redriven("q1", "#1"),
+		redriven("q2", "#2"),
+		redriven("q3", "#2"),
+		redriven("q4", "#9"),
+		{MessageId: ptr.String("q5"), ReceiptHandle: ptr.String("rh-q5"), Body: ptr.String(`not json`)},
+	}
+
+	dir := t.TempDir()
+	archive, _ := writeArchive(t, dir, "#1", "#2", "#3")
+
+	sides := func(t *testing.T, key string) (*DiffSet, *DiffSet) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil).Times(len(queue))
+
+		a := NewDiffSet(DiffSetParams{Logger: log, Key: key})
+		handler := a.ProcessMessages(ctx)
+		for _, m := range queue {
+			assert.NoError(t, handler(poller, m))
+		}
+		assert.ErrorIs(t, handler(poller, queue[0]), aws.ErrReceivedAgain)
+		assert.NoError(t, a.Release(ctx, poller))
+
+		b := NewDiffSet(DiffSetParams{Logger: log, Key: key})
+		assert.NoError(t, b.LoadArchive(ctx, aws.NewDirObjectStore(dir, "bucket"), archive.ManifestKey()))
+
+		return a, b
+	}
+
+	t.Run("by path", func(t *testing.T) {
+		a, b := sides(t, "body.orderId")
+		assert.Equal(t, 3, a.Len())
+		assert.Equal(t, 1, a.Keyless())
+
+		result := Diff(a, b)
+		assert.Equal(t, []DiffEntry{{Key: "#9", MessageID: "q4", Count: 1}}, result.OnlyA)
+		assert.Equal(t, []DiffEntry{{Key: "#3", MessageID: "#3", Count: 1}}, result.OnlyB)
+		assert.Equal(t, 2, result.Common)
+
+		var out bytes.Buffer
+		assert.NoError(t, PrintDiff(&out, result, true))
+		assert.Equal(t, `{"side":"A","key":"#9","messageId":"q4","count":1}
+{"side":"B","key":"#3","messageId":"#3","count":1}
+only in A: 1, only in B: 1, in both: 2
+`, out.String())
+
+		out.Reset()
+		assert.NoError(t, PrintDiff(&out, result, false))
+		assert.Equal(t, "only in A: 1, only in B: 1, in both: 2\n", out.String())
+	})
+
+	t.Run("by id", func(t *testing.T) {
+		result := Diff(sides(t, DiffKeyMessageID))
+		assert.Len(t, result.OnlyA, 5)
+		assert.Len(t, result.OnlyB, 3)
+		assert.Zero(t, result.Common)
+	})
+
+	t.Run("by body", func(t *testing.T) {
+		a, b := sides(t, DiffKeyBody)
+		assert.Equal(t, 4, a.Len())
+
+		result := Diff(a, b)
+		assert.Equal(t, []string{"q4", "q5"}, []string{result.OnlyA[0].MessageID, result.OnlyA[1].MessageID})
+		assert.Len(t, result.OnlyB, 1)
+		assert.Equal(t, 2, result.Common)
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		set := NewDiffSet(DiffSetParams{Logger: log, Key: DiffKeyMessageID, Filter: func(msg types.Message) bool {
+			return aws.MessageID(msg) != "#2"
+		}})
+		assert.NoError(t, set.LoadArchive(ctx, aws.NewDirObjectStore(dir, "bucket"), archive.ManifestKey()))
+		assert.Equal(t, 2, set.Len())
+	})
+}
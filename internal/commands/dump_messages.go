@@ -2,24 +2,59 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	"andboson/sqsdumper/internal/wrappers/aws"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/itchyny/gojq"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
-	"github.com/sinhashubham95/jsonic"
 )
 
 type SQSDumperParams struct {
 	Logger        zerolog.Logger
 	DeleteMessage bool
 	RawMessage    bool
-	JsonPath      string
+	// Query is a jq query run against the decoded payload, nil prints the payload as is
+	Query *Query
+	// RawOutput prints string query results without quotes
+	RawOutput bool
+	// PayloadStore resolves payloads offloaded by the SQS Extended Client Library, nil keeps the pointers
+	PayloadStore aws.PayloadStore
+	// DeletePayload deletes an offloaded payload along with the message
+	DeletePayload bool
+	// Decoder renders base64 binary payloads as JSON, nil keeps the payloads as is
+	Decoder PayloadDecoder
+	// Redactor masks the sensitive parts of the payloads before any output, nil prints the payloads as is
+	Redactor *Redactor
+	// Schema validates the decoded payloads, nil skips the validation
+	Schema *SchemaValidator
+	// InvalidOutput receives the messages not matching the schema instead of the output, nil prints them as usual
+	InvalidOutput io.Writer
+	// Output receives the dumped messages, nil is stdout
+	Output io.Writer
+	// Stream prints the FIFO queue messages as received instead of grouping them until Flush,
+	// deleted messages are always streamed, so a message isn't deleted before it's printed
+	Stream bool
+	// Archive receives the message records instead of the output, a message is deleted after its chunk upload
+	Archive *Archive
+	// DeleteBefore limits the deletes to the messages sent before the time, zero deletes any message
+	DeleteBefore time.Time
+	// MoveTo is the queue URL a message is sent to before its delete, nil deletes only
+	MoveTo *string
+	// Client sends the moved messages
+	Client aws.SQSAPI
+	// Transformer rewrites the payload of a moved message before it's sent
+	Transformer *Transformer
+	// DryRun prints the diffs of the original and the transformed payloads instead of moving the messages
+	DryRun bool
 }
 
 // SQSDumper is a command to print a message content
@@ -27,92 +62,320 @@ type SQSDumper struct {
 	logger        zerolog.Logger
 	deleteMessage bool
 	rawMessage    bool
-	jsonPath      string
+	query         *Query
+	rawOutput     bool
+	payloadStore  aws.PayloadStore
+	deletePayload bool
+	out           io.Writer
+	grouped       *groupedOutput
+	decoder       PayloadDecoder
+	redactor      *Redactor
+	schema        *SchemaValidator
+	invalidOut    io.Writer
+	invalid       int
+	stream        bool
+	archive       *Archive
+	deleteBefore  time.Time
+	moveTo        *string
+	client        aws.SQSAPI
+	transform     *Transformer
+	dryRun        bool
 }
 
 // NewSQSDumper returns a new instance
 func NewSQSDumper(p SQSDumperParams) SQSDumper {
+	out := p.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if p.Archive != nil && p.Redactor != nil {
+		p.Archive.markRedacted()
+	}
+
 	return SQSDumper{
 		logger:        p.Logger,
 		deleteMessage: p.DeleteMessage,
 		rawMessage:    p.RawMessage,
-		jsonPath:      p.JsonPath,
+		query:         p.Query,
+		rawOutput:     p.RawOutput,
+		payloadStore:  p.PayloadStore,
+		deletePayload: p.DeletePayload,
+		out:           out,
+		grouped:       newGroupedOutput(),
+		decoder:       p.Decoder,
+		redactor:      p.Redactor,
+		schema:        p.Schema,
+		invalidOut:    p.InvalidOutput,
+		stream:        p.Stream || p.DeleteMessage,
+		archive:       p.Archive,
+		deleteBefore:  p.DeleteBefore,
+		moveTo:        p.MoveTo,
+		client:        p.Client,
+		transform:     p.Transformer,
+		dryRun:        p.DryRun,
 	}
 }
 
 // ProcessMessages returns aws.MessageHandler type func which process the incoming message
 func (p *SQSDumper) ProcessMessages(ctx context.Context) aws.MessageHandler {
 	p.logger.Info().Msg("started processing")
-	return func(sqsPoller aws.SQSPoller, msg types.Message) error {
+	return func(sqsPoller aws.SQSPoller, received types.Message) error {
+		msg, pointer, err := p.resolvePayload(ctx, received)
+		if err != nil {
+			p.logger.Err(err).Msg("error resolving the message payload")
+			return errors.Wrapf(err, "error resolving the message payload")
+		}
+
+		if msg, err = decodeMessage(msg, p.decoder); err != nil {
+			p.logger.Err(err).Msg("error decoding the message payload")
+			return errors.Wrapf(err, "error decoding the message payload")
+		}
+
+		// the original payload is validated, the redacted one is printed
+		redacted, err := p.redactor.Redact(msg)
+		if err != nil {
+			p.logger.Err(err).Msg("error redacting the message payload")
+			return errors.Wrapf(err, "error redacting the message payload")
+		}
+
+		routed, err := p.validate(msg, redacted)
+		if err != nil {
+			p.logger.Err(err).Msg("error validating the message payload")
+			return errors.Wrapf(err, "error validating the message payload")
+		}
+
+		if p.archive != nil && !routed {
+			var uploaded func(ctx context.Context) error
+			var release func()
+			if p.shouldDelete(msg) {
+				uploaded = func(ctx context.Context) error {
+					return p.delete(ctx, sqsPoller, received, pointer)
+				}
+				// the poller stops extending the message when the handler returns, it's held until its chunk is uploaded
+				release = sqsPoller.Hold(ctx, received)
+			}
+			if err := p.archive.Add(ctx, redacted, uploaded, release); err != nil {
+				if release != nil {
+					release()
+				}
+				p.logger.Err(err).Msg("error archiving the message")
+				return errors.Wrapf(err, "error archiving the message")
+			}
+			return nil
+		}
+
 		// process the message
-		if err := p.processMessage(ctx, msg); err != nil {
-			p.logger.Err(err).Msg("error process the message")
-			return errors.Wrapf(err, "error processing the message")
+		out := p.out
+		if aws.MessageGroupID(msg) != "" && !p.stream {
+			out = p.grouped.writer(msg)
+		}
+		if !routed {
+			if err := p.processMessage(ctx, out, redacted); err != nil {
+				p.logger.Err(err).Msg("error process the message")
+				return errors.Wrapf(err, "error processing the message")
+			}
+		}
+		if p.grouped.full() {
+			// the memory stays bounded, a group may be printed in several parts
+			if err := p.Flush(); err != nil {
+				p.logger.Err(err).Msg("error printing the grouped messages")
+				return errors.Wrapf(err, "error printing the grouped messages")
+			}
 		}
 
-		if !p.deleteMessage {
+		if !p.shouldDelete(msg) {
 			return nil
 		}
 
-		// delete the message
-		if _, err := sqsPoller.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-			QueueUrl:      sqsPoller.GetQueueURL(),
-			ReceiptHandle: msg.ReceiptHandle,
-		}); err != nil {
-			p.logger.Err(err).Msg("error deleting the message")
-			return errors.Wrapf(err, "error deleting the message")
+		return p.delete(ctx, sqsPoller, received, pointer)
+	}
+}
+
+// shouldDelete reports whether the message is deleted, a message without the SentTimestamp isn't stale
+func (p *SQSDumper) shouldDelete(msg types.Message) bool {
+	if !p.deleteMessage {
+		return false
+	}
+	if p.deleteBefore.IsZero() {
+		return true
+	}
+	sent, ok := aws.SentTimestamp(msg)
+
+	return ok && sent.Before(p.deleteBefore)
+}
+
+// delete removes the received message and its offloaded payload, a moved message is transformed
+// and sent to the target queue first
+func (p *SQSDumper) delete(ctx context.Context, sqsPoller aws.SQSPoller, msg types.Message, pointer *aws.PayloadS3Pointer) error {
+	if p.moveTo != nil {
+		if pointer != nil && p.transform != nil {
+			err := errors.New("the offloaded payload can't be transformed")
+			p.logger.Err(err).Msg("error transforming the message")
+			return err
+		}
+		moved, err := p.transform.Transform(msg)
+		if err != nil {
+			p.logger.Err(err).Msg("error transforming the message")
+			return errors.Wrapf(err, "error transforming the message")
+		}
+		if p.dryRun {
+			return writePayloadDiff(p.out, aws.MessageID(msg), messagePayload(msg), messagePayload(moved))
+		}
+
+		// the moved message keeps pointing to the offloaded payload
+		pointer = nil
+		if _, err := p.client.SendMessage(ctx, aws.NewSendMessageInput(p.moveTo, moved)); err != nil {
+			p.logger.Err(err).Msg("error moving the message")
+			return errors.Wrapf(err, "error moving the message")
 		}
+	}
 
-		return nil
+	if _, err := sqsPoller.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      sqsPoller.GetQueueURL(),
+		ReceiptHandle: msg.ReceiptHandle,
+	}); err != nil {
+		p.logger.Err(err).Msg("error deleting the message")
+		return errors.Wrapf(err, "error deleting the message")
 	}
+
+	if p.deletePayload && pointer != nil {
+		// the message is gone already, so a failure leaves an orphaned object only
+		if err := p.payloadStore.Delete(ctx, *pointer); err != nil {
+			p.logger.Err(err).Msg("error deleting the message payload")
+		}
+	}
+
+	return nil
 }
 
-func (p *SQSDumper) processMessage(_ context.Context, msg types.Message) error {
-	eventMessage, err := aws.ParseEventMessage(*msg.Body)
+// Flush prints the buffered output of FIFO queue messages grouped by the message group
+func (p *SQSDumper) Flush() error {
+	return p.grouped.flush(p.out, p.logger)
+}
+
+// Invalid returns the number of messages not matching the schema
+func (p *SQSDumper) Invalid() int {
+	return p.invalid
+}
+
+// validate reports the message which payload doesn't match the schema,
+// true is returned when the redacted message is routed to the invalid output
+func (p *SQSDumper) validate(msg, redacted types.Message) (bool, error) {
+	if p.schema == nil {
+		return false, nil
+	}
+	if _, ok := aws.ParsePayloadS3Pointer(aws.MessageBody(msg)); ok {
+		// not resolved, there is no payload to validate
+		return false, nil
+	}
+
+	fields := newMessageFields(msg)
+	schema, errs, err := p.schema.Validate(fields)
+	if err != nil || len(errs) == 0 {
+		return false, err
+	}
+
+	p.invalid++
+	p.logger.Warn().
+		Str("messageId", aws.MessageID(msg)).
+		Str("schema", schema).
+		Strs("errors", errs).
+		Msg("the payload doesn't match the schema")
+
+	if p.invalidOut == nil {
+		return false, nil
+	}
+
+	line, err := json.Marshal(InvalidMessage{
+		MessageID: aws.MessageID(msg),
+		Schema:    schema,
+		Errors:    errs,
+		Message:   newMessageFields(redacted).Payload(),
+	})
 	if err != nil {
-		return errors.Wrap(err, "error parsing the incoming message")
+		return false, errors.Wrap(err, "error marshaling the invalid message")
 	}
+	fmt.Fprintln(p.invalidOut, string(line))
 
+	return true, nil
+}
 
-	if p.rawMessage || eventMessage.Message == nil {
-		fmt.Println(*msg.Body)
+// resolvePayload replaces an S3 pointer in the message body or in the SNS envelope with the payload
+func (p *SQSDumper) resolvePayload(ctx context.Context, msg types.Message) (types.Message, *aws.PayloadS3Pointer, error) {
+	if p.payloadStore == nil {
+		return msg, nil, nil
+	}
+
+	var resolved *aws.PayloadS3Pointer
+	msg, err := replacePayload(msg, func(payload string) (string, bool, error) {
+		pointer, ok := aws.ParsePayloadS3Pointer(payload)
+		if !ok {
+			return payload, false, nil
+		}
+		b, err := p.payloadStore.Get(ctx, pointer)
+		if err != nil {
+			return "", false, err
+		}
+		resolved = &pointer
+
+		return string(b), true, nil
+	})
+
+	return msg, resolved, err
+}
+
+func (p *SQSDumper) processMessage(_ context.Context, out io.Writer, msg types.Message) error {
+	if _, ok := aws.ParsePayloadS3Pointer(*msg.Body); ok {
+		// not resolved, print the pointer as is
+		fmt.Fprintln(out, *msg.Body)
 		return nil
 	}
 
-	stringed := string(*eventMessage.Message)
+	if p.query != nil && !p.rawMessage {
+		return p.printQuery(out, msg)
+	}
+
+	eventMessage, err := aws.ParseEventMessage(*msg.Body)
+	if err != nil {
+		return errors.Wrap(err, "error parsing the incoming message")
+	}
 
-	if p.jsonPath != "" {
-		return p.printByPath(stringed)
+	if p.rawMessage || eventMessage.Message == nil {
+		fmt.Fprintln(out, *msg.Body)
+		return nil
 	}
 
+	stringed := string(*eventMessage.Message)
 	stringed = strings.ReplaceAll(stringed, `\"`, `"`)
 	if len(stringed) >= 2 {
-		fmt.Println(stringed[1 : len(stringed)-1])
+		fmt.Fprintln(out, stringed[1:len(stringed)-1])
 	} else {
-		fmt.Println(stringed)
+		fmt.Fprintln(out, stringed)
 	}
 
 	return nil
 }
 
-func (p *SQSDumper) printByPath(msg string) error {
-	str, err := strconv.Unquote(msg)
-	if err != nil {
-		// just keep the message
-		str = msg
-	}
-
-	j, err := jsonic.New([]byte(str))
+func (p *SQSDumper) printQuery(out io.Writer, msg types.Message) error {
+	fields := newMessageFields(msg)
+	results, err := p.query.Run(fields.Payload(), fields.Meta())
 	if err != nil {
 		return err
 	}
 
-	data, err := j.Get(p.jsonPath)
-	if err != nil {
-		return err
-	}
+	for _, result := range results {
+		if str, ok := result.(string); ok && p.rawOutput {
+			fmt.Fprintln(out, str)
+			continue
+		}
 
-	fmt.Printf("%s\n", data)
+		b, err := gojq.Marshal(result)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling the query result")
+		}
+		fmt.Fprintln(out, string(b))
+	}
 
 	return nil
 }
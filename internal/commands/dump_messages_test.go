@@ -1,17 +1,25 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	mock_apis "andboson/sqsdumper/internal/mocks/mock_aws"
 	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
 	"andboson/sqsdumper/internal/wrappers/aws"
 
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/aws/smithy-go/ptr"
 	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,7 +46,6 @@ func TestSQSDumper_ProcessMessagesFull(t *testing.T) {
 		Logger:        log,
 		DeleteMessage: true,
 		RawMessage:    true,
-		JsonPath:      "",
 	}
 
 	dumper := NewSQSDumper(params)
@@ -60,7 +67,6 @@ func TestSQSDumper_ProcessMessagesSimple(t *testing.T) {
 		Logger:        log,
 		DeleteMessage: false,
 		RawMessage:    false,
-		JsonPath:      "",
 	}
 	dumper := NewSQSDumper(params)
 	err := dumper.ProcessMessages(ctx)(poller, msg)
@@ -74,3 +80,359 @@ func getBody(t *testing.T, message aws.EventMessage) *string {
 
 	return &str
 }
+
+func TestSQSDumper_ProcessMessagesFIFO(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true})
+	var out bytes.Buffer
+	dumper.out = &out
+
+	fifoMessage := func(body, group, sequence string) types.Message {
+		return types.Message{
+			Body: ptr.String(body),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameMessageGroupId): group,
+				string(types.MessageSystemAttributeNameSequenceNumber): sequence,
+			},
+		}
+	}
+
+	handler := dumper.ProcessMessages(ctx)
+	for _, msg := range []types.Message{
+		fifoMessage(`{"n":"b1"}`, "b", "10"),
+		fifoMessage(`{"n":"a2"}`, "a", "11"),
+		fifoMessage(`{"n":"a1"}`, "a", "9"),
+		fifoMessage(`{"n":"b2"}`, "b", "12"),
+	} {
+		assert.NoError(t, handler(poller, msg))
+	}
+	assert.Empty(t, out.String())
+
+	assert.NoError(t, dumper.Flush())
+	assert.Equal(t, "{\"n\":\"a1\"}\n{\"n\":\"a2\"}\n{\"n\":\"b1\"}\n{\"n\":\"b2\"}\n", out.String())
+}
+
+func TestSQSDumper_ProcessMessagesFIFOLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	var out bytes.Buffer
+	dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true, Output: &out})
+	dumper.grouped.limit = 2
+
+	handler := dumper.ProcessMessages(ctx)
+	for _, body := range []string{`{"n":"b1"}`, `{"n":"a1"}`, `{"n":"b2"}`} {
+		assert.NoError(t, handler(poller, types.Message{
+			Body: ptr.String(body),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameMessageGroupId): body[6:7],
+			},
+		}))
+	}
+
+	// the full buffer is printed without waiting for the end of the run
+	assert.Equal(t, "{\"n\":\"a1\"}\n{\"n\":\"b1\"}\n", out.String())
+	assert.NoError(t, dumper.Flush())
+	assert.Equal(t, "{\"n\":\"a1\"}\n{\"n\":\"b1\"}\n{\"n\":\"b2\"}\n", out.String())
+}
+
+func TestSQSDumper_ProcessMessagesStream(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	var out bytes.Buffer
+	dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true, Output: &out, Stream: true})
+
+	handler := dumper.ProcessMessages(ctx)
+	for _, body := range []string{`{"n":"b1"}`, `{"n":"a1"}`} {
+		assert.NoError(t, handler(poller, types.Message{
+			Body: ptr.String(body),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameMessageGroupId): body[6:7],
+			},
+		}))
+	}
+
+	assert.Equal(t, "{\"n\":\"b1\"}\n{\"n\":\"a1\"}\n", out.String())
+	assert.NoError(t, dumper.Flush())
+	assert.Equal(t, "{\"n\":\"b1\"}\n{\"n\":\"a1\"}\n", out.String())
+
+	t.Run("deleted", func(t *testing.T) {
+		var out bytes.Buffer
+		dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true, Output: &out, DeleteMessage: true})
+
+		// the message is printed before it's deleted
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(context.Context, *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+				assert.Equal(t, "{\"n\":\"a1\"}\n", out.String())
+				return &sqs.DeleteMessageOutput{}, nil
+			})
+		assert.NoError(t, dumper.ProcessMessages(ctx)(poller, types.Message{
+			Body:          ptr.String(`{"n":"a1"}`),
+			ReceiptHandle: ptr.String("rh-1"),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameMessageGroupId): "a",
+			},
+		}))
+	})
+}
+
+func TestSQSDumper_ProcessMessagesS3Payload(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "bucket"), 0755))
+	writePayload := func() {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "bucket", "key"), []byte(`{"big":"yes"}`), 0644))
+	}
+	pointer := `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]`
+
+	t.Run("in the body", func(t *testing.T) {
+		writePayload()
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any())
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+
+		dumper := NewSQSDumper(SQSDumperParams{
+			Logger:        log,
+			DeleteMessage: true,
+			RawMessage:    true,
+			PayloadStore:  aws.NewDirPayloadStore(dir),
+			DeletePayload: true,
+		})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		err := dumper.ProcessMessages(ctx)(poller, types.Message{Body: ptr.String(pointer)})
+		assert.NoError(t, err)
+		assert.Equal(t, "{\"big\":\"yes\"}\n", out.String())
+		assert.NoFileExists(t, filepath.Join(dir, "bucket", "key"))
+	})
+
+	t.Run("in the SNS envelope", func(t *testing.T) {
+		writePayload()
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+
+		query, err := ParseQuery(".big")
+		assert.NoError(t, err)
+		dumper := NewSQSDumper(SQSDumperParams{
+			Logger:       log,
+			Query:        query,
+			RawOutput:    true,
+			PayloadStore: aws.NewDirPayloadStore(dir),
+		})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		rawMessage, err := json.Marshal(pointer)
+		assert.NoError(t, err)
+		err = dumper.ProcessMessages(ctx)(poller, types.Message{
+			Body: getBody(t, aws.EventMessage{Message: (*json.RawMessage)(&rawMessage)}),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "yes\n", out.String())
+		assert.FileExists(t, filepath.Join(dir, "bucket", "key"))
+	})
+
+	t.Run("not resolved", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+
+		dumper := NewSQSDumper(SQSDumperParams{Logger: log})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		err := dumper.ProcessMessages(ctx)(poller, types.Message{Body: ptr.String(pointer)})
+		assert.NoError(t, err)
+		assert.Equal(t, pointer+"\n", out.String())
+	})
+}
+
+func TestSQSDumper_ProcessMessagesSchema(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	path := filepath.Join(t.TempDir(), "orders.json")
+	assert.NoError(t, os.WriteFile(path, []byte(orderSchema), 0644))
+	schema, err := NewSchemaValidator(path, "")
+	assert.NoError(t, err)
+
+	valid := types.Message{MessageId: ptr.String("#1"), Body: ptr.String(`{"orderId":"X"}`)}
+	invalid := types.Message{MessageId: ptr.String("#2"), Body: ptr.String(`{"id":1}`)}
+
+	t.Run("reported", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true, Schema: schema})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		handler := dumper.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, valid))
+		assert.NoError(t, handler(poller, invalid))
+		assert.Equal(t, 1, dumper.Invalid())
+		assert.Equal(t, "{\"orderId\":\"X\"}\n{\"id\":1}\n", out.String())
+	})
+
+	t.Run("routed", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		var invalidOut bytes.Buffer
+		dumper := NewSQSDumper(SQSDumperParams{Logger: log, RawMessage: true, Schema: schema, InvalidOutput: &invalidOut})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		handler := dumper.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, valid))
+		assert.NoError(t, handler(poller, invalid))
+		assert.Equal(t, 1, dumper.Invalid())
+		assert.Equal(t, "{\"orderId\":\"X\"}\n", out.String())
+
+		var routed InvalidMessage
+		assert.NoError(t, json.Unmarshal(invalidOut.Bytes(), &routed))
+		assert.Equal(t, InvalidMessage{
+			MessageID: "#2",
+			Schema:    path,
+			Errors:    []string{"(root): orderId is required"},
+			Message:   map[string]interface{}{"id": float64(1)},
+		}, routed)
+	})
+}
+
+func TestSQSDumper_ProcessMessagesDeleteBefore(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	deleteBefore := time.Date(2022, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	sentAt := func(id string, sent time.Time) types.Message {
+		return types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(`{"foo":"bar"}`),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(sent.UnixMilli(), 10),
+			},
+		}
+	}
+
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+	poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+	poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+			assert.Equal(t, "rh-#1", *in.ReceiptHandle)
+			return &sqs.DeleteMessageOutput{}, nil
+		})
+
+	var out bytes.Buffer
+	dumper := NewSQSDumper(SQSDumperParams{
+		Logger:        log,
+		DeleteMessage: true,
+		DeleteBefore:  deleteBefore,
+		Output:        &out,
+	})
+	handler := dumper.ProcessMessages(ctx)
+	assert.NoError(t, handler(poller, sentAt("#1", deleteBefore.Add(-time.Hour))))
+	// the recent messages and the ones without the SentTimestamp are dumped only
+	assert.NoError(t, handler(poller, sentAt("#2", deleteBefore)))
+	assert.NoError(t, handler(poller, types.Message{MessageId: ptr.String("#3"), Body: ptr.String(`{"foo":"bar"}`)}))
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+}
+
+func TestSQSDumper_ProcessMessagesMove(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	msg := types.Message{
+		MessageId:     ptr.String("#1"),
+		ReceiptHandle: ptr.String("rh-1"),
+		Body:          ptr.String(`{"foo":"bar"}`),
+	}
+	newDumper := func(client aws.SQSAPI) SQSDumper {
+		return NewSQSDumper(SQSDumperParams{
+			Logger:        log,
+			DeleteMessage: true,
+			MoveTo:        ptr.String("parking-url"),
+			Client:        client,
+			Output:        &bytes.Buffer{},
+		})
+	}
+
+	t.Run("moved", func(t *testing.T) {
+		client := mock_apis.NewMockSQSAPI(ctrl)
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		gomock.InOrder(
+			client.EXPECT().SendMessage(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+					assert.Equal(t, "parking-url", *in.QueueUrl)
+					assert.Equal(t, `{"foo":"bar"}`, *in.MessageBody)
+					return &sqs.SendMessageOutput{}, nil
+				}),
+			poller.EXPECT().GetQueueURL().Return(ptr.String("url")),
+			poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil),
+		)
+
+		dumper := newDumper(client)
+		assert.NoError(t, dumper.ProcessMessages(ctx)(poller, msg))
+	})
+
+	t.Run("not sent", func(t *testing.T) {
+		client := mock_apis.NewMockSQSAPI(ctrl)
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		client.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil, errors.New("access denied"))
+
+		// the message stays in the queue
+		dumper := newDumper(client)
+		assert.ErrorContains(t, dumper.ProcessMessages(ctx)(poller, msg), "access denied")
+	})
+
+	t.Run("transformed", func(t *testing.T) {
+		client := mock_apis.NewMockSQSAPI(ctrl)
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		client.EXPECT().SendMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+				assert.Equal(t, `{"foo":"bar","status":"pending"}`, *in.MessageBody)
+				return &sqs.SendMessageOutput{}, nil
+			})
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+
+		transformer, err := NewTransformer(nil, []string{"body.status=pending"})
+		assert.NoError(t, err)
+		var out bytes.Buffer
+		dumper := NewSQSDumper(SQSDumperParams{
+			Logger:        log,
+			DeleteMessage: true,
+			MoveTo:        ptr.String("parking-url"),
+			Client:        client,
+			Output:        &out,
+			Transformer:   transformer,
+		})
+		assert.NoError(t, dumper.ProcessMessages(ctx)(poller, msg))
+		// the original message is dumped
+		assert.Contains(t, out.String(), `"foo"`)
+		assert.NotContains(t, out.String(), "pending")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		// nothing is sent or deleted
+		transformer, err := NewTransformer(nil, []string{"body.status=pending"})
+		assert.NoError(t, err)
+		var out bytes.Buffer
+		dumper := NewSQSDumper(SQSDumperParams{
+			Logger:        log,
+			DeleteMessage: true,
+			MoveTo:        ptr.String("parking-url"),
+			Client:        mock_apis.NewMockSQSAPI(ctrl),
+			Output:        &out,
+			Transformer:   transformer,
+			DryRun:        true,
+		})
+		assert.NoError(t, dumper.ProcessMessages(ctx)(mock_aws.NewMockSQSPoller(ctrl), msg))
+		assert.Contains(t, out.String(), "--- #1 original\n+++ #1 transformed\n")
+		assert.Contains(t, out.String(), `+  "status": "pending"`)
+	})
+}
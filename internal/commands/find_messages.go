@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SQSFinderParams holds SQSFinder params
+type SQSFinderParams struct {
+	Logger zerolog.Logger
+	// Matchers are all required to match
+	Matchers      []Matcher
+	DeleteMessage bool
+	// Limit stops the search after N matches, 0 scans the whole queue
+	Limit int
+	// Decoder renders base64 binary payloads as JSON, nil keeps the payloads as is
+	Decoder PayloadDecoder
+}
+
+// FoundMessage is a printed matching message
+type FoundMessage struct {
+	MessageID    string      `json:"MessageId"`
+	ReceiveCount string      `json:"ReceiveCount"`
+	Message      interface{} `json:"Message"`
+}
+
+// SQSFinder is a command to search a queue for messages without consuming them,
+// the scanned messages are held invisible until the search is over, so every message is seen once
+type SQSFinder struct {
+	logger        zerolog.Logger
+	matchers      []Matcher
+	deleteMessage bool
+	limit         int
+	matches       int
+	decoder       PayloadDecoder
+	scanner       *queueScanner
+	out           io.Writer
+}
+
+// NewSQSFinder returns a new instance
+func NewSQSFinder(p SQSFinderParams) SQSFinder {
+	return SQSFinder{
+		logger:        p.Logger,
+		matchers:      p.Matchers,
+		deleteMessage: p.DeleteMessage,
+		limit:         p.Limit,
+		decoder:       p.Decoder,
+		scanner:       newQueueScanner(p.Logger),
+		out:           os.Stdout,
+	}
+}
+
+// Matches returns the number of found messages
+func (p *SQSFinder) Matches() int {
+	return p.matches
+}
+
+// ProcessMessages returns aws.MessageHandler type func which checks the incoming message
+func (p *SQSFinder) ProcessMessages(ctx context.Context) aws.MessageHandler {
+	p.logger.Info().Int("matchers", len(p.matchers)).Msg("started searching")
+	return func(sqsPoller aws.SQSPoller, msg types.Message) error {
+		if p.scanner.Seen(msg) {
+			return aws.ErrReceivedAgain
+		}
+
+		fields := newMessageFields(decodeOrKeep(p.logger, msg, p.decoder))
+		for _, m := range p.matchers {
+			if !m.Match(fields) {
+				return nil
+			}
+		}
+		p.matches++
+
+		found, err := json.Marshal(FoundMessage{
+			MessageID:    aws.MessageID(msg),
+			ReceiveCount: msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)],
+			Message:      fields.Payload(),
+		})
+		if err != nil {
+			return errors.Wrap(err, "error marshaling the found message")
+		}
+		fmt.Fprintln(p.out, string(found))
+
+		if p.deleteMessage {
+			if _, err := sqsPoller.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				p.logger.Err(err).Msg("error deleting the message")
+				return errors.Wrapf(err, "error deleting the message")
+			}
+			p.scanner.Deleted(msg)
+		}
+
+		if p.limit > 0 && p.matches >= p.limit {
+			return aws.ErrStopPolling
+		}
+
+		return nil
+	}
+}
+
+// HoldFiltered returns aws.MessageHandler type func which holds the filtered messages until the Release
+func (p *SQSFinder) HoldFiltered() aws.MessageHandler {
+	return p.scanner.holdFiltered()
+}
+
+// Release makes the held messages visible again
+func (p *SQSFinder) Release(ctx context.Context, sqsPoller aws.SQSPoller) error {
+	return p.scanner.Release(ctx, sqsPoller)
+}
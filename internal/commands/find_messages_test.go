@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQSFinder_ProcessMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	order := func(id, orderID string) types.Message {
+		return types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(`{"orderId":"` + orderID + `"}`),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "2",
+			},
+		}
+	}
+
+	matchers, err := ParseMatchers([]string{`body.orderId == "X"`})
+	assert.NoError(t, err)
+
+	t.Run("scan once and release", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil).Times(2)
+
+		finder := NewSQSFinder(SQSFinderParams{Logger: log, Matchers: matchers})
+		var out bytes.Buffer
+		finder.out = &out
+
+		handler := finder.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, order("#1", "Y")))
+		assert.NoError(t, handler(poller, order("#2", "X")))
+		assert.ErrorIs(t, handler(poller, order("#1", "Y")), aws.ErrReceivedAgain)
+		assert.Equal(t, 1, finder.Matches())
+
+		var found FoundMessage
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &found))
+		assert.Equal(t, FoundMessage{
+			MessageID:    "#2",
+			ReceiveCount: "2",
+			Message:      map[string]interface{}{"orderId": "X"},
+		}, found)
+
+		assert.NoError(t, finder.Release(ctx, poller))
+	})
+
+	t.Run("filtered are held and released", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		released := map[string]bool{}
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+				released[*in.ReceiptHandle] = true
+				return &sqs.ChangeMessageVisibilityOutput{}, nil
+			}).Times(2)
+
+		finder := NewSQSFinder(SQSFinderParams{Logger: log, Matchers: matchers})
+		finder.out = &bytes.Buffer{}
+
+		handler, filtered := finder.ProcessMessages(ctx), finder.HoldFiltered()
+		assert.NoError(t, handler(poller, order("#1", "X")))
+		assert.NoError(t, filtered(poller, order("#2", "X")))
+		// the filtered message received again means the queue has been scanned
+		assert.ErrorIs(t, filtered(poller, order("#2", "X")), aws.ErrReceivedAgain)
+		assert.Equal(t, 1, finder.Matches())
+
+		assert.NoError(t, finder.Release(ctx, poller))
+		assert.Equal(t, map[string]bool{"rh-#1": true, "rh-#2": true}, released)
+	})
+
+	t.Run("delete matches up to the limit", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+				assert.Equal(t, "rh-#2", *in.ReceiptHandle)
+				return &sqs.DeleteMessageOutput{}, nil
+			})
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(nil, errors.New("expired"))
+
+		finder := NewSQSFinder(SQSFinderParams{Logger: log, Matchers: matchers, DeleteMessage: true, Limit: 1})
+		finder.out = &bytes.Buffer{}
+
+		handler := finder.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, order("#1", "Y")))
+		assert.ErrorIs(t, handler(poller, order("#2", "X")), aws.ErrStopPolling)
+
+		// only the not deleted message is released
+		assert.Error(t, finder.Release(ctx, poller))
+	})
+}
@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"andboson/sqsdumper/internal/sinks"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SQSForwarderParams holds SQSForwarder params
+type SQSForwarderParams struct {
+	Logger zerolog.Logger
+	Sink   sinks.Sink
+	// FullRecord sends the message with the attributes as JSON instead of the body
+	FullRecord bool
+	// Unwrap sends the Message of an SNS envelope with the message attributes of the envelope
+	Unwrap bool
+	// Concurrency is the number of the messages sent at once
+	Concurrency int
+	// Retries and Backoff retry a failed send, the backoff doubles every retry
+	Retries int
+	Backoff time.Duration
+	// Failures receives the messages which can't be sent, nil only logs them
+	Failures aws.Quarantine
+	// Transformer rewrites the payload before it's sent
+	Transformer *Transformer
+	// DryRun prints the diffs of the original and the transformed payloads instead of sending the messages
+	DryRun bool
+	// Tracing creates the forward spans, nil doesn't trace
+	Tracing *aws.Tracing
+}
+
+// ForwardedMessage is the full record of a forwarded message
+type ForwardedMessage struct {
+	MessageID         string                                 `json:"MessageId"`
+	Body              string                                 `json:"Body"`
+	Attributes        map[string]string                      `json:"Attributes,omitempty"`
+	MessageAttributes map[string]types.MessageAttributeValue `json:"MessageAttributes,omitempty"`
+}
+
+// message returns the SQS message of the record
+func (m ForwardedMessage) message() types.Message {
+	return types.Message{
+		MessageId:         &m.MessageID,
+		Body:              &m.Body,
+		Attributes:        m.Attributes,
+		MessageAttributes: m.MessageAttributes,
+	}
+}
+
+// SQSForwarder is a command to forward the messages to a sink,
+// a message is deleted from the queue only after the sink acknowledged it
+type SQSForwarder struct {
+	logger     zerolog.Logger
+	sink       sinks.Sink
+	fullRecord bool
+	unwrap     bool
+	retries    int
+	backoff    time.Duration
+	failures   aws.Quarantine
+	transform  *Transformer
+	dryRun     bool
+	tracing    *aws.Tracing
+	out        io.Writer
+	slots      chan struct{}
+	wg         sync.WaitGroup
+
+	mu        sync.Mutex
+	forwarded int
+	failed    int
+}
+
+// NewSQSForwarder returns a new instance
+func NewSQSForwarder(p SQSForwarderParams) *SQSForwarder {
+	concurrency := p.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &SQSForwarder{
+		logger:     p.Logger,
+		sink:       p.Sink,
+		fullRecord: p.FullRecord,
+		unwrap:     p.Unwrap,
+		retries:    p.Retries,
+		backoff:    p.Backoff,
+		failures:   p.Failures,
+		transform:  p.Transformer,
+		dryRun:     p.DryRun,
+		tracing:    p.Tracing,
+		out:        os.Stdout,
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// ProcessMessages returns aws.MessageHandler type func which sends the incoming message in the background,
+// the handler blocks while Concurrency messages are being sent
+func (p *SQSForwarder) ProcessMessages(ctx context.Context) aws.MessageHandler {
+	p.logger.Info().Int("concurrency", cap(p.slots)).Msg("started forwarding")
+	return func(sqsPoller aws.SQSPoller, msg types.Message) error {
+		transformed, err := p.transform.Transform(msg)
+		if err != nil {
+			p.fail(ctx, msg, err)
+			return nil
+		}
+		if p.dryRun {
+			return writePayloadDiff(p.out, aws.MessageID(msg), messagePayload(msg), messagePayload(transformed))
+		}
+
+		record, err := p.record(transformed)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// the poller stops extending the message when the handler returns, it's held until it's sent and deleted
+		release := sqsPoller.Hold(ctx, msg)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer func() { <-p.slots }()
+			defer release()
+			p.forward(ctx, sqsPoller, msg, record)
+		}()
+
+		return nil
+	}
+}
+
+// Wait waits for the messages being sent
+func (p *SQSForwarder) Wait() {
+	p.wg.Wait()
+}
+
+// Forwarded returns the number of the forwarded messages
+func (p *SQSForwarder) Forwarded() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.forwarded
+}
+
+// Failed returns the number of the messages which can't be sent
+func (p *SQSForwarder) Failed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.failed
+}
+
+func (p *SQSForwarder) forward(ctx context.Context, sqsPoller aws.SQSPoller, msg types.Message, record sinks.Record) {
+	// the delete is a child of the forward span
+	ctx, span := p.tracing.StartMessageSpan(ctx, msg, aws.SpanForward)
+	defer span.End()
+
+	if err := p.send(ctx, record); err != nil {
+		aws.RecordSpanError(span, err)
+		p.fail(ctx, msg, err)
+		return
+	}
+
+	p.mu.Lock()
+	p.forwarded++
+	p.mu.Unlock()
+
+	if _, err := sqsPoller.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      sqsPoller.GetQueueURL(),
+		ReceiptHandle: msg.ReceiptHandle,
+	}); err != nil {
+		// the message is received and forwarded again
+		p.logger.Err(err).Str("messageId", record.MessageID).Msg("error deleting the forwarded message")
+	}
+}
+
+// fail counts the message which can't be forwarded and saves the original to the failures
+func (p *SQSForwarder) fail(ctx context.Context, msg types.Message, err error) {
+	p.mu.Lock()
+	p.failed++
+	p.mu.Unlock()
+
+	logger := p.logger.With().Str("messageId", aws.MessageID(msg)).Logger()
+	logger.Err(err).Msg("error forwarding the message, it stays in the queue")
+	if p.failures != nil && !p.dryRun {
+		if err := p.failures.Put(ctx, msg, err); err != nil {
+			logger.Err(err).Msg("error saving the failed message")
+		}
+	}
+}
+
+// send sends the record retrying with the exponential backoff
+func (p *SQSForwarder) send(ctx context.Context, record sinks.Record) error {
+	backoff := p.backoff
+	err := p.sink.Send(ctx, record)
+	for i := 0; i < p.retries && err != nil; i++ {
+		p.logger.Warn().Err(err).Str("messageId", record.MessageID).Msgf("forwarding error, retry %d of %d", i+1, p.retries)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		err = p.sink.Send(ctx, record)
+	}
+
+	return err
+}
+
+func (p *SQSForwarder) record(msg types.Message) (sinks.Record, error) {
+	record := sinks.Record{
+		MessageID:       aws.MessageID(msg),
+		Body:            []byte(aws.MessageBody(msg)),
+		GroupID:         aws.MessageGroupID(msg),
+		DeduplicationID: aws.MessageDeduplicationID(msg),
+	}
+	record.Attributes, record.AttributeTypes = messageAttributeStrings(msg)
+
+	if p.unwrap {
+		if envelope, err := aws.ParseEventMessage(aws.MessageBody(msg)); err == nil && envelope.Message != nil {
+			record.Topic = envelope.TopicARN
+			record.Body = *envelope.Message
+			// the SNS Message is a string holding the published payload
+			var published string
+			if err := json.Unmarshal(*envelope.Message, &published); err == nil {
+				record.Body = []byte(published)
+			}
+			record.Attributes, record.AttributeTypes = snsAttributeStrings(envelope)
+		}
+	}
+
+	if p.fullRecord {
+		body, err := json.Marshal(ForwardedMessage{
+			MessageID:         aws.MessageID(msg),
+			Body:              aws.MessageBody(msg),
+			Attributes:        msg.Attributes,
+			MessageAttributes: msg.MessageAttributes,
+		})
+		if err != nil {
+			return record, errors.Wrap(err, "error marshaling the message")
+		}
+		record.Body = body
+	}
+
+	return record, nil
+}
+
+// messageAttributeStrings returns the message attributes as strings with their data types,
+// binary values are base64 encoded
+func messageAttributeStrings(msg types.Message) (map[string]string, map[string]string) {
+	if len(msg.MessageAttributes) == 0 {
+		return nil, nil
+	}
+
+	fields := newMessageFields(msg)
+	attrs := make(map[string]string, len(msg.MessageAttributes))
+	dataTypes := make(map[string]string, len(msg.MessageAttributes))
+	for name, attr := range msg.MessageAttributes {
+		value, _ := fields.Get(fieldMsgAttr + "." + name)
+		attrs[name] = stringify(value)
+		dataTypes[name] = ptr.ToString(attr.DataType)
+	}
+
+	return attrs, dataTypes
+}
+
+// snsAttributeStrings returns the message attributes of the SNS envelope with their data types
+func snsAttributeStrings(envelope aws.EventMessage) (map[string]string, map[string]string) {
+	if len(envelope.MessageAttributes) == 0 {
+		return nil, nil
+	}
+
+	attrs := make(map[string]string, len(envelope.MessageAttributes))
+	dataTypes := make(map[string]string, len(envelope.MessageAttributes))
+	for name, attr := range envelope.MessageAttributes {
+		attrs[name] = attr.Value
+		dataTypes[name] = attr.Type
+	}
+
+	return attrs, dataTypes
+}
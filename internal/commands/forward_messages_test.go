@@ -0,0 +1,284 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/sinks"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeSink fails the first sends of a message
+type fakeSink struct {
+	mu       sync.Mutex
+	failures map[string]int
+	sent     []sinks.Record
+	inFlight int
+	maxSent  int
+}
+
+func (s *fakeSink) Send(_ context.Context, record sinks.Record) error {
+	s.mu.Lock()
+	if s.failures[record.MessageID] > 0 {
+		s.failures[record.MessageID]--
+		s.mu.Unlock()
+		return errors.New("unavailable")
+	}
+	s.inFlight++
+	if s.inFlight > s.maxSent {
+		s.maxSent = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.sent = append(s.sent, record)
+
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func TestSQSForwarder_ProcessMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	msg := func(id string) types.Message {
+		return types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(`{"orderId":"` + id + `"}`),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"type": {DataType: ptr.String("String"), StringValue: ptr.String("order")},
+			},
+		}
+	}
+
+	t.Run("deleted after the ack", func(t *testing.T) {
+		sink := &fakeSink{failures: map[string]int{"#2": 2}}
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url")).AnyTimes()
+		deleted := map[string]bool{}
+		var mu sync.Mutex
+		// the message is held until it's deleted
+		poller.EXPECT().Hold(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, msg types.Message) func() {
+				return func() {
+					mu.Lock()
+					defer mu.Unlock()
+					assert.True(t, deleted[*msg.ReceiptHandle])
+				}
+			}).Times(3)
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				deleted[*in.ReceiptHandle] = true
+				return &sqs.DeleteMessageOutput{}, nil
+			}).Times(3)
+
+		forwarder := NewSQSForwarder(SQSForwarderParams{
+			Logger:      log,
+			Sink:        sink,
+			Concurrency: 2,
+			Retries:     2,
+			Backoff:     time.Millisecond,
+		})
+		handler := forwarder.ProcessMessages(ctx)
+		for _, id := range []string{"#1", "#2", "#3"} {
+			assert.NoError(t, handler(poller, msg(id)))
+		}
+		forwarder.Wait()
+
+		assert.Equal(t, 3, forwarder.Forwarded())
+		assert.Equal(t, 0, forwarder.Failed())
+		assert.Equal(t, map[string]bool{"rh-#1": true, "rh-#2": true, "rh-#3": true}, deleted)
+		assert.Equal(t, 2, sink.maxSent)
+		assert.Len(t, sink.sent, 3)
+		assert.Equal(t, map[string]string{"type": "order"}, sink.sent[0].Attributes)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		sink := &fakeSink{failures: map[string]int{"#1": 3}}
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().Hold(gomock.Any(), gomock.Any()).Return(func() {}).AnyTimes()
+		path := filepath.Join(t.TempDir(), "failures.jsonl")
+		failures, err := aws.NewQuarantine(ctx, nil, "file:"+path)
+		assert.NoError(t, err)
+
+		forwarder := NewSQSForwarder(SQSForwarderParams{
+			Logger:   log,
+			Sink:     sink,
+			Retries:  2,
+			Backoff:  time.Millisecond,
+			Failures: failures,
+		})
+		assert.NoError(t, forwarder.ProcessMessages(ctx)(poller, msg("#1")))
+		forwarder.Wait()
+
+		assert.Equal(t, 0, forwarder.Forwarded())
+		assert.Equal(t, 1, forwarder.Failed())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var failed aws.QuarantinedMessage
+		assert.NoError(t, json.Unmarshal(content, &failed))
+		assert.Equal(t, "#1", failed.MessageID)
+		assert.Equal(t, "unavailable", failed.Error)
+	})
+
+	t.Run("transformed", func(t *testing.T) {
+		sink := &fakeSink{}
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().Hold(gomock.Any(), gomock.Any()).Return(func() {}).AnyTimes()
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+		transformer, err := NewTransformer(nil, []string{"body.status=pending"})
+		assert.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "failures.jsonl")
+		failures, err := aws.NewQuarantine(ctx, nil, "file:"+path)
+		assert.NoError(t, err)
+
+		forwarder := NewSQSForwarder(SQSForwarderParams{Logger: log, Sink: sink, Transformer: transformer, Failures: failures})
+		handler := forwarder.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, msg("#1")))
+		// the transform fails on a payload which is not an object
+		assert.NoError(t, handler(poller, types.Message{MessageId: ptr.String("#2"), Body: ptr.String(`[1]`)}))
+		forwarder.Wait()
+
+		assert.Equal(t, 1, forwarder.Forwarded())
+		assert.Equal(t, 1, forwarder.Failed())
+		assert.Equal(t, `{"orderId":"#1","status":"pending"}`, string(sink.sent[0].Body))
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), `"MessageId":"#2"`)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		transformer, err := NewTransformer([]string{`.orderId |= ascii_downcase`}, nil)
+		assert.NoError(t, err)
+
+		var out bytes.Buffer
+		forwarder := NewSQSForwarder(SQSForwarderParams{Logger: log, Transformer: transformer, DryRun: true})
+		forwarder.out = &out
+		assert.NoError(t, forwarder.ProcessMessages(ctx)(poller, types.Message{MessageId: ptr.String("#1"), Body: ptr.String(`{"orderId":"X"}`)}))
+		forwarder.Wait()
+
+		assert.Equal(t, 0, forwarder.Forwarded())
+		assert.Equal(t, "--- #1 original\n+++ #1 transformed\n {\n-  \"orderId\": \"X\"\n+  \"orderId\": \"x\"\n }\n", out.String())
+	})
+
+	t.Run("traced", func(t *testing.T) {
+		sink := &fakeSink{failures: map[string]int{"#2": 1}}
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().Hold(gomock.Any(), gomock.Any()).Return(func() {}).AnyTimes()
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		var deleteSpan trace.SpanContext
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+				deleteSpan = trace.SpanContextFromContext(ctx)
+				return &sqs.DeleteMessageOutput{}, nil
+			})
+
+		recorder := tracetest.NewSpanRecorder()
+		tracing := aws.NewTracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), "orders")
+		forwarder := NewSQSForwarder(SQSForwarderParams{Logger: log, Sink: sink, Tracing: tracing})
+		handler := forwarder.ProcessMessages(ctx)
+		assert.NoError(t, handler(poller, msg("#1")))
+		assert.NoError(t, handler(poller, msg("#2")))
+		forwarder.Wait()
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			for _, attr := range span.Attributes() {
+				if attr.Key == "messaging.message_id" {
+					spans[attr.Value.AsString()] = span
+				}
+			}
+		}
+		assert.Equal(t, "orders forward", spans["#1"].Name())
+		// the delete runs in the forward span
+		assert.Equal(t, spans["#1"].SpanContext(), deleteSpan)
+		assert.Equal(t, codes.Error, spans["#2"].Status().Code)
+	})
+
+	t.Run("full record", func(t *testing.T) {
+		sink := &fakeSink{}
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().Hold(gomock.Any(), gomock.Any()).Return(func() {}).AnyTimes()
+		poller.EXPECT().GetQueueURL().Return(ptr.String("url"))
+		poller.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+
+		forwarder := NewSQSForwarder(SQSForwarderParams{Logger: log, Sink: sink, FullRecord: true})
+		assert.NoError(t, forwarder.ProcessMessages(ctx)(poller, msg("#1")))
+		forwarder.Wait()
+
+		assert.JSONEq(t, `{
+			"MessageId": "#1",
+			"Body": "{\"orderId\":\"#1\"}",
+			"MessageAttributes": {"type": {"DataType": "String", "StringValue": "order", "BinaryValue": null, "BinaryListValues": null, "StringListValues": null}}
+		}`, string(sink.sent[0].Body))
+	})
+}
+
+func TestSQSForwarder_record(t *testing.T) {
+	rawMessage := json.RawMessage(`"{\"orderId\":\"X\"}"`)
+	envelope := getBody(t, aws.EventMessage{
+		TopicARN: "arn:aws:sns:us-east-1:123456789012:orders",
+		Message:  &rawMessage,
+		MessageAttributes: map[string]aws.SNSMessageAttribute{
+			"count": {Type: "Number", Value: "2"},
+		},
+	})
+	msg := types.Message{
+		MessageId: ptr.String("#1"),
+		Body:      envelope,
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: ptr.String("String"), StringValue: ptr.String("order")},
+		},
+	}
+
+	record, err := NewSQSForwarder(SQSForwarderParams{Logger: log}).record(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, sinks.Record{
+		MessageID:      "#1",
+		Body:           []byte(*envelope),
+		Attributes:     map[string]string{"type": "order"},
+		AttributeTypes: map[string]string{"type": "String"},
+	}, record)
+
+	record, err = NewSQSForwarder(SQSForwarderParams{Logger: log, Unwrap: true}).record(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, sinks.Record{
+		MessageID:      "#1",
+		Body:           []byte(`{"orderId":"X"}`),
+		Attributes:     map[string]string{"count": "2"},
+		AttributeTypes: map[string]string{"count": "Number"},
+		Topic:          "arn:aws:sns:us-east-1:123456789012:orders",
+	}, record)
+}
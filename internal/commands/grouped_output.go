@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/rs/zerolog"
+)
+
+// maxGroupedMessages bounds the buffered output, a large FIFO queue is printed in parts of the groups
+const maxGroupedMessages = 1000
+
+// groupedOutput buffers the output of FIFO queue messages per message group,
+// to print every group at once in the sequence number order instead of interleaved with other groups
+type groupedOutput struct {
+	groups map[string][]*groupedEntry
+	count  int
+	limit  int
+}
+
+type groupedEntry struct {
+	sequenceNumber string
+	text           bytes.Buffer
+}
+
+func newGroupedOutput() *groupedOutput {
+	return &groupedOutput{groups: map[string][]*groupedEntry{}, limit: maxGroupedMessages}
+}
+
+// full reports whether the buffer holds the limit of the messages and has to be flushed
+func (g *groupedOutput) full() bool {
+	return g.count >= g.limit
+}
+
+// writer returns the buffer for the message output
+func (g *groupedOutput) writer(msg types.Message) io.Writer {
+	group := aws.MessageGroupID(msg)
+	entry := &groupedEntry{sequenceNumber: aws.SequenceNumber(msg)}
+	g.groups[group] = append(g.groups[group], entry)
+	g.count++
+
+	return &entry.text
+}
+
+// flush prints all buffered groups sorted by the group id and resets the buffer
+func (g *groupedOutput) flush(w io.Writer, logger zerolog.Logger) error {
+	groupIDs := make([]string, 0, len(g.groups))
+	for group := range g.groups {
+		groupIDs = append(groupIDs, group)
+	}
+	sort.Strings(groupIDs)
+
+	for _, group := range groupIDs {
+		entries := g.groups[group]
+		sort.SliceStable(entries, func(i, j int) bool {
+			return aws.CompareSequenceNumbers(entries[i].sequenceNumber, entries[j].sequenceNumber) < 0
+		})
+
+		logger.Info().Str("group", group).Int("messages", len(entries)).Msg("message group")
+		for _, entry := range entries {
+			if _, err := entry.text.WriteTo(w); err != nil {
+				return err
+			}
+		}
+	}
+	g.groups = map[string][]*groupedEntry{}
+	g.count = 0
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// match operators
+const (
+	opEqual    = "=="
+	opNotEqual = "!="
+	opRegexp   = "=~"
+)
+
+// Matcher checks a message field against a value, like body.orderId == "X",
+// supported operators are ==, != and =~ for a regular expression
+type Matcher struct {
+	path  string
+	op    string
+	value interface{}
+	re    *regexp.Regexp
+}
+
+// ParseMatcher parses a match expression, the value is a JSON literal or a bare word
+func ParseMatcher(expr string) (Matcher, error) {
+	var m Matcher
+
+	idx := -1
+	for _, op := range []string{opEqual, opNotEqual, opRegexp} {
+		if i := strings.Index(expr, op); i > 0 && (idx == -1 || i < idx) {
+			idx, m.op = i, op
+		}
+	}
+	if idx == -1 {
+		return m, errors.Errorf("bad match expression %q, expected <path> ==|!=|=~ <value>", expr)
+	}
+
+	m.path = strings.TrimSpace(expr[:idx])
+	literal := strings.TrimSpace(expr[idx+len(m.op):])
+	if m.path == "" || literal == "" {
+		return m, errors.Errorf("bad match expression %q, expected <path> ==|!=|=~ <value>", expr)
+	}
+
+	if err := json.Unmarshal([]byte(literal), &m.value); err != nil {
+		m.value = literal
+	}
+
+	if m.op == opRegexp {
+		pattern, ok := m.value.(string)
+		if !ok {
+			return m, errors.Errorf("bad match expression %q, a regular expression must be a string", expr)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return m, errors.Wrapf(err, "bad regular expression in %q", expr)
+		}
+		m.re = re
+	}
+
+	return m, nil
+}
+
+// ParseMatchers parses all the expressions
+func ParseMatchers(exprs []string) ([]Matcher, error) {
+	matchers := make([]Matcher, 0, len(exprs))
+	for _, expr := range exprs {
+		m, err := ParseMatcher(expr)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// Match checks the message fields, a missing field matches only !=
+func (m Matcher) Match(fields *messageFields) bool {
+	value, ok := fields.Get(m.path)
+	if !ok {
+		return m.op == opNotEqual
+	}
+
+	switch m.op {
+	case opRegexp:
+		return m.re.MatchString(stringify(value))
+	case opNotEqual:
+		return !equalValues(value, m.value)
+	default:
+		return equalValues(value, m.value)
+	}
+}
+
+// equalValues compares decoded JSON values, scalars of different types are compared as strings,
+// so attr.ApproximateReceiveCount == 3 matches the "3" attribute
+func equalValues(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	if isScalar(a) && isScalar(b) {
+		return stringify(a) == stringify(b)
+	}
+
+	return false
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool, nil:
+		return true
+	default:
+		return false
+	}
+}
+
+// stringify returns a string as is and any other value as JSON
+func stringify(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMatcher(t *testing.T) {
+	m, err := ParseMatcher(`body.orderId == "X"`)
+	assert.NoError(t, err)
+	assert.Equal(t, Matcher{path: "body.orderId", op: opEqual, value: "X"}, m)
+
+	m, err = ParseMatcher(`attr.ApproximateReceiveCount != 3`)
+	assert.NoError(t, err)
+	assert.Equal(t, Matcher{path: "attr.ApproximateReceiveCount", op: opNotEqual, value: float64(3)}, m)
+
+	m, err = ParseMatcher(`msgattr.type =~ "a==b"`)
+	assert.NoError(t, err)
+	assert.Equal(t, opRegexp, m.op)
+
+	for _, expr := range []string{`body.x`, `== "X"`, `body.x ==`, `body.x =~ 42`, `body.x =~ "("`} {
+		_, err := ParseMatcher(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	payload := json.RawMessage(`"{\"orderId\":\"X\",\"items\":[{\"sku\":\"a-1\"}],\"total\":42}"`)
+	b, err := json.Marshal(aws.EventMessage{Message: &payload})
+	assert.NoError(t, err)
+
+	fields := newMessageFields(types.Message{
+		MessageId: ptr.String("#1"),
+		Body:      ptr.String(string(b)),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "3",
+		},
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: ptr.String("String"), StringValue: ptr.String("order.created")},
+		},
+	})
+
+	tests := map[string]bool{
+		`body.orderId == "X"`:               true,
+		`body.orderId == X`:                 true,
+		`body.orderId != "X"`:               false,
+		`body.total == 42`:                  true,
+		`body.items.0.sku =~ "^a-"`:         true,
+		`body.missing == "X"`:               false,
+		`body.missing != "X"`:               true,
+		`attr.ApproximateReceiveCount == 3`: true,
+		`msgattr.type =~ "^order\\."`:       true,
+		`id == "#1"`:                        true,
+		`body.items == [{"sku":"a-1"}]`:     true,
+		`unknown.field == "X"`:              false,
+	}
+	for expr, want := range tests {
+		m, err := ParseMatcher(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, want, m.Match(fields), expr)
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, firstValue(decodePayload(types.Message{Body: ptr.String(`{"foo":"bar"}`)})))
+	assert.Equal(t, "plain text", firstValue(decodePayload(types.Message{Body: ptr.String(`plain text`)})))
+}
+
+func firstValue(v interface{}, _ interface{}) interface{} {
+	return v
+}
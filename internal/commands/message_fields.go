@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// field path prefixes
+const (
+	fieldBody    = "body"
+	fieldAttr    = "attr"
+	fieldMsgAttr = "msgattr"
+	fieldID      = "id"
+	fieldSNS     = "sns"
+)
+
+// messageFields gives access to the message parts by a path:
+// body.x.y for the decoded payload, attr.Name for a system attribute,
+// msgattr.Name for a message attribute, sns.Name for an SNS envelope field and id for the MessageId
+type messageFields struct {
+	msg      types.Message
+	payload  interface{}
+	envelope *aws.EventMessage
+	decoded  bool
+}
+
+func newMessageFields(msg types.Message) *messageFields {
+	return &messageFields{msg: msg}
+}
+
+// Payload returns the decoded message payload, the SNS envelope is unwrapped,
+// a payload which is not JSON is returned as a string
+func (f *messageFields) Payload() interface{} {
+	f.decode()
+
+	return f.payload
+}
+
+// Meta returns the message metadata: MessageId, system and message attributes and the SNS envelope fields
+func (f *messageFields) Meta() map[string]interface{} {
+	attrs := make(map[string]interface{}, len(f.msg.Attributes))
+	for name, value := range f.msg.Attributes {
+		attrs[name] = value
+	}
+	msgAttrs := make(map[string]interface{}, len(f.msg.MessageAttributes))
+	for name := range f.msg.MessageAttributes {
+		msgAttrs[name], _ = f.Get(fieldMsgAttr + "." + name)
+	}
+
+	meta := map[string]interface{}{
+		"MessageId":         aws.MessageID(f.msg),
+		"Attributes":        attrs,
+		"MessageAttributes": msgAttrs,
+	}
+
+	if sns := f.sns(); sns != nil {
+		meta["SNS"] = sns
+	}
+
+	return meta
+}
+
+// sns returns the SNS envelope fields, nil for a message published not via SNS
+func (f *messageFields) sns() map[string]interface{} {
+	f.decode()
+	if f.envelope == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"Type":      f.envelope.Type,
+		"MessageId": f.envelope.MessageID,
+		"TopicArn":  f.envelope.TopicARN,
+		"Timestamp": f.envelope.Timestamp,
+	}
+}
+
+func (f *messageFields) decode() {
+	if f.decoded {
+		return
+	}
+
+	f.payload, f.envelope = decodePayload(f.msg)
+	f.decoded = true
+}
+
+// Get returns the field value by the path
+func (f *messageFields) Get(path string) (interface{}, bool) {
+	prefix, rest, _ := strings.Cut(path, ".")
+	switch prefix {
+	case fieldID:
+		return aws.MessageID(f.msg), f.msg.MessageId != nil
+	case fieldBody:
+		return lookupPath(f.Payload(), rest)
+	case fieldAttr:
+		value, ok := f.msg.Attributes[rest]
+		return value, ok
+	case fieldMsgAttr:
+		value, ok := f.msg.MessageAttributes[rest]
+		if !ok {
+			return nil, false
+		}
+		if value.StringValue != nil {
+			return *value.StringValue, true
+		}
+		return base64.StdEncoding.EncodeToString(value.BinaryValue), true
+	case fieldSNS:
+		sns := f.sns()
+		if sns == nil {
+			return nil, false
+		}
+		return lookupPath(sns, rest)
+	default:
+		return nil, false
+	}
+}
+
+// decodePayload returns the payload of a plain or an SNS enveloped message with the envelope
+func decodePayload(msg types.Message) (interface{}, *aws.EventMessage) {
+	var envelope *aws.EventMessage
+
+	body := aws.MessageBody(msg)
+	eventMessage, err := aws.ParseEventMessage(body)
+	if err == nil && eventMessage.Message != nil {
+		envelope = &eventMessage
+		body = string(*eventMessage.Message)
+		// the SNS Message is a string holding the published payload
+		var published string
+		if err := json.Unmarshal(*eventMessage.Message, &published); err == nil {
+			body = published
+		}
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		return body, envelope
+	}
+
+	return payload, envelope
+}
+
+// lookupPath walks the dotted path over the decoded JSON, numeric segments index arrays
+func lookupPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" || path == "." {
+		return value, true
+	}
+
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			value = node[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
@@ -0,0 +1,255 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/hamba/avro"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// PayloadDecoder renders a binary payload as JSON
+type PayloadDecoder interface {
+	Decode(data []byte, msg types.Message) ([]byte, error)
+}
+
+// ProtoDecoder decodes protobuf payloads by the message descriptors of a descriptor set file
+type ProtoDecoder struct {
+	files    *protoregistry.Files
+	typeName string
+	typeAttr string
+}
+
+// NewProtoDecoder loads the descriptor set produced by protoc --descriptor_set_out --include_imports,
+// the message type is taken from the typeAttr message attribute falling back to typeName
+func NewProtoDecoder(descriptorSet, typeName, typeAttr string) (*ProtoDecoder, error) {
+	if typeName == "" && typeAttr == "" {
+		return nil, errors.New("the protobuf message type is required")
+	}
+
+	b, err := os.ReadFile(descriptorSet)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the descriptor set")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, errors.Wrap(err, "error parsing the descriptor set")
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing the descriptor set")
+	}
+
+	d := &ProtoDecoder{
+		files:    files,
+		typeName: typeName,
+		typeAttr: typeAttr,
+	}
+	if typeName != "" {
+		if _, err := d.descriptor(typeName); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// Decode returns the protojson rendering of the payload
+func (d *ProtoDecoder) Decode(data []byte, msg types.Message) ([]byte, error) {
+	typeName := d.typeName
+	if attr, ok := msg.MessageAttributes[d.typeAttr]; ok && d.typeAttr != "" && attr.StringValue != nil {
+		typeName = *attr.StringValue
+	}
+	if typeName == "" {
+		return nil, errors.Errorf("the message has no %s attribute with the protobuf message type", d.typeAttr)
+	}
+
+	descriptor, err := d.descriptor(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "error decoding the %s message", typeName)
+	}
+	b, err := protojson.MarshalOptions{Resolver: dynamicTypes{d.files}}.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error rendering the %s message", typeName)
+	}
+
+	// protojson output is randomly spaced
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, b); err != nil {
+		return nil, errors.Wrapf(err, "error rendering the %s message", typeName)
+	}
+
+	return compacted.Bytes(), nil
+}
+
+func (d *ProtoDecoder) descriptor(typeName string) (protoreflect.MessageDescriptor, error) {
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown protobuf message type %s", typeName)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a protobuf message type", typeName)
+	}
+
+	return message, nil
+}
+
+// dynamicTypes resolves google.protobuf.Any payloads by the descriptor set
+type dynamicTypes struct {
+	files *protoregistry.Files
+}
+
+func (r dynamicTypes) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	desc, err := r.files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+
+	return dynamicpb.NewMessageType(message), nil
+}
+
+func (r dynamicTypes) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := protoreflect.FullName(url)
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		name = protoreflect.FullName(url[i+1:])
+	}
+
+	return r.FindMessageByName(name)
+}
+
+func (r dynamicTypes) FindExtensionByName(protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return nil, protoregistry.NotFound
+}
+
+func (r dynamicTypes) FindExtensionByNumber(protoreflect.FullName, protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return nil, protoregistry.NotFound
+}
+
+// AvroDecoder decodes Avro binary payloads by the writer schema
+type AvroDecoder struct {
+	schema avro.Schema
+}
+
+// NewAvroDecoder loads the Avro schema file
+func NewAvroDecoder(schemaPath string) (*AvroDecoder, error) {
+	b, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the Avro schema")
+	}
+	schema, err := avro.Parse(string(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing the Avro schema")
+	}
+
+	return &AvroDecoder{schema: schema}, nil
+}
+
+// Decode returns the JSON rendering of the payload
+func (d *AvroDecoder) Decode(data []byte, _ types.Message) ([]byte, error) {
+	var value interface{}
+	if err := avro.Unmarshal(d.schema, data, &value); err != nil {
+		return nil, errors.Wrap(err, "error decoding the Avro payload")
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "error rendering the Avro payload")
+	}
+
+	return b, nil
+}
+
+// decodeMessage replaces a base64 binary payload in the message body or in the SNS envelope with its JSON rendering,
+// a payload which is JSON already is kept
+func decodeMessage(msg types.Message, decoder PayloadDecoder) (types.Message, error) {
+	if decoder == nil {
+		return msg, nil
+	}
+
+	return replacePayload(msg, func(payload string) (string, bool, error) {
+		if json.Valid([]byte(payload)) {
+			return payload, false, nil
+		}
+
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return "", false, errors.Wrap(err, "the payload is not base64 encoded")
+		}
+		decoded, err := decoder.Decode(data, msg)
+		if err != nil {
+			return "", false, err
+		}
+
+		return string(decoded), true, nil
+	})
+}
+
+// decodeOrKeep decodes the payload of a scanned message, a payload which can't be decoded is kept as is
+func decodeOrKeep(logger zerolog.Logger, msg types.Message, decoder PayloadDecoder) types.Message {
+	decoded, err := decodeMessage(msg, decoder)
+	if err != nil {
+		logger.Warn().Err(err).Str("messageId", aws.MessageID(msg)).Msg("error decoding the message payload")
+		return msg
+	}
+
+	return decoded
+}
+
+// replacePayload replaces the payload published via SNS, which is the envelope Message, or the message body
+func replacePayload(msg types.Message, replace func(payload string) (string, bool, error)) (types.Message, error) {
+	if msg.Body == nil {
+		return msg, nil
+	}
+
+	var envelope map[string]json.RawMessage
+	var snsMessage string
+	if json.Unmarshal([]byte(*msg.Body), &envelope) != nil || json.Unmarshal(envelope["Message"], &snsMessage) != nil {
+		payload, replaced, err := replace(*msg.Body)
+		if err != nil || !replaced {
+			return msg, err
+		}
+		msg.Body = &payload
+
+		return msg, nil
+	}
+
+	payload, replaced, err := replace(snsMessage)
+	if err != nil || !replaced {
+		return msg, err
+	}
+	if envelope["Message"], err = json.Marshal(payload); err != nil {
+		return msg, err
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return msg, err
+	}
+	resolved := string(body)
+	msg.Body = &resolved
+
+	return msg, nil
+}
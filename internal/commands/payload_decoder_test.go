@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/hamba/avro"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeOrderDescriptors writes the descriptor set of orders.v1.Order{order_id, amount} and returns the encoded order
+func writeOrderDescriptors(t *testing.T) (string, []byte) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("orders.proto"),
+		Package: proto.String("orders.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:     proto.String("order_id"),
+					JsonName: proto.String("orderId"),
+					Number:   proto.Int32(1),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				},
+				{
+					Name:     proto.String("amount"),
+					JsonName: proto.String("amount"),
+					Number:   proto.Int32(2),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				},
+			},
+		}},
+	}
+
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "orders.pb")
+	assert.NoError(t, os.WriteFile(path, b, 0644))
+
+	fd, err := protodesc.NewFile(file, nil)
+	assert.NoError(t, err)
+	desc := fd.Messages().ByName("Order")
+	order := dynamicpb.NewMessage(desc)
+	order.Set(desc.Fields().ByName("order_id"), protoreflect.ValueOf("X"))
+	order.Set(desc.Fields().ByName("amount"), protoreflect.ValueOf(int32(42)))
+	encoded, err := proto.Marshal(order)
+	assert.NoError(t, err)
+
+	return path, encoded
+}
+
+func TestProtoDecoder_Decode(t *testing.T) {
+	path, encoded := writeOrderDescriptors(t)
+
+	t.Run("by the type name", func(t *testing.T) {
+		d, err := NewProtoDecoder(path, "orders.v1.Order", "")
+		assert.NoError(t, err)
+
+		b, err := d.Decode(encoded, types.Message{})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"orderId":"X","amount":42}`, string(b))
+	})
+
+	t.Run("by the message attribute", func(t *testing.T) {
+		d, err := NewProtoDecoder(path, "", "type")
+		assert.NoError(t, err)
+
+		b, err := d.Decode(encoded, types.Message{MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: ptr.String("String"), StringValue: ptr.String("orders.v1.Order")},
+		}})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"orderId":"X","amount":42}`, string(b))
+
+		_, err = d.Decode(encoded, types.Message{})
+		assert.Error(t, err)
+	})
+
+	t.Run("bad params", func(t *testing.T) {
+		_, err := NewProtoDecoder(path, "", "")
+		assert.Error(t, err)
+		_, err = NewProtoDecoder(path, "orders.v1.Missing", "")
+		assert.Error(t, err)
+		_, err = NewProtoDecoder(filepath.Join(t.TempDir(), "missing.pb"), "orders.v1.Order", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestAvroDecoder_Decode(t *testing.T) {
+	const schema = `{"type":"record","name":"Order","fields":[{"name":"orderId","type":"string"},{"name":"amount","type":"int"}]}`
+	path := filepath.Join(t.TempDir(), "order.avsc")
+	assert.NoError(t, os.WriteFile(path, []byte(schema), 0644))
+
+	d, err := NewAvroDecoder(path)
+	assert.NoError(t, err)
+
+	encoded, err := avro.Marshal(avro.MustParse(schema), map[string]interface{}{"orderId": "X", "amount": 42})
+	assert.NoError(t, err)
+
+	b, err := d.Decode(encoded, types.Message{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"orderId":"X","amount":42}`, string(b))
+
+	_, err = d.Decode([]byte{0xff}, types.Message{})
+	assert.Error(t, err)
+}
+
+func TestSQSDumper_ProcessMessagesDecoder(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	path, encoded := writeOrderDescriptors(t)
+	decoder, err := NewProtoDecoder(path, "orders.v1.Order", "")
+	assert.NoError(t, err)
+	query, err := ParseQuery(".orderId")
+	assert.NoError(t, err)
+
+	dumper := NewSQSDumper(SQSDumperParams{Logger: log, Query: query, RawOutput: true, Decoder: decoder})
+	var out bytes.Buffer
+	dumper.out = &out
+	handler := dumper.ProcessMessages(ctx)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	// in the body
+	assert.NoError(t, handler(poller, types.Message{Body: ptr.String(base64.StdEncoding.EncodeToString(encoded))}))
+
+	// in the SNS envelope
+	rawMessage, err := json.Marshal(base64.StdEncoding.EncodeToString(encoded))
+	assert.NoError(t, err)
+	assert.NoError(t, handler(poller, types.Message{
+		Body: getBody(t, aws.EventMessage{Message: (*json.RawMessage)(&rawMessage)}),
+	}))
+
+	// JSON is kept
+	assert.NoError(t, handler(poller, types.Message{Body: ptr.String(`{"orderId":"Y"}`)}))
+
+	assert.Equal(t, "X\nX\nY\n", out.String())
+
+	assert.Error(t, handler(poller, types.Message{Body: ptr.String("not base64")}))
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/pkg/errors"
+)
+
+// metaVariable is the jq variable holding the message metadata
+const metaVariable = "$meta"
+
+var jsonPathIndexRe = regexp.MustCompile(`^([^\[]*)((?:\[\d+\])*)$`)
+
+// Query is a compiled jq query run against the decoded payload,
+// $meta holds the MessageId, the attributes and the SNS envelope fields
+type Query struct {
+	code *gojq.Code
+}
+
+// ParseQuery parses and compiles a jq query
+func ParseQuery(src string) (*Query, error) {
+	parsed, err := gojq.Parse(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad query %q", src)
+	}
+
+	code, err := gojq.Compile(parsed, gojq.WithVariables([]string{metaVariable}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad query %q", src)
+	}
+
+	return &Query{code: code}, nil
+}
+
+// JSONPathQuery converts a json path shorthand like x.y[0].z to the jq query
+func JSONPathQuery(path string) (string, error) {
+	path = strings.Trim(path, ".")
+	if path == "" {
+		return ".", nil
+	}
+
+	var query strings.Builder
+	for _, segment := range strings.Split(path, ".") {
+		parts := jsonPathIndexRe.FindStringSubmatch(segment)
+		if parts == nil {
+			return "", errors.Errorf("bad json path %q", path)
+		}
+		if parts[1] != "" {
+			query.WriteString(".")
+			query.WriteString(quoteKey(parts[1]))
+		}
+		query.WriteString(parts[2])
+	}
+
+	if query.Len() > 0 && query.String()[0] == '[' {
+		return "." + query.String(), nil
+	}
+
+	return query.String(), nil
+}
+
+// Run returns all the query results
+func (q *Query) Run(payload interface{}, meta map[string]interface{}) ([]interface{}, error) {
+	var results []interface{}
+
+	iter := q.code.Run(payload, meta)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return results, nil
+		}
+		if err, ok := v.(error); ok {
+			return results, errors.Wrap(err, "query error")
+		}
+		results = append(results, v)
+	}
+}
+
+func quoteKey(key string) string {
+	b, _ := gojq.Marshal(key)
+	return string(b)
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPathQuery(t *testing.T) {
+	tests := map[string]string{
+		"":               ".",
+		".":              ".",
+		"foo":            `."foo"`,
+		"a.arr[0].a":     `."a"."arr"[0]."a"`,
+		"some-key.x":     `."some-key"."x"`,
+		"[1]":            ".[1]",
+		"items[0][1].id": `."items"[0][1]."id"`,
+	}
+	for path, want := range tests {
+		got, err := JSONPathQuery(path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, want, got, path)
+
+		_, err = ParseQuery(got)
+		assert.NoError(t, err, got)
+	}
+
+	_, err := JSONPathQuery("a[x]")
+	assert.Error(t, err)
+}
+
+func TestSQSDumper_ProcessMessagesQuery(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	payload := json.RawMessage(`"{\"orders\":[{\"id\":1,\"status\":\"failed\"},{\"id\":2,\"status\":\"ok\"}]}"`)
+	msg := types.Message{
+		MessageId: ptr.String("#1"),
+		Body: getBody(t, aws.EventMessage{
+			Message:  &payload,
+			TopicARN: "arn:aws:sns:eu-central-1:1:orders",
+		}),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "4",
+		},
+	}
+
+	tests := []struct {
+		query     string
+		rawOutput bool
+		want      string
+	}{
+		{
+			query: `.orders[] | select(.status == "failed") | {id, topic: $meta.SNS.TopicArn}`,
+			want:  "{\"id\":1,\"topic\":\"arn:aws:sns:eu-central-1:1:orders\"}\n",
+		},
+		{
+			query:     `"\($meta.MessageId) received \($meta.Attributes.ApproximateReceiveCount) times"`,
+			rawOutput: true,
+			want:      "#1 received 4 times\n",
+		},
+		{
+			query: `.orders | map(.id)`,
+			want:  "[1,2]\n",
+		},
+		{
+			query: `.missing`,
+			want:  "null\n",
+		},
+	}
+	for _, tt := range tests {
+		query, err := ParseQuery(tt.query)
+		assert.NoError(t, err)
+
+		dumper := NewSQSDumper(SQSDumperParams{Logger: log, Query: query, RawOutput: tt.rawOutput})
+		var out bytes.Buffer
+		dumper.out = &out
+
+		assert.NoError(t, dumper.ProcessMessages(ctx)(poller, msg), tt.query)
+		assert.Equal(t, tt.want, out.String(), tt.query)
+	}
+
+	_, err := ParseQuery(`.foo |`)
+	assert.Error(t, err)
+}
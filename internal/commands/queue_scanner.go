@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// queueScanner tracks a non-destructive queue scan: the scanned messages are held invisible
+// until the scan is over, so every message is seen once, and released at the end
+type queueScanner struct {
+	logger  zerolog.Logger
+	held    map[string]*string
+	deleted map[string]struct{}
+}
+
+func newQueueScanner(logger zerolog.Logger) *queueScanner {
+	return &queueScanner{
+		logger:  logger,
+		held:    map[string]*string{},
+		deleted: map[string]struct{}{},
+	}
+}
+
+// Seen registers the message, true is returned for a message received again
+// after the visibility timeout, which means the whole queue has been scanned
+func (s *queueScanner) Seen(msg types.Message) bool {
+	id := aws.MessageID(msg)
+	_, held := s.held[id]
+	_, deleted := s.deleted[id]
+	if !deleted {
+		// keep the latest receipt handle to release the message
+		s.held[id] = msg.ReceiptHandle
+	}
+
+	if held || deleted {
+		s.logger.Info().Str("messageId", id).Msg("the message is received again, the queue has been scanned")
+		return true
+	}
+
+	return false
+}
+
+// holdFiltered returns aws.MessageHandler type func which holds the messages skipped by the poller filters
+// with the scanned ones, so the scan sees them once and releases them at the end
+func (s *queueScanner) holdFiltered() aws.MessageHandler {
+	return func(_ aws.SQSPoller, msg types.Message) error {
+		if s.Seen(msg) {
+			return aws.ErrReceivedAgain
+		}
+
+		return nil
+	}
+}
+
+// Deleted marks the message as deleted, so it isn't released
+func (s *queueScanner) Deleted(msg types.Message) {
+	id := aws.MessageID(msg)
+	delete(s.held, id)
+	s.deleted[id] = struct{}{}
+}
+
+// Release makes the held messages visible again
+func (s *queueScanner) Release(ctx context.Context, sqsPoller aws.SQSPoller) error {
+	var failed int
+	for id, receiptHandle := range s.held {
+		if _, err := sqsPoller.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			ReceiptHandle:     receiptHandle,
+			VisibilityTimeout: 0,
+		}); err != nil {
+			failed++
+			s.logger.Err(err).Str("messageId", id).Msg("error releasing the message")
+			continue
+		}
+		delete(s.held, id)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d messages are not released and stay invisible until the visibility timeout", failed)
+	}
+
+	return nil
+}
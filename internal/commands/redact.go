@@ -0,0 +1,323 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// redaction modes
+const (
+	RedactModeMask = "mask"
+	RedactModeHash = "hash"
+)
+
+// redactMask replaces a masked value
+const redactMask = "***"
+
+// well-known sensitive value patterns
+var redactPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	"card":  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+	"ipv4":  regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+}
+
+// RedactRule is a redaction rule, exactly one of Path, Regex or Pattern is set
+type RedactRule struct {
+	// Path is a payload path like body.user.email, the whole value is redacted
+	Path string `yaml:"path"`
+	// Regex matches are redacted in all the payload strings
+	Regex string `yaml:"regex"`
+	// Pattern is a well-known pattern: email, card or ipv4
+	Pattern string `yaml:"pattern"`
+	// Mode overrides the default mode
+	Mode string `yaml:"mode"`
+
+	path []string
+	re   *regexp.Regexp
+}
+
+// RedactConfig is the redaction rules file
+type RedactConfig struct {
+	Mode string `yaml:"mode"`
+	// Salt keys the hashes, so the hashed values can't be guessed without it
+	Salt  string       `yaml:"salt"`
+	Rules []RedactRule `yaml:"rules"`
+}
+
+// ParseRedactRule parses a rule like body.user.email, re:<regex> or pattern:email, the rule is checked by NewRedactor
+func ParseRedactRule(src string) RedactRule {
+	switch {
+	case strings.HasPrefix(src, "re:"):
+		return RedactRule{Regex: strings.TrimPrefix(src, "re:")}
+	case strings.HasPrefix(src, "pattern:"):
+		return RedactRule{Pattern: strings.TrimPrefix(src, "pattern:")}
+	default:
+		return RedactRule{Path: src}
+	}
+}
+
+// LoadRedactConfig reads the YAML rules file
+func LoadRedactConfig(path string) (RedactConfig, error) {
+	var cfg RedactConfig
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "error reading the redaction rules")
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, errors.Wrap(err, "error parsing the redaction rules")
+	}
+
+	return cfg, nil
+}
+
+// Redactor masks or hashes the sensitive parts of message payloads and attributes
+type Redactor struct {
+	mode  string
+	salt  []byte
+	rules []RedactRule
+}
+
+// NewRedactor compiles the rules, the config mode is the default for the rules without one,
+// the hash mode needs a salt
+func NewRedactor(cfg RedactConfig) (*Redactor, error) {
+	mode, rules := cfg.Mode, cfg.Rules
+	if mode == "" {
+		mode = RedactModeMask
+	}
+	if err := checkRedactMode(mode); err != nil {
+		return nil, err
+	}
+
+	compiled := make([]RedactRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Mode == "" {
+			rule.Mode = mode
+		}
+		if err := checkRedactMode(rule.Mode); err != nil {
+			return nil, err
+		}
+		if rule.Mode == RedactModeHash && cfg.Salt == "" {
+			return nil, errors.New("the hash redaction mode needs a salt, set --redact-salt or salt in the rules file")
+		}
+
+		switch {
+		case rule.Path != "" && rule.Regex == "" && rule.Pattern == "":
+			prefix, rest, _ := strings.Cut(rule.Path, ".")
+			if prefix != fieldBody || rest == "" {
+				return nil, errors.Errorf("bad redaction path %q, only body.x.y paths are supported", rule.Path)
+			}
+			rule.path = strings.Split(rest, ".")
+		case rule.Regex != "" && rule.Path == "" && rule.Pattern == "":
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad redaction regex %q", rule.Regex)
+			}
+			rule.re = re
+		case rule.Pattern != "" && rule.Path == "" && rule.Regex == "":
+			re, ok := redactPatterns[rule.Pattern]
+			if !ok {
+				return nil, errors.Errorf("unknown redaction pattern %q, use email, card or ipv4", rule.Pattern)
+			}
+			rule.re = re
+		default:
+			return nil, errors.New("a redaction rule must have exactly one of path, regex or pattern")
+		}
+		compiled = append(compiled, rule)
+	}
+
+	return &Redactor{mode: mode, salt: []byte(cfg.Salt), rules: compiled}, nil
+}
+
+// Redact returns the message with the payload in the body or in the SNS envelope redacted,
+// the regex rules apply to the string message attributes as well
+func (r *Redactor) Redact(msg types.Message) (types.Message, error) {
+	if r == nil {
+		return msg, nil
+	}
+
+	msg, err := replacePayload(msg, func(payload string) (string, bool, error) {
+		redacted, err := r.redactPayload(payload)
+		if err != nil {
+			return "", false, err
+		}
+
+		return redacted, redacted != payload, nil
+	})
+	if err != nil {
+		return msg, err
+	}
+	msg.MessageAttributes = r.redactAttributes(msg.MessageAttributes)
+
+	return msg, nil
+}
+
+// redactAttributes returns a copy of the attributes with the string values redacted, the message ones are kept
+func (r *Redactor) redactAttributes(attrs map[string]types.MessageAttributeValue) map[string]types.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return attrs
+	}
+
+	redacted := make(map[string]types.MessageAttributeValue, len(attrs))
+	for name, value := range attrs {
+		if value.StringValue != nil {
+			s := r.redactString(*value.StringValue)
+			value.StringValue = &s
+		}
+		redacted[name] = value
+	}
+
+	return redacted
+}
+
+func (r *Redactor) redactPayload(payload string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		// not JSON, only the regex rules apply
+		return r.redactString(payload), nil
+	}
+
+	changed := false
+	for _, rule := range r.rules {
+		if rule.path != nil {
+			value = r.redactPath(value, rule.path, rule.Mode, &changed)
+		}
+	}
+	value = r.redactStrings(value, &changed)
+	if !changed {
+		return payload, nil
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", errors.Wrap(err, "error marshaling the redacted payload")
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// redactString applies the regex rules
+func (r *Redactor) redactString(s string) string {
+	for _, rule := range r.rules {
+		if rule.re == nil {
+			continue
+		}
+		mode := rule.Mode
+		s = rule.re.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Pattern == "card" && !luhnValid(match) {
+				return match
+			}
+			return r.redactValue(match, mode)
+		})
+	}
+
+	return s
+}
+
+// redactStrings applies the regex rules to all the strings of the decoded JSON
+func (r *Redactor) redactStrings(value interface{}, changed *bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		redacted := r.redactString(v)
+		*changed = *changed || redacted != v
+		return redacted
+	case json.Number:
+		// numbers like card numbers are matched as strings and replaced by the redacted string
+		if redacted := r.redactString(v.String()); redacted != v.String() {
+			*changed = true
+			return redacted
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = r.redactStrings(item, changed)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactStrings(item, changed)
+		}
+	}
+
+	return value
+}
+
+// redactPath replaces the value by the path, numeric segments index arrays
+func (r *Redactor) redactPath(value interface{}, path []string, mode string, changed *bool) interface{} {
+	if len(path) == 0 {
+		*changed = true
+		if s, ok := value.(string); ok {
+			return r.redactValue(s, mode)
+		}
+		b, _ := json.Marshal(value)
+		return r.redactValue(string(b), mode)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if item, ok := v[path[0]]; ok {
+			v[path[0]] = r.redactPath(item, path[1:], mode, changed)
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(v) {
+			v[i] = r.redactPath(v[i], path[1:], mode, changed)
+		}
+	}
+
+	return value
+}
+
+// redactValue masks the value or replaces it with an HMAC keyed by the salt, so equal values can still be correlated
+// while the hashes of short values like emails or card numbers can't be brute-forced without the salt
+func (r *Redactor) redactValue(value, mode string) string {
+	if mode == RedactModeHash {
+		mac := hmac.New(sha256.New, r.salt)
+		mac.Write([]byte(value))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+
+	return redactMask
+}
+
+// luhnValid checks the card number checksum, so long numbers like ids aren't redacted
+func luhnValid(number string) bool {
+	var sum, n int
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
+
+func checkRedactMode(mode string) error {
+	if mode != RedactModeMask && mode != RedactModeHash {
+		return errors.Errorf("unknown redaction mode %q, use mask or hash", mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	redact := func(t *testing.T, r *Redactor, body string) string {
+		msg, err := r.Redact(types.Message{Body: ptr.String(body)})
+		assert.NoError(t, err)
+		return *msg.Body
+	}
+
+	t.Run("path", func(t *testing.T) {
+		r, err := NewRedactor(RedactConfig{Rules: []RedactRule{
+			ParseRedactRule("body.user.email"),
+			ParseRedactRule("body.items.0.card"),
+		}})
+		assert.NoError(t, err)
+
+		assert.JSONEq(t,
+			`{"user":{"email":"***","name":"N"},"items":[{"card":"***"},{"card":"4111"}],"amount":10.50}`,
+			redact(t, r, `{"user":{"email":"a@b.com","name":"N"},"items":[{"card":"4111"},{"card":"4111"}],"amount":10.50}`))
+
+		// numbers are kept as is
+		assert.Contains(t, redact(t, r, `{"user":{"email":"a@b.com"},"amount":10.50}`), `"amount":10.50`)
+
+		// nothing to redact, the payload is kept
+		assert.Equal(t, `{"b": 1, "a": 2}`, redact(t, r, `{"b": 1, "a": 2}`))
+	})
+
+	t.Run("patterns", func(t *testing.T) {
+		r, err := NewRedactor(RedactConfig{Mode: RedactModeMask, Rules: []RedactRule{
+			ParseRedactRule("pattern:email"),
+			ParseRedactRule("pattern:card"),
+			ParseRedactRule(`re:token=\w+`),
+		}})
+		assert.NoError(t, err)
+
+		assert.JSONEq(t,
+			`{"note":"mail *** now","card":"***","id":"1234567890123","auth":"***&x=1"}`,
+			redact(t, r, `{"note":"mail a.b@c.com now","card":"4111 1111 1111 1111","id":"1234567890123","auth":"token=abc&x=1"}`))
+
+		// a card stored as a number is redacted too, other numbers are kept as is
+		assert.Equal(t, `{"amount":10.50,"card":"***","id":1234567890123}`,
+			redact(t, r, `{"card":4111111111111111,"id":1234567890123,"amount":10.50}`))
+
+		// the string message attributes are redacted, the original message is kept
+		msg := types.Message{
+			Body: ptr.String("{}"),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"user": {DataType: ptr.String("String"), StringValue: ptr.String("a.b@c.com")},
+				"seq":  {DataType: ptr.String("Number"), StringValue: ptr.String("1")},
+			},
+		}
+		redacted, err := r.Redact(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, "***", *redacted.MessageAttributes["user"].StringValue)
+		assert.Equal(t, "1", *redacted.MessageAttributes["seq"].StringValue)
+		assert.Equal(t, "a.b@c.com", *msg.MessageAttributes["user"].StringValue)
+
+		// not JSON
+		assert.Equal(t, "mail *** now", redact(t, r, "mail a.b@c.com now"))
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		rules := []RedactRule{
+			ParseRedactRule("body.email"),
+			{Pattern: "ipv4", Mode: RedactModeMask},
+		}
+		r, err := NewRedactor(RedactConfig{Mode: RedactModeHash, Salt: "secret", Rules: rules})
+		assert.NoError(t, err)
+
+		first := redact(t, r, `{"email":"a@b.com","ip":"10.0.0.1"}`)
+		assert.JSONEq(t, `{"email":"hmac:f2d15403cb47c220","ip":"***"}`, first)
+		// equal values have equal hashes
+		assert.Equal(t, first, redact(t, r, `{"email":"a@b.com","ip":"10.0.0.2"}`))
+
+		// the hashes depend on the salt
+		other, err := NewRedactor(RedactConfig{Mode: RedactModeHash, Salt: "other", Rules: rules})
+		assert.NoError(t, err)
+		assert.NotEqual(t, first, redact(t, other, `{"email":"a@b.com","ip":"10.0.0.1"}`))
+
+		// the hash mode needs a salt
+		_, err = NewRedactor(RedactConfig{Mode: RedactModeHash, Rules: rules})
+		assert.Error(t, err)
+	})
+
+	t.Run("SNS envelope", func(t *testing.T) {
+		r, err := NewRedactor(RedactConfig{Rules: []RedactRule{ParseRedactRule("body.email")}})
+		assert.NoError(t, err)
+
+		rawMessage, err := json.Marshal(`{"email":"a@b.com"}`)
+		assert.NoError(t, err)
+		msg, err := r.Redact(types.Message{Body: getBody(t, aws.EventMessage{Message: (*json.RawMessage)(&rawMessage)})})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"email": "***"}, newMessageFields(msg).Payload())
+	})
+
+	t.Run("bad rules", func(t *testing.T) {
+		for _, rules := range [][]RedactRule{
+			{ParseRedactRule("attr.SenderId")},
+			{ParseRedactRule("re:(")},
+			{ParseRedactRule("pattern:ssn")},
+			{{Path: "body.x", Pattern: "email"}},
+			{{Path: "body.x", Mode: "drop"}},
+		} {
+			_, err := NewRedactor(RedactConfig{Rules: rules})
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestLoadRedactConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redact.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+mode: hash
+salt: secret
+rules:
+  - path: body.user.email
+  - pattern: card
+    mode: mask
+`), 0644))
+
+	cfg, err := LoadRedactConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, RedactConfig{
+		Mode: RedactModeHash,
+		Salt: "secret",
+		Rules: []RedactRule{
+			{Path: "body.user.email"},
+			{Pattern: "card", Mode: RedactModeMask},
+		},
+	}, cfg)
+
+	_, err = NewRedactor(cfg)
+	assert.NoError(t, err)
+}
+
+func TestSQSDumper_ProcessMessagesRedact(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	redactor, err := NewRedactor(RedactConfig{Rules: []RedactRule{ParseRedactRule("body.email")}})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "schema.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"properties":{"email":{"pattern":"@"}},"required":["id"]}`), 0644))
+	schema, err := NewSchemaValidator(path, "")
+	assert.NoError(t, err)
+
+	var out, invalidOut bytes.Buffer
+	dumper := NewSQSDumper(SQSDumperParams{Logger: log, Redactor: redactor, Schema: schema, InvalidOutput: &invalidOut})
+	dumper.out = &out
+	handler := dumper.ProcessMessages(ctx)
+	poller := mock_aws.NewMockSQSPoller(ctrl)
+
+	// the original payload is validated
+	assert.NoError(t, handler(poller, types.Message{Body: ptr.String(`{"id":1,"email":"a@b.com"}`)}))
+	assert.Equal(t, "{\"email\":\"***\",\"id\":1}\n", out.String())
+
+	// the routed message is redacted
+	assert.NoError(t, handler(poller, types.Message{Body: ptr.String(`{"email":"a@b.com"}`)}))
+	var routed InvalidMessage
+	assert.NoError(t, json.Unmarshal(invalidOut.Bytes(), &routed))
+	assert.Equal(t, map[string]interface{}{"email": "***"}, routed.Message)
+}
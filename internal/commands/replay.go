@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// maxRecordSize bounds an archived record line, SQS messages are up to 256KB
+const maxRecordSize = 4 * 1024 * 1024
+
+// ReplayParams holds the replay settings
+type ReplayParams struct {
+	Logger zerolog.Logger
+	Store  aws.ObjectStore
+	// ManifestKey is the manifest of the archive run, the chunks are next to it
+	ManifestKey string
+	// Forward holds the sink, the payload and the retry settings, the concurrency and the failures aren't used
+	Forward SQSForwarderParams
+	// ProgressPath is the file of the sent chunks and offsets, a replay resumes from it, empty doesn't keep the progress
+	ProgressPath string
+}
+
+// ReplayProgress is the position of a replay: the chunks before Chunk and the first Offset records of Chunk are sent
+type ReplayProgress struct {
+	Queue   string    `json:"queue"`
+	Started time.Time `json:"started"`
+	Chunk   int       `json:"chunk"`
+	Offset  int       `json:"offset"`
+}
+
+// Replayer sends the archived messages of a manifest to a sink in order,
+// a chunk is sent only after its checksum and message count are verified
+type Replayer struct {
+	logger       zerolog.Logger
+	store        aws.ObjectStore
+	manifestKey  string
+	forwarder    *SQSForwarder
+	progressPath string
+	progress     ReplayProgress
+	sent         int
+	skipped      int
+}
+
+// NewReplayer returns a new instance
+func NewReplayer(p ReplayParams) *Replayer {
+	p.Forward.Logger = p.Logger
+
+	return &Replayer{
+		logger:       p.Logger,
+		store:        p.Store,
+		manifestKey:  p.ManifestKey,
+		forwarder:    NewSQSForwarder(p.Forward),
+		progressPath: p.ProgressPath,
+	}
+}
+
+// Sent returns the number of the sent messages
+func (r *Replayer) Sent() int {
+	return r.sent
+}
+
+// Skipped returns the number of the messages sent by a previous run
+func (r *Replayer) Skipped() int {
+	return r.skipped
+}
+
+// Run sends the chunks, a failed send stops the replay and the next run resumes from the failed message
+func (r *Replayer) Run(ctx context.Context) error {
+	manifest, err := loadManifest(ctx, r.store, r.manifestKey)
+	if err != nil {
+		return err
+	}
+	if manifest.Redacted {
+		return errors.New("the archive run was written with the redaction rules, its masked payloads can't be replayed")
+	}
+
+	if err := r.loadProgress(manifest); err != nil {
+		return err
+	}
+	r.logger.Info().
+		Str("queue", manifest.Queue).
+		Int("chunks", len(manifest.Chunks)).
+		Int("chunk", r.progress.Chunk).
+		Int("offset", r.progress.Offset).
+		Msg("started replaying")
+
+	for i, chunk := range manifest.Chunks {
+		if i < r.progress.Chunk {
+			r.skipped += chunk.Messages
+			continue
+		}
+		if err := r.replayChunk(ctx, i, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Replayer) replayChunk(ctx context.Context, index int, chunk ArchiveChunk) error {
+	content, err := loadChunk(ctx, r.store, r.manifestKey, chunk)
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	if index == r.progress.Chunk {
+		offset = r.progress.Offset
+		r.skipped += offset
+	}
+
+	scanner := recordScanner(content)
+	for line := 0; scanner.Scan(); line++ {
+		if line < offset {
+			continue
+		}
+
+		var archived ForwardedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+			return errors.Wrapf(err, "bad record %d of %s", line+1, chunk.Name)
+		}
+		if err := r.replay(ctx, archived); err != nil {
+			return errors.Wrapf(err, "error replaying the message %s, record %d of %s", archived.MessageID, line+1, chunk.Name)
+		}
+
+		r.sent++
+		r.progress.Offset = line + 1
+		if err := r.saveProgress(); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "error reading %s", chunk.Name)
+	}
+
+	r.progress.Chunk = index + 1
+	r.progress.Offset = 0
+	return r.saveProgress()
+}
+
+// replay sends the message like forward does, a dry run writes the diff of the transform only
+func (r *Replayer) replay(ctx context.Context, archived ForwardedMessage) error {
+	msg := archived.message()
+	p := r.forwarder
+
+	transformed, err := p.transform.Transform(msg)
+	if err != nil {
+		return err
+	}
+	if p.dryRun {
+		return writePayloadDiff(p.out, archived.MessageID, messagePayload(msg), messagePayload(transformed))
+	}
+
+	record, err := p.record(transformed)
+	if err != nil {
+		return err
+	}
+
+	return p.send(ctx, record)
+}
+
+// loadManifest downloads the manifest of an archive run
+func loadManifest(ctx context.Context, store aws.ObjectStore, key string) (ArchiveManifest, error) {
+	var manifest ArchiveManifest
+	b, err := store.Get(ctx, key)
+	if err != nil {
+		return manifest, errors.Wrap(err, "error reading the manifest")
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return manifest, errors.Wrap(err, "error parsing the manifest")
+	}
+
+	return manifest, nil
+}
+
+// loadChunk downloads the chunk and returns its decompressed content after the checks of the manifest
+func loadChunk(ctx context.Context, store aws.ObjectStore, manifestKey string, chunk ArchiveChunk) ([]byte, error) {
+	body, err := store.Get(ctx, path.Join(path.Dir(manifestKey), chunk.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	if len(body) != chunk.Bytes || hex.EncodeToString(sum[:]) != chunk.SHA256 {
+		return nil, errors.Errorf("the chunk %s doesn't match the manifest checksum", chunk.Name)
+	}
+
+	content, err := gunzip(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decompressing %s", chunk.Name)
+	}
+
+	count := 0
+	scanner := recordScanner(content)
+	for scanner.Scan() {
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", chunk.Name)
+	}
+	if count != chunk.Messages {
+		return nil, errors.Errorf("the chunk %s holds %d messages, the manifest lists %d", chunk.Name, count, chunk.Messages)
+	}
+
+	return content, nil
+}
+
+// loadProgress reads the progress of the manifest run, a progress of another run is an error
+func (r *Replayer) loadProgress(manifest ArchiveManifest) error {
+	r.progress = ReplayProgress{Queue: manifest.Queue, Started: manifest.Started}
+	if r.progressPath == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(r.progressPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error reading the replay progress")
+	}
+
+	var progress ReplayProgress
+	if err := json.Unmarshal(b, &progress); err != nil {
+		return errors.Wrap(err, "error parsing the replay progress")
+	}
+	if progress.Queue != manifest.Queue || !progress.Started.Equal(manifest.Started) {
+		return errors.Errorf("the replay progress %s belongs to another archive run", r.progressPath)
+	}
+	r.progress = progress
+
+	return nil
+}
+
+// saveProgress replaces the progress file, so an interrupted write doesn't lose the position
+func (r *Replayer) saveProgress() error {
+	if r.progressPath == "" || r.forwarder.dryRun {
+		return nil
+	}
+
+	b, err := json.Marshal(r.progress)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling the replay progress")
+	}
+	tmp := r.progressPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "error writing the replay progress")
+	}
+	if err := os.Rename(tmp, r.progressPath); err != nil {
+		return errors.Wrap(err, "error writing the replay progress")
+	}
+
+	return nil
+}
+
+func recordScanner(content []byte) *bufio.Scanner {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+
+	return scanner
+}
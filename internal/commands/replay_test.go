@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeArchive archives the messages in the chunks of two messages and returns the manifest path
+func writeArchive(t *testing.T, dir string, ids ...string) (*Archive, string) {
+	ctx := context.Background()
+	archive := NewArchive(ArchiveParams{
+		Store:         aws.NewDirObjectStore(dir, "bucket"),
+		Prefix:        "dumps/",
+		Queue:         "orders-dlq",
+		Logger:        log,
+		ChunkMessages: 2,
+	})
+	for _, id := range ids {
+		assert.NoError(t, archive.Add(ctx, archivedMessage(id), nil, nil))
+	}
+	assert.NoError(t, archive.Close(ctx))
+
+	return archive, filepath.Join(dir, "bucket", filepath.FromSlash(archive.ManifestKey()))
+}
+
+func sentIDs(sink *fakeSink) []string {
+	ids := make([]string, 0, len(sink.sent))
+	for _, record := range sink.sent {
+		ids = append(ids, record.MessageID)
+	}
+	return ids
+}
+
+func TestReplayer_Run(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	archive, _ := writeArchive(t, dir, "#1", "#2", "#3", "#4", "#5")
+	progressPath := filepath.Join(t.TempDir(), "replay.json")
+
+	sink := &fakeSink{failures: map[string]int{"#4": 1}}
+	newReplayer := func() *Replayer {
+		return NewReplayer(ReplayParams{
+			Logger:       log,
+			Store:        aws.NewDirObjectStore(dir, "bucket"),
+			ManifestKey:  archive.ManifestKey(),
+			Forward:      SQSForwarderParams{Sink: sink},
+			ProgressPath: progressPath,
+		})
+	}
+
+	replayer := newReplayer()
+	err := replayer.Run(ctx)
+	assert.ErrorContains(t, err, "error replaying the message #4, record 2 of chunk-00002.jsonl.gz")
+	assert.Equal(t, 3, replayer.Sent())
+
+	// the next run resumes from the failed message
+	replayer = newReplayer()
+	assert.NoError(t, replayer.Run(ctx))
+	assert.Equal(t, 2, replayer.Sent())
+	assert.Equal(t, 3, replayer.Skipped())
+	assert.Equal(t, []string{"#1", "#2", "#3", "#4", "#5"}, sentIDs(sink))
+	assert.Equal(t, `{"orderId":"#1"}`, string(sink.sent[0].Body))
+
+	// a finished replay sends nothing
+	replayer = newReplayer()
+	assert.NoError(t, replayer.Run(ctx))
+	assert.Equal(t, 0, replayer.Sent())
+	assert.Equal(t, 5, replayer.Skipped())
+}
+
+func TestReplayer_RunLocalManifest(t *testing.T) {
+	dir := t.TempDir()
+	_, manifestPath := writeArchive(t, dir, "#1", "#2", "#3")
+
+	sink := &fakeSink{}
+	replayer := NewReplayer(ReplayParams{
+		Logger:      log,
+		Store:       aws.NewDirObjectStore(filepath.Dir(manifestPath), ""),
+		ManifestKey: ArchiveManifestName,
+		Forward:     SQSForwarderParams{Sink: sink, FullRecord: true},
+	})
+	assert.NoError(t, replayer.Run(context.Background()))
+	assert.Equal(t, []string{"#1", "#2", "#3"}, sentIDs(sink))
+	assert.Contains(t, string(sink.sent[0].Body), `"MessageId":"#1"`)
+}
+
+func TestReplayer_RunRedacted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	archive := NewArchive(ArchiveParams{Store: aws.NewDirObjectStore(dir, "bucket"), Logger: log})
+	archive.markRedacted()
+	assert.NoError(t, archive.Add(ctx, archivedMessage("#1"), nil, nil))
+	assert.NoError(t, archive.Close(ctx))
+
+	// the masked payloads aren't sent
+	sink := &fakeSink{}
+	replayer := NewReplayer(ReplayParams{
+		Logger:      log,
+		Store:       aws.NewDirObjectStore(dir, "bucket"),
+		ManifestKey: archive.ManifestKey(),
+		Forward:     SQSForwarderParams{Sink: sink},
+	})
+	assert.ErrorContains(t, replayer.Run(ctx), "masked payloads can't be replayed")
+	assert.Empty(t, sink.sent)
+}
+
+func TestReplayer_RunVerify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("checksum", func(t *testing.T) {
+		dir := t.TempDir()
+		archive, manifestPath := writeArchive(t, dir, "#1", "#2", "#3")
+		chunkPath := filepath.Join(filepath.Dir(manifestPath), archive.Manifest().Chunks[1].Name)
+		body, err := os.ReadFile(chunkPath)
+		assert.NoError(t, err)
+		body[len(body)-1] ^= 0xff
+		assert.NoError(t, os.WriteFile(chunkPath, body, 0644))
+
+		sink := &fakeSink{}
+		replayer := NewReplayer(ReplayParams{
+			Logger:      log,
+			Store:       aws.NewDirObjectStore(dir, "bucket"),
+			ManifestKey: archive.ManifestKey(),
+			Forward:     SQSForwarderParams{Sink: sink},
+		})
+		assert.EqualError(t, replayer.Run(ctx), "the chunk chunk-00002.jsonl.gz doesn't match the manifest checksum")
+		assert.Equal(t, []string{"#1", "#2"}, sentIDs(sink))
+	})
+
+	t.Run("count", func(t *testing.T) {
+		dir := t.TempDir()
+		archive, manifestPath := writeArchive(t, dir, "#1", "#2")
+		manifest, err := os.ReadFile(manifestPath)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(manifestPath, bytes.Replace(manifest, []byte(`"messages": 2`), []byte(`"messages": 3`), 1), 0644))
+
+		sink := &fakeSink{}
+		replayer := NewReplayer(ReplayParams{
+			Logger:      log,
+			Store:       aws.NewDirObjectStore(dir, "bucket"),
+			ManifestKey: archive.ManifestKey(),
+			Forward:     SQSForwarderParams{Sink: sink},
+		})
+		assert.EqualError(t, replayer.Run(ctx), "the chunk chunk-00001.jsonl.gz holds 2 messages, the manifest lists 3")
+		assert.Empty(t, sink.sent)
+	})
+
+	t.Run("another run", func(t *testing.T) {
+		dir := t.TempDir()
+		archive, _ := writeArchive(t, dir, "#1")
+		progressPath := filepath.Join(t.TempDir(), "replay.json")
+		assert.NoError(t, os.WriteFile(progressPath, []byte(`{"queue":"orders-dlq","started":"2022-10-19T15:04:05Z","chunk":1}`), 0644))
+
+		replayer := NewReplayer(ReplayParams{
+			Logger:       log,
+			Store:        aws.NewDirObjectStore(dir, "bucket"),
+			ManifestKey:  archive.ManifestKey(),
+			Forward:      SQSForwarderParams{Sink: &fakeSink{}},
+			ProgressPath: progressPath,
+		})
+		assert.ErrorContains(t, replayer.Run(ctx), "belongs to another archive run")
+	})
+}
+
+func TestReplayer_RunDryRun(t *testing.T) {
+	dir := t.TempDir()
+	archive, _ := writeArchive(t, dir, "#1")
+	transformer, err := NewTransformer(nil, []string{"body.status=replayed"})
+	assert.NoError(t, err)
+
+	replayer := NewReplayer(ReplayParams{
+		Logger:      log,
+		Store:       aws.NewDirObjectStore(dir, "bucket"),
+		ManifestKey: archive.ManifestKey(),
+		Forward:     SQSForwarderParams{Transformer: transformer, DryRun: true},
+	})
+	var out bytes.Buffer
+	replayer.forwarder.out = &out
+	assert.NoError(t, replayer.Run(context.Background()))
+	assert.Contains(t, out.String(), `+  "status": "replayed"`)
+}
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// report formats
+const (
+	ReportFormatTable = "table"
+	ReportFormatJSON  = "json"
+)
+
+// missingValue is the group key value of a message without the field
+const missingValue = "<none>"
+
+// SQSReporterParams holds SQSReporter params
+type SQSReporterParams struct {
+	Logger zerolog.Logger
+	// GroupBy are field paths like body.errorType or attr.SenderId
+	GroupBy []string
+	Format  string
+	// Decoder renders base64 binary payloads as JSON, nil keeps the payloads as is
+	Decoder PayloadDecoder
+}
+
+// ReportGroup holds the aggregated stats of the messages with the same group key
+type ReportGroup struct {
+	Key             map[string]string `json:"key"`
+	Count           int               `json:"count"`
+	FirstSent       *time.Time        `json:"firstSent,omitempty"`
+	LastSent        *time.Time        `json:"lastSent,omitempty"`
+	ReceiveCounts   map[int]int       `json:"receiveCounts"`
+	SampleMessageID string            `json:"sampleMessageId"`
+}
+
+// SQSReporter is a command to aggregate a queue's contents by the group key without consuming them
+type SQSReporter struct {
+	logger  zerolog.Logger
+	groupBy []string
+	format  string
+	decoder PayloadDecoder
+	groups  map[string]*ReportGroup
+	scanner *queueScanner
+	out     io.Writer
+}
+
+// NewSQSReporter returns a new instance
+func NewSQSReporter(p SQSReporterParams) SQSReporter {
+	return SQSReporter{
+		logger:  p.Logger,
+		groupBy: p.GroupBy,
+		format:  p.Format,
+		decoder: p.Decoder,
+		groups:  map[string]*ReportGroup{},
+		scanner: newQueueScanner(p.Logger),
+		out:     os.Stdout,
+	}
+}
+
+// ProcessMessages returns aws.MessageHandler type func which adds the incoming message to its group
+func (p *SQSReporter) ProcessMessages(_ context.Context) aws.MessageHandler {
+	p.logger.Info().Strs("groupBy", p.groupBy).Msg("started scanning")
+	return func(_ aws.SQSPoller, msg types.Message) error {
+		if p.scanner.Seen(msg) {
+			return aws.ErrReceivedAgain
+		}
+
+		fields := newMessageFields(decodeOrKeep(p.logger, msg, p.decoder))
+		key := make(map[string]string, len(p.groupBy))
+		values := make([]string, 0, len(p.groupBy))
+		for _, path := range p.groupBy {
+			value := missingValue
+			if v, ok := fields.Get(path); ok {
+				value = stringify(v)
+			}
+			key[path] = value
+			values = append(values, value)
+		}
+
+		groupID := strings.Join(values, "\x00")
+		group, ok := p.groups[groupID]
+		if !ok {
+			group = &ReportGroup{
+				Key:             key,
+				ReceiveCounts:   map[int]int{},
+				SampleMessageID: aws.MessageID(msg),
+			}
+			p.groups[groupID] = group
+		}
+
+		group.Count++
+		if count, ok := aws.ReceiveCount(msg); ok {
+			group.ReceiveCounts[count]++
+		}
+		if sent, ok := aws.SentTimestamp(msg); ok {
+			if group.FirstSent == nil || sent.Before(*group.FirstSent) {
+				group.FirstSent = &sent
+			}
+			if group.LastSent == nil || sent.After(*group.LastSent) {
+				group.LastSent = &sent
+			}
+		}
+
+		return nil
+	}
+}
+
+// Groups returns the groups sorted by the count, the biggest first
+func (p *SQSReporter) Groups() []*ReportGroup {
+	groups := make([]*ReportGroup, 0, len(p.groups))
+	for _, group := range p.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].SampleMessageID < groups[j].SampleMessageID
+	})
+
+	return groups
+}
+
+// Print prints the report in the table or JSON format
+func (p *SQSReporter) Print() error {
+	groups := p.Groups()
+	if p.format == ReportFormatJSON {
+		enc := json.NewEncoder(p.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	header := append(append([]string{}, p.groupBy...), "COUNT", "FIRST SENT", "LAST SENT", "RECEIVE COUNTS", "SAMPLE")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, group := range groups {
+		row := make([]string, 0, len(header))
+		for _, path := range p.groupBy {
+			row = append(row, group.Key[path])
+		}
+		row = append(row,
+			strconv.Itoa(group.Count),
+			formatTime(group.FirstSent),
+			formatTime(group.LastSent),
+			formatReceiveCounts(group.ReceiveCounts),
+			group.SampleMessageID,
+		)
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "error printing the report")
+	}
+
+	return nil
+}
+
+// HoldFiltered returns aws.MessageHandler type func which holds the filtered messages until the Release
+func (p *SQSReporter) HoldFiltered() aws.MessageHandler {
+	return p.scanner.holdFiltered()
+}
+
+// Release makes the held messages visible again
+func (p *SQSReporter) Release(ctx context.Context, sqsPoller aws.SQSPoller) error {
+	return p.scanner.Release(ctx, sqsPoller)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// formatReceiveCounts returns the distribution like 1:10 2:3
+func formatReceiveCounts(counts map[int]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+
+	receives := make([]int, 0, len(counts))
+	for receive := range counts {
+		receives = append(receives, receive)
+	}
+	sort.Ints(receives)
+
+	parts := make([]string, 0, len(receives))
+	for _, receive := range receives {
+		parts = append(parts, fmt.Sprintf("%d:%d", receive, counts[receive]))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQSReporter_ProcessMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	msg := func(id, body, receives, sent string) types.Message {
+		return types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(body),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): receives,
+				string(types.MessageSystemAttributeNameSentTimestamp):           sent,
+			},
+		}
+	}
+
+	messages := []types.Message{
+		msg("#1", `{"errorType":"timeout"}`, "1", "1600000002000"),
+		msg("#2", `{"errorType":"timeout"}`, "3", "1600000001000"),
+		msg("#3", `{"errorType":"auth"}`, "1", "1600000003000"),
+		msg("#4", `not json`, "1", "1600000004000"),
+		msg("#5", `{"errorType":"timeout"}`, "1", "1600000005000"),
+	}
+
+	newReporter := func(t *testing.T, format string) (SQSReporter, *bytes.Buffer) {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil).Times(len(messages))
+
+		reporter := NewSQSReporter(SQSReporterParams{Logger: log, GroupBy: []string{"body.errorType"}, Format: format})
+		var out bytes.Buffer
+		reporter.out = &out
+
+		handler := reporter.ProcessMessages(ctx)
+		for _, m := range messages {
+			assert.NoError(t, handler(poller, m))
+		}
+		assert.ErrorIs(t, handler(poller, messages[0]), aws.ErrReceivedAgain)
+		assert.NoError(t, reporter.Release(ctx, poller))
+
+		return reporter, &out
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		reporter, _ := newReporter(t, ReportFormatTable)
+
+		groups := reporter.Groups()
+		assert.Len(t, groups, 3)
+
+		first := time.UnixMilli(1600000001000).UTC()
+		last := time.UnixMilli(1600000005000).UTC()
+		assert.Equal(t, &ReportGroup{
+			Key:             map[string]string{"body.errorType": "timeout"},
+			Count:           3,
+			FirstSent:       &first,
+			LastSent:        &last,
+			ReceiveCounts:   map[int]int{1: 2, 3: 1},
+			SampleMessageID: "#1",
+		}, groups[0])
+		assert.Equal(t, map[string]string{"body.errorType": "auth"}, groups[1].Key)
+		assert.Equal(t, map[string]string{"body.errorType": missingValue}, groups[2].Key)
+	})
+
+	t.Run("table", func(t *testing.T) {
+		reporter, out := newReporter(t, ReportFormatTable)
+		assert.NoError(t, reporter.Print())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, lines, 4)
+		assert.Equal(t, []string{"body.errorType", "COUNT", "FIRST", "SENT", "LAST", "SENT", "RECEIVE", "COUNTS", "SAMPLE"}, strings.Fields(lines[0]))
+		assert.Equal(t, []string{"timeout", "3", "2020-09-13T12:26:41Z", "2020-09-13T12:26:45Z", "1:2", "3:1", "#1"}, strings.Fields(lines[1]))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		reporter, out := newReporter(t, ReportFormatJSON)
+		assert.NoError(t, reporter.Print())
+
+		var groups []ReportGroup
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &groups))
+		assert.Len(t, groups, 3)
+		assert.Equal(t, 3, groups[0].Count)
+		assert.Equal(t, map[int]int{1: 2, 3: 1}, groups[0].ReceiveCounts)
+	})
+}
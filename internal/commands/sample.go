@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/rs/zerolog"
+)
+
+// SQSSamplerParams holds SQSSampler params, either Size or Rate is set
+type SQSSamplerParams struct {
+	Logger zerolog.Logger
+	// Size is the number of the sampled messages, every message of the queue has the same chance to be sampled
+	Size int
+	// Rate is the chance of a message to be sampled, like 0.01
+	Rate float64
+	// Rand picks the sampled messages, nil is seeded with the current time
+	Rand *rand.Rand
+}
+
+// SQSSampler is a command to pick a representative set of a queue's messages in a full pass without consuming them
+type SQSSampler struct {
+	logger  zerolog.Logger
+	size    int
+	rate    float64
+	rand    *rand.Rand
+	scanner *queueScanner
+	seen    int
+	sampled []sampledMessage
+}
+
+// sampledMessage keeps the position of the message in the pass, so the sample is printed in the queue order
+type sampledMessage struct {
+	index int
+	msg   types.Message
+}
+
+// NewSQSSampler returns a new instance
+func NewSQSSampler(p SQSSamplerParams) SQSSampler {
+	r := p.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return SQSSampler{
+		logger:  p.Logger,
+		size:    p.Size,
+		rate:    p.Rate,
+		rand:    r,
+		scanner: newQueueScanner(p.Logger),
+	}
+}
+
+// ProcessMessages returns aws.MessageHandler type func which adds the incoming message to the sample,
+// the size sample is a reservoir: the n-th message replaces a random sampled one with the chance size/n
+func (p *SQSSampler) ProcessMessages(_ context.Context) aws.MessageHandler {
+	p.logger.Info().Int("size", p.size).Float64("rate", p.rate).Msg("started sampling")
+	return func(_ aws.SQSPoller, msg types.Message) error {
+		if p.scanner.Seen(msg) {
+			return aws.ErrReceivedAgain
+		}
+
+		p.seen++
+		sampled := sampledMessage{index: p.seen, msg: msg}
+		switch {
+		case p.size <= 0:
+			if p.rand.Float64() < p.rate {
+				p.sampled = append(p.sampled, sampled)
+			}
+		case len(p.sampled) < p.size:
+			p.sampled = append(p.sampled, sampled)
+		default:
+			if i := p.rand.Intn(p.seen); i < p.size {
+				p.sampled[i] = sampled
+			}
+		}
+
+		return nil
+	}
+}
+
+// Seen returns the number of the scanned messages
+func (p *SQSSampler) Seen() int {
+	return p.seen
+}
+
+// Sampled returns the sampled messages in the order of the pass
+func (p *SQSSampler) Sampled() []types.Message {
+	sampled := append([]sampledMessage{}, p.sampled...)
+	sort.Slice(sampled, func(i, j int) bool {
+		return sampled[i].index < sampled[j].index
+	})
+
+	messages := make([]types.Message, 0, len(sampled))
+	for _, s := range sampled {
+		messages = append(messages, s.msg)
+	}
+
+	return messages
+}
+
+// Print passes the sampled messages to the handler, like the dumper printing them
+func (p *SQSSampler) Print(sqsPoller aws.SQSPoller, handler aws.MessageHandler) error {
+	for _, msg := range p.Sampled() {
+		if err := handler(sqsPoller, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HoldFiltered returns aws.MessageHandler type func which holds the filtered messages until the Release
+func (p *SQSSampler) HoldFiltered() aws.MessageHandler {
+	return p.scanner.holdFiltered()
+}
+
+// Release makes the held messages visible again
+func (p *SQSSampler) Release(ctx context.Context, sqsPoller aws.SQSPoller) error {
+	return p.scanner.Release(ctx, sqsPoller)
+}
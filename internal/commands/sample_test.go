@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	mock_aws "andboson/sqsdumper/internal/mocks/mock_sqs"
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQSSampler_ProcessMessages(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	messages := make([]types.Message, 100)
+	for i := range messages {
+		id := strconv.Itoa(i)
+		messages[i] = types.Message{
+			MessageId:     ptr.String(id),
+			ReceiptHandle: ptr.String("rh-" + id),
+			Body:          ptr.String(`{"n":` + id + `}`),
+		}
+	}
+
+	sample := func(t *testing.T, p SQSSamplerParams) SQSSampler {
+		poller := mock_aws.NewMockSQSPoller(ctrl)
+		poller.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil).Times(len(messages))
+
+		p.Logger = log
+		p.Rand = rand.New(rand.NewSource(1))
+		sampler := NewSQSSampler(p)
+		handler := sampler.ProcessMessages(ctx)
+		for _, m := range messages {
+			assert.NoError(t, handler(poller, m))
+		}
+		assert.ErrorIs(t, handler(poller, messages[0]), aws.ErrReceivedAgain)
+		assert.NoError(t, sampler.Release(ctx, poller))
+		assert.Equal(t, len(messages), sampler.Seen())
+
+		return sampler
+	}
+
+	ids := func(messages []types.Message) []int {
+		var ids []int
+		for _, m := range messages {
+			id, _ := strconv.Atoi(aws.MessageID(m))
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	t.Run("size", func(t *testing.T) {
+		sampler := sample(t, SQSSamplerParams{Size: 10})
+
+		sampled := ids(sampler.Sampled())
+		assert.Len(t, sampled, 10)
+		assert.IsIncreasing(t, sampled)
+		// the reservoir keeps the later messages too, unlike the first N
+		assert.Greater(t, sampled[len(sampled)-1], 10)
+	})
+
+	t.Run("size above the queue", func(t *testing.T) {
+		sampler := sample(t, SQSSamplerParams{Size: 1000})
+
+		assert.Len(t, sampler.Sampled(), len(messages))
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		sampler := sample(t, SQSSamplerParams{Rate: 0.2})
+
+		sampled := ids(sampler.Sampled())
+		assert.InDelta(t, 20, len(sampled), 12)
+		assert.IsIncreasing(t, sampled)
+	})
+
+	t.Run("print", func(t *testing.T) {
+		sampler := sample(t, SQSSamplerParams{Size: 3})
+
+		var printed []types.Message
+		assert.NoError(t, sampler.Print(nil, func(_ aws.SQSPoller, msg types.Message) error {
+			printed = append(printed, msg)
+			return nil
+		}))
+		assert.Equal(t, sampler.Sampled(), printed)
+	})
+}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// DefaultSchemaKey selects the schema of a directory by the SNS topic
+const DefaultSchemaKey = "sns.TopicArn"
+
+// schemaExt is the extension of the schema files in a directory
+const schemaExt = ".json"
+
+// InvalidMessage is a printed message which payload doesn't match its schema
+type InvalidMessage struct {
+	MessageID string      `json:"MessageId"`
+	Schema    string      `json:"Schema"`
+	Errors    []string    `json:"Errors"`
+	Message   interface{} `json:"Message"`
+}
+
+// SchemaValidator validates decoded payloads against a JSON Schema file
+// or against the schemas of a directory selected by a message field
+type SchemaValidator struct {
+	path    string
+	key     string
+	single  *gojsonschema.Schema
+	schemas map[string]*gojsonschema.Schema
+}
+
+// NewSchemaValidator loads the schema file or prepares the schema directory,
+// the schema of a message is <dir>/<key value>.json, for an ARN the resource name is used,
+// like orders.json for arn:aws:sns:us-east-1:123456789012:orders
+func NewSchemaValidator(path, key string) (*SchemaValidator, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the schema")
+	}
+
+	v := &SchemaValidator{
+		path:    path,
+		key:     key,
+		schemas: map[string]*gojsonschema.Schema{},
+	}
+	if v.key == "" {
+		v.key = DefaultSchemaKey
+	}
+
+	if !info.IsDir() {
+		if v.single, err = loadSchema(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// Validate returns the validation errors of the message payload, empty for a valid one,
+// the schema is empty when the message has no schema and isn't validated
+func (v *SchemaValidator) Validate(fields *messageFields) (schema string, errs []string, err error) {
+	schema = v.schemaPath(fields)
+	if schema == "" {
+		return "", nil, nil
+	}
+
+	compiled, err := v.schema(schema)
+	if err != nil || compiled == nil {
+		return "", nil, err
+	}
+
+	result, err := compiled.Validate(gojsonschema.NewGoLoader(fields.Payload()))
+	if err != nil {
+		return schema, nil, errors.Wrap(err, "error validating the payload")
+	}
+
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+
+	return schema, errs, nil
+}
+
+// schemaPath returns the schema file of the message, empty if the message has no key field
+func (v *SchemaValidator) schemaPath(fields *messageFields) string {
+	if v.single != nil {
+		return v.path
+	}
+
+	value, ok := fields.Get(v.key)
+	if !ok {
+		return ""
+	}
+	name := stringify(value)
+	if strings.HasPrefix(name, "arn:") {
+		name = name[strings.LastIndex(name, ":")+1:]
+	}
+	// the value must not escape the directory
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+
+	return filepath.Join(v.path, name+schemaExt)
+}
+
+// schema returns the compiled schema, nil for a missing schema file
+func (v *SchemaValidator) schema(path string) (*gojsonschema.Schema, error) {
+	if v.single != nil {
+		return v.single, nil
+	}
+
+	if schema, ok := v.schemas[path]; ok {
+		return schema, nil
+	}
+
+	var schema *gojsonschema.Schema
+	if _, err := os.Stat(path); err == nil {
+		if schema, err = loadSchema(path); err != nil {
+			return nil, err
+		}
+	}
+	v.schemas[path] = schema
+
+	return schema, nil
+}
+
+func loadSchema(path string) (*gojsonschema.Schema, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading the schema %s", path)
+	}
+
+	// a reference loader resolves relative $ref against the schema file
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(abs)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading the schema %s", path)
+	}
+
+	return schema, nil
+}
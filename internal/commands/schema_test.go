@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/assert"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["orderId"],
+	"properties": {"orderId": {"type": "string"}}
+}`
+
+func TestSchemaValidator_Validate(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "orders.json"), []byte(orderSchema), 0644))
+
+	snsMessage := func(t *testing.T, topic, payload string) types.Message {
+		rawMessage, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		return types.Message{Body: getBody(t, aws.EventMessage{
+			TopicARN: topic,
+			Message:  (*json.RawMessage)(&rawMessage),
+		})}
+	}
+
+	t.Run("single file", func(t *testing.T) {
+		path := filepath.Join(dir, "orders.json")
+		v, err := NewSchemaValidator(path, "")
+		assert.NoError(t, err)
+
+		schema, errs, err := v.Validate(newMessageFields(types.Message{Body: ptr.String(`{"orderId":"X"}`)}))
+		assert.NoError(t, err)
+		assert.Equal(t, path, schema)
+		assert.Empty(t, errs)
+
+		schema, errs, err = v.Validate(newMessageFields(types.Message{Body: ptr.String(`{"orderId":42}`)}))
+		assert.NoError(t, err)
+		assert.Equal(t, path, schema)
+		assert.Equal(t, []string{"orderId: Invalid type. Expected: string, given: integer"}, errs)
+	})
+
+	t.Run("directory by the topic", func(t *testing.T) {
+		v, err := NewSchemaValidator(dir, DefaultSchemaKey)
+		assert.NoError(t, err)
+
+		schema, errs, err := v.Validate(newMessageFields(snsMessage(t, "arn:aws:sns:us-east-1:123456789012:orders", `{}`)))
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "orders.json"), schema)
+		assert.Equal(t, []string{"(root): orderId is required"}, errs)
+
+		// no schema for the topic
+		schema, errs, err = v.Validate(newMessageFields(snsMessage(t, "arn:aws:sns:us-east-1:123456789012:users", `{}`)))
+		assert.NoError(t, err)
+		assert.Empty(t, schema)
+		assert.Empty(t, errs)
+	})
+
+	t.Run("directory by a message attribute", func(t *testing.T) {
+		v, err := NewSchemaValidator(dir, "msgattr.type")
+		assert.NoError(t, err)
+
+		msg := func(typ string) types.Message {
+			return types.Message{
+				Body: ptr.String(`{}`),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"type": {DataType: ptr.String("String"), StringValue: ptr.String(typ)},
+				},
+			}
+		}
+
+		_, errs, err := v.Validate(newMessageFields(msg("orders")))
+		assert.NoError(t, err)
+		assert.Len(t, errs, 1)
+
+		// the value must not escape the directory
+		schema, _, err := v.Validate(newMessageFields(msg("../orders")))
+		assert.NoError(t, err)
+		assert.Empty(t, schema)
+	})
+
+	t.Run("bad schema", func(t *testing.T) {
+		_, err := NewSchemaValidator(filepath.Join(dir, "missing.json"), "")
+		assert.Error(t, err)
+
+		bad := filepath.Join(t.TempDir(), "bad.json")
+		assert.NoError(t, os.WriteFile(bad, []byte(`{"type": 42}`), 0644))
+		_, err = NewSchemaValidator(bad, "")
+		assert.Error(t, err)
+	})
+}
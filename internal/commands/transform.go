@@ -0,0 +1,271 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/itchyny/gojq"
+	"github.com/pkg/errors"
+)
+
+// transform prefixes, a transform without a prefix is a jq expression
+const (
+	transformJQ       = "jq:"
+	transformTemplate = "tmpl:"
+)
+
+// transformStep returns the new payload
+type transformStep func(payload string, meta map[string]interface{}) (string, error)
+
+// Transformer rewrites the message payload, which is the SNS envelope Message or the body,
+// by jq expressions, Go templates and path assignments applied in order
+type Transformer struct {
+	steps []transformStep
+}
+
+// NewTransformer returns a new instance of the transforms like 'jq:.status = "new"' or 'tmpl:{{.Body.id}}'
+// followed by the assignments like body.status=new, the value is a JSON literal or a bare word
+func NewTransformer(transforms, sets []string) (*Transformer, error) {
+	t := &Transformer{}
+
+	for _, src := range transforms {
+		var (
+			step transformStep
+			err  error
+		)
+		switch {
+		case strings.HasPrefix(src, transformTemplate):
+			step, err = templateStep(strings.TrimPrefix(src, transformTemplate))
+		default:
+			step, err = jqStep(strings.TrimPrefix(src, transformJQ))
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.steps = append(t.steps, step)
+	}
+
+	for _, src := range sets {
+		query, err := setQuery(src)
+		if err != nil {
+			return nil, err
+		}
+		step, err := jqStep(query)
+		if err != nil {
+			return nil, err
+		}
+		t.steps = append(t.steps, step)
+	}
+
+	return t, nil
+}
+
+// Transform returns the message with the transformed payload
+func (t *Transformer) Transform(msg types.Message) (types.Message, error) {
+	if t == nil || len(t.steps) == 0 {
+		return msg, nil
+	}
+
+	meta := newMessageFields(msg).Meta()
+	return replacePayload(msg, func(payload string) (string, bool, error) {
+		transformed := payload
+		for _, step := range t.steps {
+			var err error
+			if transformed, err = step(transformed, meta); err != nil {
+				return payload, false, err
+			}
+		}
+
+		return transformed, transformed != payload, nil
+	})
+}
+
+// jqStep runs the jq expression which must return a single value,
+// a string result replaces a payload which is not JSON as is
+func jqStep(src string) (transformStep, error) {
+	query, err := ParseQuery(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad transform")
+	}
+
+	return func(payload string, meta map[string]interface{}) (string, error) {
+		value, isJSON := decodeJSONNumbers(payload)
+		results, err := query.Run(value, meta)
+		if err != nil {
+			return "", errors.Wrapf(err, "transform %q", src)
+		}
+		if len(results) != 1 {
+			return "", errors.Errorf("transform %q returned %d values, expected one", src, len(results))
+		}
+
+		if s, ok := results[0].(string); ok && !isJSON {
+			return s, nil
+		}
+		b, err := gojq.Marshal(results[0])
+		if err != nil {
+			return "", errors.Wrap(err, "error marshaling the transform result")
+		}
+
+		return string(b), nil
+	}, nil
+}
+
+// templateStep executes the Go template with .Body holding the decoded payload and .Meta the metadata,
+// the output is the new payload
+func templateStep(src string) (transformStep, error) {
+	tmpl, err := template.New("transform").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				var b bytes.Buffer
+				enc := json.NewEncoder(&b)
+				enc.SetEscapeHTML(false)
+				err := enc.Encode(v)
+				return strings.TrimSuffix(b.String(), "\n"), err
+			},
+		}).
+		Parse(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad transform template")
+	}
+
+	return func(payload string, meta map[string]interface{}) (string, error) {
+		value, _ := decodeJSONNumbers(payload)
+
+		var out strings.Builder
+		if err := tmpl.Execute(&out, map[string]interface{}{"Body": value, "Meta": meta}); err != nil {
+			return "", errors.Wrap(err, "transform template")
+		}
+
+		return strings.TrimSpace(out.String()), nil
+	}, nil
+}
+
+// setQuery returns the jq assignment of the body.x.y=value shorthand
+func setQuery(src string) (string, error) {
+	path, literal, ok := strings.Cut(src, "=")
+	path = strings.TrimSpace(path)
+	if !ok || (path != fieldBody && !strings.HasPrefix(path, fieldBody+".")) {
+		return "", errors.Errorf("bad --set value %q, expected body.x.y=value", src)
+	}
+
+	var value interface{}
+	literal = strings.TrimSpace(literal)
+	if err := json.Unmarshal([]byte(literal), &value); err != nil {
+		value = literal
+	}
+	b, err := gojq.Marshal(value)
+	if err != nil {
+		return "", errors.Wrapf(err, "bad --set value %q", src)
+	}
+
+	target, err := JSONPathQuery(strings.TrimPrefix(path, fieldBody))
+	if err != nil {
+		return "", errors.Wrapf(err, "bad --set value %q", src)
+	}
+
+	return target + " = " + string(b), nil
+}
+
+// decodeJSONNumbers decodes the JSON payload keeping the numbers exact,
+// a payload which is not JSON is returned as a string
+func decodeJSONNumbers(payload string) (interface{}, bool) {
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return payload, false
+	}
+
+	return value, true
+}
+
+// messagePayload returns the SNS envelope Message or the message body
+func messagePayload(msg types.Message) string {
+	var payload string
+	_, _ = replacePayload(msg, func(p string) (string, bool, error) {
+		payload = p
+		return p, false, nil
+	})
+
+	return payload
+}
+
+// writePayloadDiff writes the line diff of the pretty-printed payloads
+func writePayloadDiff(w io.Writer, id, before, after string) error {
+	if before == after {
+		_, err := fmt.Fprintf(w, "=== %s unchanged\n", id)
+		return err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s original\n+++ %s transformed\n", id, id)
+	for _, line := range lineDiff(prettyLines(before), prettyLines(after)) {
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func prettyLines(payload string) []string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(payload), "", "  "); err == nil {
+		payload = b.String()
+	}
+
+	return strings.Split(payload, "\n")
+}
+
+// lineDiff returns the lines prefixed by ' ' for common, '-' for removed and '+' for added ones
+func lineDiff(a, b []string) []string {
+	// lcs[i][j] is the longest common subsequence length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+
+	return diff
+}
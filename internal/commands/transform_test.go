@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformer_Transform(t *testing.T) {
+	msg := types.Message{
+		MessageId: ptr.String("#1"),
+		Body:      ptr.String(`{"orderId":12345678901234567890,"status":"failed","items":[{"sku":"A"}]}`),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: ptr.String("String"), StringValue: ptr.String("order")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		transforms []string
+		sets       []string
+		body       string
+	}{
+		{
+			name:       "jq",
+			transforms: []string{`.status = "pending" | del(.items)`},
+			body:       `{"orderId":12345678901234567890,"status":"pending"}`,
+		},
+		{
+			name:       "jq prefix and meta",
+			transforms: []string{`jq:.type = $meta.MessageAttributes.type`},
+			body:       `{"items":[{"sku":"A"}],"orderId":12345678901234567890,"status":"failed","type":"order"}`,
+		},
+		{
+			name:       "template",
+			transforms: []string{`tmpl:{"id": {{json .Body.orderId}}, "sku": "{{(index .Body.items 0).sku}}", "msg": "{{.Meta.MessageId}}"}`},
+			body:       `{"id": 12345678901234567890, "sku": "A", "msg": "#1"}`,
+		},
+		{
+			name: "set",
+			sets: []string{"body.status=pending", "body.items[0].qty=2", "body.retry=true"},
+			body: `{"items":[{"qty":2,"sku":"A"}],"orderId":12345678901234567890,"retry":true,"status":"pending"}`,
+		},
+		{
+			name:       "transform then set",
+			transforms: []string{`{orderId}`},
+			sets:       []string{`body.note="fixed, 42"`},
+			body:       `{"note":"fixed, 42","orderId":12345678901234567890}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformer, err := NewTransformer(tt.transforms, tt.sets)
+			assert.NoError(t, err)
+
+			transformed, err := transformer.Transform(msg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.body, *transformed.Body)
+		})
+	}
+
+	t.Run("sns", func(t *testing.T) {
+		rawMessage := json.RawMessage(`"{\"status\":\"failed\"}"`)
+		transformer, err := NewTransformer(nil, []string{"body.status=pending"})
+		assert.NoError(t, err)
+
+		transformed, err := transformer.Transform(types.Message{Body: getBody(t, aws.EventMessage{
+			TopicARN: "arn:orders",
+			Message:  &rawMessage,
+		})})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"status":"pending"}`, messagePayload(transformed))
+		envelope, err := aws.ParseEventMessage(*transformed.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:orders", envelope.TopicARN)
+	})
+
+	t.Run("not JSON", func(t *testing.T) {
+		transformer, err := NewTransformer([]string{`ascii_upcase`}, nil)
+		assert.NoError(t, err)
+
+		transformed, err := transformer.Transform(types.Message{Body: ptr.String("plain text")})
+		assert.NoError(t, err)
+		assert.Equal(t, "PLAIN TEXT", *transformed.Body)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := NewTransformer([]string{`.[`}, nil)
+		assert.Error(t, err)
+		_, err = NewTransformer([]string{`tmpl:{{.Body`}, nil)
+		assert.Error(t, err)
+		_, err = NewTransformer(nil, []string{"status=pending"})
+		assert.EqualError(t, err, `bad --set value "status=pending", expected body.x.y=value`)
+
+		transformer, err := NewTransformer([]string{`.items[]`}, nil)
+		assert.NoError(t, err)
+		_, err = transformer.Transform(types.Message{Body: ptr.String(`{"items":[1,2]}`)})
+		assert.EqualError(t, err, `transform ".items[]" returned 2 values, expected one`)
+
+		transformer, err = NewTransformer([]string{`tmpl:{{.Body.missing}}`}, nil)
+		assert.NoError(t, err)
+		_, err = transformer.Transform(msg)
+		assert.Error(t, err)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var transformer *Transformer
+		transformed, err := transformer.Transform(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, msg, transformed)
+	})
+}
+
+func TestWritePayloadDiff(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, writePayloadDiff(&out, "#1", `{"id":1,"status":"failed"}`, `{"id":1,"status":"pending"}`))
+	assert.NoError(t, writePayloadDiff(&out, "#2", `{"id":2}`, `{"id":2}`))
+
+	assert.Equal(t, `--- #1 original
++++ #1 transformed
+ {
+   "id": 1,
+-  "status": "failed"
++  "status": "pending"
+ }
+=== #2 unchanged
+`, out.String())
+}
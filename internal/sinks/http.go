@@ -0,0 +1,82 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MessageIDHeader holds the MessageId of the forwarded message
+const MessageIDHeader = "X-Sqs-Message-Id"
+
+// HTTPSinkParams holds HTTPSink params
+type HTTPSinkParams struct {
+	URL     string
+	Method  string
+	Headers http.Header
+	Timeout time.Duration
+}
+
+// HTTPSink sends the records to a webhook, a 2xx response is the acknowledgement
+type HTTPSink struct {
+	client  *http.Client
+	url     string
+	method  string
+	headers http.Header
+}
+
+// NewHTTPSink returns a new instance
+func NewHTTPSink(p HTTPSinkParams) *HTTPSink {
+	method := p.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	return &HTTPSink{
+		client:  &http.Client{Timeout: p.Timeout},
+		url:     p.URL,
+		method:  method,
+		headers: p.Headers,
+	}
+}
+
+// Send sends the record body
+func (s *HTTPSink) Send(ctx context.Context, record Record) error {
+	req, err := http.NewRequestWithContext(ctx, s.method, s.url, bytes.NewReader(record.Body))
+	if err != nil {
+		return errors.Wrap(err, "error creating the request")
+	}
+	for name, values := range s.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(MessageIDHeader, record.MessageID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error sending the request")
+	}
+	defer resp.Body.Close()
+	// drain the body to reuse the connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Close closes the idle connections
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSink_Send(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("acknowledged", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, "Bearer X", r.Header.Get("Authorization"))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "#1", r.Header.Get(MessageIDHeader))
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"orderId":"X"}`, string(body))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		sink := NewHTTPSink(HTTPSinkParams{
+			URL:     server.URL,
+			Method:  http.MethodPut,
+			Headers: http.Header{"Authorization": {"Bearer X"}},
+		})
+		defer sink.Close()
+
+		assert.NoError(t, sink.Send(ctx, Record{MessageID: "#1", Body: []byte(`{"orderId":"X"}`)}))
+	})
+
+	t.Run("not 2xx", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		sink := NewHTTPSink(HTTPSinkParams{URL: server.URL, Headers: http.Header{"Content-Type": {"text/plain"}}})
+		err := sink.Send(ctx, Record{MessageID: "#1", Body: []byte("text")})
+		assert.EqualError(t, err, "unexpected response status 503 Service Unavailable")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer server.Close()
+		defer close(done)
+
+		sink := NewHTTPSink(HTTPSinkParams{URL: server.URL, Timeout: 10 * time.Millisecond})
+		assert.Error(t, sink.Send(ctx, Record{MessageID: "#1"}))
+	})
+}
@@ -0,0 +1,72 @@
+package sinks
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBatchTimeout bounds the wait for a batch, every send waits for its acknowledgement
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// KafkaSinkParams holds KafkaSink params
+type KafkaSinkParams struct {
+	Brokers []string
+	Topic   string
+}
+
+// kafkaWriter is implemented by kafka.Writer
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaSink produces the records to a Kafka topic, the acknowledgement of all the in-sync replicas is awaited
+type KafkaSink struct {
+	writer kafkaWriter
+}
+
+// NewKafkaSink returns a new instance
+func NewKafkaSink(p KafkaSinkParams) *KafkaSink {
+	return &KafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(p.Brokers...),
+			Topic:        p.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: kafkaBatchTimeout,
+		},
+	}
+}
+
+// Send produces the record with the headers
+func (s *KafkaSink) Send(ctx context.Context, record Record) error {
+	headers := record.Headers()
+	msg := kafka.Message{
+		Value:   record.Body,
+		Headers: make([]kafka.Header, 0, len(headers)),
+	}
+	for _, name := range sortedNames(headers) {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: name, Value: []byte(headers[name])})
+	}
+
+	return errors.Wrap(s.writer.WriteMessages(ctx, msg), "error producing the message")
+}
+
+// Close flushes and closes the writer
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+func sortedNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,50 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKafkaWriter struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeKafkaWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	return nil
+}
+
+func TestKafkaSink_Send(t *testing.T) {
+	ctx := context.Background()
+	writer := &fakeKafkaWriter{}
+	sink := &KafkaSink{writer: writer}
+
+	err := sink.Send(ctx, Record{
+		MessageID:  "#1",
+		Body:       []byte(`{"orderId":"X"}`),
+		Attributes: map[string]string{"type": "order"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []kafka.Message{{
+		Value: []byte(`{"orderId":"X"}`),
+		Headers: []kafka.Header{
+			{Key: MessageIDHeader, Value: []byte("#1")},
+			{Key: "type", Value: []byte("order")},
+		},
+	}}, writer.messages)
+
+	writer.err = errors.New("not enough replicas")
+	assert.EqualError(t, sink.Send(ctx, Record{MessageID: "#2"}), "error producing the message: not enough replicas")
+}
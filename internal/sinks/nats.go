@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// natsAckTimeout bounds the wait for the acknowledgement, nats.go requires a deadline
+const natsAckTimeout = 10 * time.Second
+
+// NATSSinkParams holds NATSSink params
+type NATSSinkParams struct {
+	URL     string
+	Subject string
+	// JetStream awaits the stream acknowledgement instead of the server flush
+	JetStream bool
+}
+
+// NATSSink publishes the records to a NATS subject
+type NATSSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+}
+
+// NewNATSSink returns a new instance connected to the server
+func NewNATSSink(p NATSSinkParams) (*NATSSink, error) {
+	conn, err := nats.Connect(p.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to NATS")
+	}
+
+	s := &NATSSink{conn: conn, subject: p.Subject}
+	if p.JetStream {
+		if s.js, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "error getting the JetStream context")
+		}
+	}
+
+	return s, nil
+}
+
+// Send publishes the record with the headers
+func (s *NATSSink) Send(ctx context.Context, record Record) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, natsAckTimeout)
+		defer cancel()
+	}
+
+	msg := nats.NewMsg(s.subject)
+	msg.Data = record.Body
+	for name, value := range record.Headers() {
+		msg.Header.Set(name, value)
+	}
+
+	if s.js != nil {
+		_, err := s.js.PublishMsg(msg, nats.Context(ctx))
+		return errors.Wrap(err, "error publishing the message")
+	}
+
+	if err := s.conn.PublishMsg(msg); err != nil {
+		return errors.Wrap(err, "error publishing the message")
+	}
+	// the server has the message once the flush round trip is over
+	return errors.Wrap(s.conn.FlushWithContext(ctx), "error flushing the message")
+}
+
+// Close closes the connection
+func (s *NATSSink) Close() error {
+	s.conn.Close()
+	return nil
+}
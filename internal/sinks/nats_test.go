@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func runNATSServer(t *testing.T) *server.Server {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	return srv
+}
+
+func TestNATSSink_Send(t *testing.T) {
+	ctx := context.Background()
+	srv := runNATSServer(t)
+	conn, err := nats.Connect(srv.ClientURL())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	record := Record{
+		MessageID:  "#1",
+		Body:       []byte(`{"orderId":"X"}`),
+		Attributes: map[string]string{"type": "order"},
+	}
+
+	t.Run("core", func(t *testing.T) {
+		sub, err := conn.SubscribeSync("orders")
+		assert.NoError(t, err)
+		assert.NoError(t, conn.Flush())
+
+		sink, err := NewNATSSink(NATSSinkParams{URL: srv.ClientURL(), Subject: "orders"})
+		assert.NoError(t, err)
+		defer sink.Close()
+		assert.NoError(t, sink.Send(ctx, record))
+
+		msg, err := sub.NextMsg(time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"orderId":"X"}`, string(msg.Data))
+		assert.Equal(t, "#1", msg.Header.Get(MessageIDHeader))
+		assert.Equal(t, "order", msg.Header.Get("type"))
+	})
+
+	t.Run("jetstream", func(t *testing.T) {
+		js, err := conn.JetStream()
+		assert.NoError(t, err)
+		_, err = js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}})
+		assert.NoError(t, err)
+
+		sink, err := NewNATSSink(NATSSinkParams{URL: srv.ClientURL(), Subject: "orders.failed", JetStream: true})
+		assert.NoError(t, err)
+		defer sink.Close()
+		assert.NoError(t, sink.Send(ctx, record))
+
+		msg, err := js.GetMsg("ORDERS", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"orderId":"X"}`, string(msg.Data))
+		assert.Equal(t, "#1", msg.Header.Get(MessageIDHeader))
+
+		// no stream for the subject, the message isn't acknowledged
+		sink, err = NewNATSSink(NATSSinkParams{URL: srv.ClientURL(), Subject: "payments", JetStream: true})
+		assert.NoError(t, err)
+		defer sink.Close()
+		assert.Error(t, sink.Send(ctx, record))
+	})
+}
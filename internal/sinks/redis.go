@@ -0,0 +1,69 @@
+package sinks
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// RedisBodyField holds the record body in a stream entry
+const RedisBodyField = "body"
+
+// RedisSinkParams holds RedisSink params
+type RedisSinkParams struct {
+	// Addr is host:port or a redis:// URL
+	Addr   string
+	Stream string
+	// MaxLen trims the stream approximately to the length, 0 keeps all the entries
+	MaxLen int64
+}
+
+// RedisSink adds the records to a Redis stream
+type RedisSink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisSink returns a new instance
+func NewRedisSink(p RedisSinkParams) (*RedisSink, error) {
+	opts := &redis.Options{Addr: p.Addr}
+	if strings.Contains(p.Addr, "://") {
+		var err error
+		if opts, err = redis.ParseURL(p.Addr); err != nil {
+			return nil, errors.Wrap(err, "bad Redis URL")
+		}
+	}
+
+	return &RedisSink{
+		client: redis.NewClient(opts),
+		stream: p.Stream,
+		maxLen: p.MaxLen,
+	}, nil
+}
+
+// Send adds the stream entry of the body and the headers
+func (s *RedisSink) Send(ctx context.Context, record Record) error {
+	headers := record.Headers()
+	values := make(map[string]interface{}, len(headers)+1)
+	for name, value := range headers {
+		values[name] = value
+	}
+	values[RedisBodyField] = record.Body
+
+	err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: values,
+	}).Err()
+
+	return errors.Wrap(err, "error adding the stream entry")
+}
+
+// Close closes the client
+func (s *RedisSink) Close() error {
+	return s.client.Close()
+}
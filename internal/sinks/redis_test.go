@@ -0,0 +1,40 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisSink_Send(t *testing.T) {
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+
+	sink, err := NewRedisSink(RedisSinkParams{Addr: "redis://" + srv.Addr() + "/0", Stream: "orders"})
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	assert.NoError(t, sink.Send(ctx, Record{
+		MessageID:  "#1",
+		Body:       []byte(`{"orderId":"X"}`),
+		Attributes: map[string]string{"type": "order"},
+	}))
+
+	entries, err := srv.Stream("orders")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	values := map[string]string{}
+	for i := 0; i < len(entries[0].Values); i += 2 {
+		values[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+	assert.Equal(t, map[string]string{
+		RedisBodyField:  `{"orderId":"X"}`,
+		MessageIDHeader: "#1",
+		"type":          "order",
+	}, values)
+
+	srv.Close()
+	assert.Error(t, sink.Send(ctx, Record{MessageID: "#2"}))
+}
@@ -0,0 +1,38 @@
+package sinks
+
+import (
+	"context"
+)
+
+// Record is a message forwarded to a sink
+type Record struct {
+	MessageID string
+	Body      []byte
+	// Attributes are the message attributes rendered as strings, sinks map them to headers
+	Attributes map[string]string
+	// AttributeTypes are the attribute data types like String, Number or Binary, binary values are base64 encoded
+	AttributeTypes map[string]string
+	// Topic is the SNS topic ARN the message was published to, empty for a message published not via SNS
+	Topic string
+	// GroupID and DeduplicationID are the ids of a FIFO queue message, empty for a standard queue message
+	GroupID         string
+	DeduplicationID string
+}
+
+// Headers returns the attributes and the MessageId as headers
+func (r Record) Headers() map[string]string {
+	headers := make(map[string]string, len(r.Attributes)+1)
+	for name, value := range r.Attributes {
+		headers[name] = value
+	}
+	headers[MessageIDHeader] = r.MessageID
+
+	return headers
+}
+
+// Sink delivers the records to another system
+type Sink interface {
+	// Send returns after the record is acknowledged, the source message is deleted only then
+	Send(ctx context.Context, record Record) error
+	Close() error
+}
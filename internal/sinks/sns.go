@@ -0,0 +1,226 @@
+package sinks
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"andboson/sqsdumper/internal/wrappers/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/pkg/errors"
+)
+
+// sns batching
+const (
+	// SNSMaxBatchSize is the PublishBatch entries limit
+	SNSMaxBatchSize = 10
+	// snsLinger bounds the wait for a batch to fill
+	snsLinger = 50 * time.Millisecond
+	// snsPublishTimeout bounds a PublishBatch call
+	snsPublishTimeout = 30 * time.Second
+	// snsFIFOSuffix marks a FIFO topic, which requires the group and the deduplication ids
+	snsFIFOSuffix = ".fifo"
+)
+
+// SNSSinkParams holds SNSSink params
+type SNSSinkParams struct {
+	Client aws.SNSAPI
+	// TopicARN is the target topic, empty publishes a record to its original topic
+	TopicARN string
+	// BatchSize is the number of the records published at once up to SNSMaxBatchSize,
+	// a batch is published earlier if it isn't filled in a short time
+	BatchSize int
+}
+
+// snsEntry is a record waiting for its batch to be published
+type snsEntry struct {
+	record Record
+	topic  string
+	done   chan error
+}
+
+// SNSSink publishes the records to SNS topics in batches
+type SNSSink struct {
+	client    aws.SNSAPI
+	topicARN  string
+	batchSize int
+	entries   chan *snsEntry
+	stopped   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewSNSSink returns a new instance
+func NewSNSSink(p SNSSinkParams) *SNSSink {
+	batchSize := p.BatchSize
+	if batchSize < 1 || batchSize > SNSMaxBatchSize {
+		batchSize = SNSMaxBatchSize
+	}
+
+	s := &SNSSink{
+		client:    p.Client,
+		topicARN:  p.TopicARN,
+		batchSize: batchSize,
+		entries:   make(chan *snsEntry),
+		stopped:   make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// Send publishes the record with its batch and returns once the batch is published
+func (s *SNSSink) Send(ctx context.Context, record Record) error {
+	topic := s.topicARN
+	if topic == "" {
+		topic = record.Topic
+	}
+	if topic == "" {
+		return errors.New("the message has no original SNS topic")
+	}
+
+	entry := &snsEntry{record: record, topic: topic, done: make(chan error, 1)}
+	select {
+	case s.entries <- entry:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-entry.done:
+		return err
+	case <-ctx.Done():
+		// the record may be published still, it's sent again once the message is received again
+		return ctx.Err()
+	}
+}
+
+// Close publishes the pending batches
+func (s *SNSSink) Close() error {
+	close(s.entries)
+	<-s.stopped
+	s.wg.Wait()
+
+	return nil
+}
+
+// run collects the entries into the batches by the topic
+func (s *SNSSink) run() {
+	defer close(s.stopped)
+
+	batches := map[string][]*snsEntry{}
+	var linger <-chan time.Time
+	for {
+		select {
+		case entry, ok := <-s.entries:
+			if !ok {
+				for topic, batch := range batches {
+					s.publish(topic, batch)
+				}
+				return
+			}
+
+			batches[entry.topic] = append(batches[entry.topic], entry)
+			if len(batches[entry.topic]) == s.batchSize {
+				s.publish(entry.topic, batches[entry.topic])
+				delete(batches, entry.topic)
+			}
+			if linger == nil && len(batches) > 0 {
+				linger = time.After(snsLinger)
+			}
+		case <-linger:
+			for topic, batch := range batches {
+				s.publish(topic, batch)
+			}
+			batches = map[string][]*snsEntry{}
+			linger = nil
+		}
+	}
+}
+
+// publish publishes the batch in the background and reports the result of every entry
+func (s *SNSSink) publish(topic string, batch []*snsEntry) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), snsPublishTimeout)
+		defer cancel()
+
+		input := &sns.PublishBatchInput{TopicArn: ptr.String(topic)}
+		for i, entry := range batch {
+			input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, snsBatchEntry(topic, i, entry.record))
+		}
+
+		out, err := s.client.PublishBatch(ctx, input)
+		if err != nil {
+			err = errors.Wrap(err, "error publishing the batch")
+			for _, entry := range batch {
+				entry.done <- err
+			}
+			return
+		}
+
+		failed := make(map[string]error, len(out.Failed))
+		for _, f := range out.Failed {
+			failed[ptr.ToString(f.Id)] = errors.Errorf("error publishing the message: %s: %s", ptr.ToString(f.Code), ptr.ToString(f.Message))
+		}
+		for i, entry := range batch {
+			entry.done <- failed[strconv.Itoa(i)]
+		}
+	}()
+}
+
+// snsBatchEntry returns the PublishBatch entry of the record, a FIFO topic entry keeps the group
+// and the deduplication ids of the message, the MessageId stands in for the ids of a standard queue message
+func snsBatchEntry(topic string, i int, record Record) types.PublishBatchRequestEntry {
+	entry := types.PublishBatchRequestEntry{
+		Id:                ptr.String(strconv.Itoa(i)),
+		Message:           ptr.String(string(record.Body)),
+		MessageAttributes: snsMessageAttributes(record),
+	}
+	if !strings.HasSuffix(topic, snsFIFOSuffix) {
+		return entry
+	}
+
+	entry.MessageGroupId = ptr.String(record.GroupID)
+	if record.GroupID == "" {
+		entry.MessageGroupId = ptr.String(record.MessageID)
+	}
+	entry.MessageDeduplicationId = ptr.String(record.DeduplicationID)
+	if record.DeduplicationID == "" {
+		entry.MessageDeduplicationId = ptr.String(record.MessageID)
+	}
+
+	return entry
+}
+
+// snsMessageAttributes returns the record attributes as SNS message attributes, String is the default type
+func snsMessageAttributes(record Record) map[string]types.MessageAttributeValue {
+	if len(record.Attributes) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]types.MessageAttributeValue, len(record.Attributes))
+	for name, value := range record.Attributes {
+		dataType := record.AttributeTypes[name]
+		if dataType == "" {
+			dataType = "String"
+		}
+
+		attr := types.MessageAttributeValue{DataType: ptr.String(dataType)}
+		if binary, err := base64.StdEncoding.DecodeString(value); strings.HasPrefix(dataType, "Binary") && err == nil {
+			attr.BinaryValue = binary
+		} else {
+			attr.StringValue = ptr.String(value)
+		}
+		attrs[name] = attr
+	}
+
+	return attrs
+}
@@ -0,0 +1,131 @@
+package sinks
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	mock_apis "andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSNSSink_Send(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	// sendAll sends the records at once and returns the errors in the records order
+	sendAll := func(sink *SNSSink, records ...Record) []error {
+		errs := make([]error, len(records))
+		var wg sync.WaitGroup
+		for i, record := range records {
+			wg.Add(1)
+			go func(i int, record Record) {
+				defer wg.Done()
+				errs[i] = sink.Send(ctx, record)
+			}(i, record)
+		}
+		wg.Wait()
+
+		return errs
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		client := mock_apis.NewMockSNSAPI(ctrl)
+		client.EXPECT().PublishBatch(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+				assert.Equal(t, "arn:aws:sns:us-east-1:123456789012:orders", *in.TopicArn)
+				assert.Len(t, in.PublishBatchRequestEntries, 2)
+				for _, entry := range in.PublishBatchRequestEntries {
+					assert.Equal(t, `{"orderId":"X"}`, *entry.Message)
+					assert.Equal(t, map[string]types.MessageAttributeValue{
+						"type":  {DataType: ptr.String("String"), StringValue: ptr.String("order")},
+						"count": {DataType: ptr.String("Number"), StringValue: ptr.String("2")},
+						"raw":   {DataType: ptr.String("Binary"), BinaryValue: []byte("raw")},
+					}, entry.MessageAttributes)
+				}
+
+				return &sns.PublishBatchOutput{
+					Failed: []types.BatchResultErrorEntry{{Id: ptr.String("1"), Code: ptr.String("InternalError"), Message: ptr.String("try again")}},
+				}, nil
+			})
+
+		sink := NewSNSSink(SNSSinkParams{Client: client, TopicARN: "arn:aws:sns:us-east-1:123456789012:orders", BatchSize: 2})
+		defer sink.Close()
+
+		record := func(id string) Record {
+			return Record{
+				MessageID:      id,
+				Body:           []byte(`{"orderId":"X"}`),
+				Attributes:     map[string]string{"type": "order", "count": "2", "raw": "cmF3"},
+				AttributeTypes: map[string]string{"count": "Number", "raw": "Binary"},
+			}
+		}
+		errs := sendAll(sink, record("#1"), record("#2"))
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				failed++
+				assert.EqualError(t, err, "error publishing the message: InternalError: try again")
+			}
+		}
+		assert.Equal(t, 1, failed)
+	})
+
+	t.Run("original topic", func(t *testing.T) {
+		client := mock_apis.NewMockSNSAPI(ctrl)
+		var mu sync.Mutex
+		published := map[string]int{}
+		client.EXPECT().PublishBatch(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				published[*in.TopicArn] += len(in.PublishBatchRequestEntries)
+				return &sns.PublishBatchOutput{}, nil
+			}).AnyTimes()
+
+		sink := NewSNSSink(SNSSinkParams{Client: client})
+		defer sink.Close()
+
+		errs := sendAll(sink,
+			Record{MessageID: "#1", Body: []byte("1"), Topic: "arn:orders"},
+			Record{MessageID: "#2", Body: []byte("2"), Topic: "arn:payments"},
+			Record{MessageID: "#3", Body: []byte("3"), Topic: "arn:orders"},
+		)
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+		assert.Equal(t, map[string]int{"arn:orders": 2, "arn:payments": 1}, published)
+
+		assert.EqualError(t, sink.Send(ctx, Record{MessageID: "#4"}), "the message has no original SNS topic")
+	})
+
+	t.Run("fifo topic", func(t *testing.T) {
+		client := mock_apis.NewMockSNSAPI(ctrl)
+		client.EXPECT().PublishBatch(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+				assert.Len(t, in.PublishBatchRequestEntries, 2)
+				fifo, standard := in.PublishBatchRequestEntries[0], in.PublishBatchRequestEntries[1]
+				if *fifo.Message != "1" {
+					fifo, standard = standard, fifo
+				}
+				assert.Equal(t, "orders", *fifo.MessageGroupId)
+				assert.Equal(t, "dedup-1", *fifo.MessageDeduplicationId)
+				// a standard queue message is published with its MessageId
+				assert.Equal(t, "#2", *standard.MessageGroupId)
+				assert.Equal(t, "#2", *standard.MessageDeduplicationId)
+				return &sns.PublishBatchOutput{}, nil
+			})
+
+		sink := NewSNSSink(SNSSinkParams{Client: client, TopicARN: "arn:aws:sns:us-east-1:123456789012:orders.fifo", BatchSize: 2})
+		defer sink.Close()
+
+		errs := sendAll(sink,
+			Record{MessageID: "#1", Body: []byte("1"), GroupID: "orders", DeduplicationID: "dedup-1"},
+			Record{MessageID: "#2", Body: []byte("2")},
+		)
+		assert.Equal(t, []error{nil, nil}, errs)
+	})
+}
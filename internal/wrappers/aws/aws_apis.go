@@ -3,6 +3,8 @@ package aws
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
@@ -25,6 +27,36 @@ type SQSAPI interface {
 	DeleteMessage(ctx context.Context,
 		params *sqs.DeleteMessageInput,
 		optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+
+	SendMessage(ctx context.Context,
+		params *sqs.SendMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+
+	ChangeMessageVisibility(ctx context.Context,
+		params *sqs.ChangeMessageVisibilityInput,
+		optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// S3API represents AWS SDK S3 methods
+type S3API interface {
+	GetObject(ctx context.Context,
+		params *s3.GetObjectInput,
+		optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+
+	DeleteObject(ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+
+	PutObject(ctx context.Context,
+		params *s3.PutObjectInput,
+		optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// SNSAPI represents AWS SDK SNS methods
+type SNSAPI interface {
+	PublishBatch(ctx context.Context,
+		params *sns.PublishBatchInput,
+		optFns ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
 }
 
 // ConfigQueue holds queue params
@@ -32,4 +64,5 @@ type ConfigQueue struct {
 	QueueName               string `yaml:"name"`
 	MaxMessagesPerRetrieval int32  `yaml:"max-messages-per-retrieval"`
 	WaitTimeSeconds         int32  `yaml:"wait-time-seconds"`
+	VisibilityTimeout       int32  `yaml:"visibility-timeout"`
 }
@@ -1,5 +1,11 @@
 package aws
 
+import "time"
+
 const (
 	awsMaxAttempts = 15
+	// receiveErrorDelay is the pause before receiving again after a failed receive
+	receiveErrorDelay = time.Second
+	// retryDelay is the pause before the first retry of a failed message, doubled every retry
+	retryDelay = time.Second
 )
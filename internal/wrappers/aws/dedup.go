@@ -0,0 +1,231 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+)
+
+// DedupMode selects the key a received message is deduplicated by
+type DedupMode string
+
+// dedup modes
+const (
+	DedupNone      DedupMode = "none"
+	DedupMessageID DedupMode = "id"
+	DedupBody      DedupMode = "body"
+)
+
+// ParseDedupMode parses the --dedup value
+func ParseDedupMode(src string) (DedupMode, error) {
+	switch mode := DedupMode(src); mode {
+	case DedupNone, DedupMessageID, DedupBody:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown dedup mode %q, use id, body or none", src)
+	}
+}
+
+// Key returns the message dedup key, empty if the message can't be deduplicated
+func (m DedupMode) Key(msg types.Message) string {
+	switch m {
+	case DedupMessageID:
+		return MessageID(msg)
+	case DedupBody:
+		if msg.Body == nil {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(*msg.Body))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	default:
+		return ""
+	}
+}
+
+// DedupStore is a file of the handled message keys, one per line,
+// so repeated runs on the same queue skip the messages handled already
+type DedupStore struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+	file *os.File
+}
+
+// OpenDedupStore loads the keys of the file, the file is created if it doesn't exist
+func OpenDedupStore(path string) (*DedupStore, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening the dedup store")
+	}
+
+	keys := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error reading the dedup store")
+	}
+
+	return &DedupStore{keys: keys, file: file}, nil
+}
+
+// Has reports whether the key is stored
+func (d *DedupStore) Has(key string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.keys[key]
+
+	return ok
+}
+
+// Add stores the key
+func (d *DedupStore) Add(key string) error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.keys[key]; ok {
+		return nil
+	}
+	if _, err := d.file.WriteString(key + "\n"); err != nil {
+		return errors.Wrap(err, "error writing the dedup store")
+	}
+	d.keys[key] = struct{}{}
+
+	return nil
+}
+
+// Len returns the number of the stored keys
+func (d *DedupStore) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.keys)
+}
+
+// Close closes the file
+func (d *DedupStore) Close() error {
+	return d.file.Close()
+}
+
+// duplicate reports whether the message has been handled in this run or in a previous one
+func (s *sqsPoller) duplicate(msg types.Message) bool {
+	key := s.dedup.Key(msg)
+	if key == "" {
+		return false
+	}
+	_, received := s.checkReceived.get(key)
+
+	return received || s.dedupStore.Has(key)
+}
+
+// markReceived remembers the message handled without an error, failed messages are handled again
+func (s *sqsPoller) markReceived(msg types.Message) {
+	key := s.dedup.Key(msg)
+	if key == "" {
+		return
+	}
+
+	s.checkReceived.add(key, receivedMessage{id: MessageID(msg), receiptHandle: stringValue(msg.ReceiptHandle)})
+	if err := s.dedupStore.Add(key); err != nil {
+		s.logger.Err(err).Str("messageId", MessageID(msg)).Msg("error storing the message dedup key")
+	}
+}
+
+// markDeleted remembers the deleted message, so its redelivery can be deleted as well
+func (s *sqsPoller) markDeleted(receiptHandle *string) {
+	if receiptHandle != nil {
+		s.deletedHandles.add(*receiptHandle, receivedMessage{})
+	}
+}
+
+// skipDuplicate counts the duplicate and deletes it if configured, otherwise it stays invisible
+// until its visibility timeout expires; only a redelivery of a message handled and deleted in the run is deleted,
+// a message left in the queue or a distinct message sharing the body with a handled one is kept
+func (s *sqsPoller) skipDuplicate(ctx context.Context, msg types.Message) {
+	s.summary.Duplicates++
+	s.logger.Debug().Str("messageId", MessageID(msg)).Msg("skipped the duplicate message")
+	if !s.deleteDups {
+		return
+	}
+
+	handled, ok := s.checkReceived.get(s.dedup.Key(msg))
+	if !ok || handled.id != MessageID(msg) {
+		return
+	}
+	if _, deleted := s.deletedHandles.get(handled.receiptHandle); !deleted {
+		return
+	}
+
+	if _, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      s.queueURL,
+		ReceiptHandle: msg.ReceiptHandle,
+	}); err != nil {
+		s.logger.Err(err).Str("messageId", MessageID(msg)).Msg("error deleting the duplicate message")
+	}
+}
+
+// maxReceivedKeys bounds the keys remembered in a run, the oldest ones are forgotten past it
+const maxReceivedKeys = 100000
+
+// receivedMessage is the handled message of a dedup key
+type receivedMessage struct {
+	id            string
+	receiptHandle string
+}
+
+// receivedKeys holds the dedup keys of the messages handled in the run, the deletes of the background handlers
+// mark the receipt handles concurrently
+type receivedKeys struct {
+	mu       sync.Mutex
+	messages map[string]receivedMessage
+	order    []string
+	next     int
+	limit    int
+}
+
+func newReceivedKeys(limit int) *receivedKeys {
+	return &receivedKeys{messages: map[string]receivedMessage{}, limit: limit}
+}
+
+// get returns the handled message with the key
+func (r *receivedKeys) get(key string) (receivedMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[key]
+	return msg, ok
+}
+
+// add remembers the key replacing the oldest one past the limit
+func (r *receivedKeys) add(key string, msg receivedMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.messages[key]; !ok {
+		if len(r.order) < r.limit {
+			r.order = append(r.order, key)
+		} else {
+			delete(r.messages, r.order[r.next])
+			r.order[r.next] = key
+			r.next = (r.next + 1) % r.limit
+		}
+	}
+	r.messages[key] = msg
+}
@@ -0,0 +1,181 @@
+package aws
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupMode_Key(t *testing.T) {
+	msg := types.Message{MessageId: stringPtr("#1"), Body: stringPtr("body")}
+
+	for _, src := range []string{"id", "body", "none"} {
+		_, err := ParseDedupMode(src)
+		assert.NoError(t, err)
+	}
+	_, err := ParseDedupMode("md5")
+	assert.Error(t, err)
+
+	assert.Equal(t, "#1", DedupMessageID.Key(msg))
+	assert.Equal(t, "sha256:230d8358dc8e8890b4c58deeb62912ee2f20357ae92a5cc861b98e68fe31acb5", DedupBody.Key(msg))
+	assert.Empty(t, DedupNone.Key(msg))
+	assert.Empty(t, DedupMessageID.Key(types.Message{}))
+	assert.Empty(t, DedupBody.Key(types.Message{}))
+}
+
+func TestDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+
+	store, err := OpenDedupStore(path)
+	assert.NoError(t, err)
+	assert.False(t, store.Has("#1"))
+	assert.NoError(t, store.Add("#1"))
+	assert.NoError(t, store.Add("#1"))
+	assert.NoError(t, store.Add("#2"))
+	assert.True(t, store.Has("#1"))
+	assert.NoError(t, store.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "#1\n#2\n", string(content))
+
+	store, err = OpenDedupStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, store.Len())
+	assert.True(t, store.Has("#2"))
+	assert.NoError(t, store.Close())
+
+	// a nil store has nothing
+	var none *DedupStore
+	assert.False(t, none.Has("#1"))
+	assert.NoError(t, none.Add("#1"))
+}
+
+func TestSqsPoller_PollMessagesDedup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	message := func(id, body, receiptHandle string) types.Message {
+		return types.Message{MessageId: stringPtr(id), Body: stringPtr(body), ReceiptHandle: stringPtr(receiptHandle)}
+	}
+	received := []types.Message{
+		message("#1", "a", "rh-1"),
+		message("#1", "a", "rh-1-again"),
+		message("#2", "b", "rh-2"),
+	}
+
+	handled := func(t *testing.T, poller SQSPoller) []string {
+		var ids []string
+		assert.NoError(t, poller.PollMessages(context.Background(), func(_ SQSPoller, msg types.Message) error {
+			ids = append(ids, MessageID(msg))
+			return nil
+		}))
+		return ids
+	}
+
+	// deleting handles the messages like the dumper with --deleteMessage, the failed ones are left in the queue
+	deleting := func(t *testing.T, poller SQSPoller, failed ...string) []string {
+		var ids []string
+		assert.NoError(t, poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			ids = append(ids, MessageID(msg))
+			for _, handle := range failed {
+				if handle == *msg.ReceiptHandle {
+					return errors.New("some error")
+				}
+			}
+			_, err := poller.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{ReceiptHandle: msg.ReceiptHandle})
+			return err
+		}))
+		return ids
+	}
+	expectDeletes := func(sqsClient *mock_aws.MockSQSAPI, receiptHandles ...string) {
+		for _, receiptHandle := range receiptHandles {
+			sqsClient.EXPECT().DeleteMessage(gomock.Any(), &sqs.DeleteMessageInput{
+				QueueUrl:      stringPtr("url"),
+				ReceiptHandle: stringPtr(receiptHandle),
+			}).Return(&sqs.DeleteMessageOutput{}, nil)
+		}
+	}
+
+	t.Run("by the message id", func(t *testing.T) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		// the redelivery of the deleted message is deleted too
+		expectDeletes(sqsClient, "rh-1", "rh-1-again", "rh-2")
+
+		poller := newTestPoller(t, sqsClient, "2", received, SQSParam{Dedup: DedupMessageID, DeleteDuplicates: true})
+		assert.Equal(t, []string{"#1", "#2"}, deleting(t, poller))
+		assert.Equal(t, 1, poller.GetSummary().Duplicates)
+		assert.Contains(t, poller.GetSummary().String(), "duplicates: 1")
+	})
+
+	t.Run("by the body", func(t *testing.T) {
+		messages := []types.Message{
+			message("#1", "a", "rh-1"),
+			message("#2", "a", "rh-2"),
+			message("#1", "a", "rh-1-again"),
+			message("#3", "b", "rh-3"),
+		}
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		// only the redelivery of the handled message is deleted, the distinct #2 with the same body is kept
+		expectDeletes(sqsClient, "rh-1", "rh-1-again", "rh-3")
+
+		poller := newTestPoller(t, sqsClient, "2", messages, SQSParam{Dedup: DedupBody, DeleteDuplicates: true})
+		assert.Equal(t, []string{"#1", "#3"}, deleting(t, poller))
+		assert.Equal(t, 2, poller.GetSummary().Duplicates)
+	})
+
+	t.Run("failed and redelivered", func(t *testing.T) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		// the failed message isn't a duplicate, its redelivery is handled and deleted then
+		expectDeletes(sqsClient, "rh-1-again")
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+
+		poller := newTestPoller(t, sqsClient, "2", received, SQSParam{Dedup: DedupMessageID, DeleteDuplicates: true})
+		assert.Equal(t, []string{"#1", "#1"}, deleting(t, poller, "rh-1"))
+		assert.Equal(t, 0, poller.GetSummary().Duplicates)
+	})
+
+	t.Run("left in the queue", func(t *testing.T) {
+		// the handled message isn't deleted, so its redelivery is skipped but kept
+		poller := newTestPoller(t, mock_aws.NewMockSQSAPI(ctrl), "2", received, SQSParam{Dedup: DedupMessageID, DeleteDuplicates: true})
+		assert.Equal(t, []string{"#1", "#2"}, handled(t, poller))
+		assert.Equal(t, 1, poller.GetSummary().Duplicates)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		// the rest of the batch after the total is released
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:      stringPtr("url"),
+			ReceiptHandle: stringPtr("rh-2"),
+		}).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+
+		poller := newTestPoller(t, sqsClient, "2", received, SQSParam{Dedup: DedupNone})
+		assert.Equal(t, []string{"#1", "#1"}, handled(t, poller))
+		assert.Equal(t, 0, poller.GetSummary().Duplicates)
+	})
+
+	t.Run("stored by a previous run", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dedup")
+		assert.NoError(t, os.WriteFile(path, []byte("#1\n"), 0644))
+		store, err := OpenDedupStore(path)
+		assert.NoError(t, err)
+
+		poller := newTestPoller(t, mock_aws.NewMockSQSAPI(ctrl), "1", received, SQSParam{Dedup: DedupMessageID, DedupStore: store})
+		assert.Equal(t, []string{"#2"}, handled(t, poller))
+		assert.Equal(t, 2, poller.GetSummary().Duplicates)
+		assert.NoError(t, store.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "#1\n#2\n", string(content))
+	})
+}
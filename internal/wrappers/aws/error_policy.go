@@ -0,0 +1,209 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+)
+
+// ErrorAction defines what to do with a message the handler failed to process
+type ErrorAction string
+
+// supported error actions
+const (
+	ErrorActionSkip       ErrorAction = "skip"
+	ErrorActionStop       ErrorAction = "stop"
+	ErrorActionRetry      ErrorAction = "retry"
+	ErrorActionQuarantine ErrorAction = "quarantine"
+)
+
+const (
+	// ErrorAttributeName is the message attribute holding the processing error of a quarantined message
+	ErrorAttributeName = "sqsdumper-error"
+
+	quarantineFilePrefix  = "file:"
+	quarantineQueuePrefix = "queue:"
+	maxMessageAttributes  = 10
+)
+
+var queueNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,80}(\.fifo)?$`)
+
+// ErrorPolicy holds the handler error policy
+type ErrorPolicy struct {
+	Action  ErrorAction
+	Retries int
+	// Target is a quarantine queue name or a file path
+	Target string
+	// Delete removes a quarantined message from the source queue
+	Delete bool
+	// Redact is applied to the messages written to a quarantine file,
+	// a quarantine queue gets them as is to be redriven
+	Redact func(msg types.Message) (types.Message, error)
+}
+
+// ParseErrorPolicy parses a policy definition like skip, stop, retry=N or quarantine=<queue|file>
+func ParseErrorPolicy(def string) (ErrorPolicy, error) {
+	var policy ErrorPolicy
+
+	action, arg, hasArg := strings.Cut(strings.TrimSpace(def), "=")
+	policy.Action = ErrorAction(action)
+	switch policy.Action {
+	case "":
+		policy.Action = ErrorActionSkip
+	case ErrorActionSkip, ErrorActionStop:
+		if hasArg {
+			return policy, errors.Errorf("the %q error policy takes no argument", action)
+		}
+	case ErrorActionRetry:
+		retries, err := strconv.Atoi(arg)
+		if err != nil || retries < 1 {
+			return policy, errors.Errorf("bad retries number %q, expected retry=N where N > 0", arg)
+		}
+		policy.Retries = retries
+	case ErrorActionQuarantine:
+		if arg == "" {
+			return policy, errors.New("quarantine target is empty, expected quarantine=<queue|file>")
+		}
+		policy.Target = arg
+	default:
+		return policy, errors.Errorf("unknown error policy %q, expected skip, stop, retry=N or quarantine=<queue|file>", def)
+	}
+
+	return policy, nil
+}
+
+// String returns the policy definition
+func (p ErrorPolicy) String() string {
+	switch p.Action {
+	case "":
+		return string(ErrorActionSkip)
+	case ErrorActionRetry:
+		return fmt.Sprintf("%s=%d", p.Action, p.Retries)
+	case ErrorActionQuarantine:
+		return fmt.Sprintf("%s=%s", p.Action, p.Target)
+	default:
+		return string(p.Action)
+	}
+}
+
+// Quarantine stores messages which failed processing
+type Quarantine interface {
+	Put(ctx context.Context, msg types.Message, cause error) error
+}
+
+// QuarantinedMessage is a message record written to a quarantine file
+type QuarantinedMessage struct {
+	MessageID         string                                 `json:"MessageId"`
+	Body              string                                 `json:"Body"`
+	Attributes        map[string]string                      `json:"Attributes,omitempty"`
+	MessageAttributes map[string]types.MessageAttributeValue `json:"MessageAttributes,omitempty"`
+	Error             string                                 `json:"Error"`
+}
+
+// NewQuarantine returns a file or a queue quarantine for the target,
+// a target is a queue when it looks like a queue name, use file: or queue: prefixes to be explicit
+func NewQuarantine(ctx context.Context, client SQSAPI, target string) (Quarantine, error) {
+	switch {
+	case strings.HasPrefix(target, quarantineFilePrefix):
+		return &fileQuarantine{path: strings.TrimPrefix(target, quarantineFilePrefix)}, nil
+	case strings.HasPrefix(target, quarantineQueuePrefix):
+		target = strings.TrimPrefix(target, quarantineQueuePrefix)
+	case !queueNameRe.MatchString(target):
+		return &fileQuarantine{path: target}, nil
+	}
+
+	output, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: &target,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting the quarantine queue %s URL", target)
+	}
+
+	return &queueQuarantine{client: client, queueURL: output.QueueUrl}, nil
+}
+
+type fileQuarantine struct {
+	path   string
+	redact func(msg types.Message) (types.Message, error)
+}
+
+// Put appends the message with the error text to the quarantine file as a JSON line
+func (q *fileQuarantine) Put(_ context.Context, msg types.Message, cause error) error {
+	if q.redact != nil {
+		var err error
+		if msg, err = q.redact(msg); err != nil {
+			return errors.Wrap(err, "error redacting the quarantined message")
+		}
+	}
+	record := QuarantinedMessage{
+		MessageID:         stringValue(msg.MessageId),
+		Body:              stringValue(msg.Body),
+		Attributes:        msg.Attributes,
+		MessageAttributes: msg.MessageAttributes,
+		Error:             cause.Error(),
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling the quarantined message")
+	}
+
+	f, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error opening the quarantine file")
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "error writing the quarantine file")
+	}
+
+	return nil
+}
+
+type queueQuarantine struct {
+	client   SQSAPI
+	queueURL *string
+}
+
+// Put sends the message to the quarantine queue with the error text in the message attribute
+func (q *queueQuarantine) Put(ctx context.Context, msg types.Message, cause error) error {
+	attrs := make(map[string]types.MessageAttributeValue, len(msg.MessageAttributes)+1)
+	for name, value := range msg.MessageAttributes {
+		attrs[name] = value
+	}
+	if len(attrs) < maxMessageAttributes {
+		errText := cause.Error()
+		attrs[ErrorAttributeName] = types.MessageAttributeValue{
+			DataType:    stringPtr("String"),
+			StringValue: &errText,
+		}
+	}
+
+	input := NewSendMessageInput(q.queueURL, msg)
+	input.MessageAttributes = attrs
+	if _, err := q.client.SendMessage(ctx, input); err != nil {
+		return errors.Wrap(err, "error sending the message to the quarantine queue")
+	}
+
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,102 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	tests := []struct {
+		def     string
+		want    ErrorPolicy
+		wantErr bool
+	}{
+		{def: "", want: ErrorPolicy{Action: ErrorActionSkip}},
+		{def: "skip", want: ErrorPolicy{Action: ErrorActionSkip}},
+		{def: "stop", want: ErrorPolicy{Action: ErrorActionStop}},
+		{def: "retry=3", want: ErrorPolicy{Action: ErrorActionRetry, Retries: 3}},
+		{def: "quarantine=bad-queue", want: ErrorPolicy{Action: ErrorActionQuarantine, Target: "bad-queue"}},
+		{def: "stop=1", wantErr: true},
+		{def: "retry", wantErr: true},
+		{def: "retry=0", wantErr: true},
+		{def: "quarantine", wantErr: true},
+		{def: "ignore", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.def, func(t *testing.T) {
+			got, err := ParseErrorPolicy(tt.def)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			if tt.def != "" {
+				assert.Equal(t, tt.def, got.String())
+			}
+		})
+	}
+}
+
+func TestNewQuarantine(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bad.jsonl")
+		q, err := NewQuarantine(ctx, nil, path)
+		assert.NoError(t, err)
+
+		msg := types.Message{MessageId: ptr.String("#1"), Body: ptr.String(`{"foo":"bar"}`)}
+		assert.NoError(t, q.Put(ctx, msg, errors.New("first")))
+		assert.NoError(t, q.Put(ctx, msg, errors.New("second")))
+
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		defer f.Close()
+
+		var records []QuarantinedMessage
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record QuarantinedMessage
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		assert.Len(t, records, 2)
+		assert.Equal(t, "#1", records[0].MessageID)
+		assert.Equal(t, `{"foo":"bar"}`, records[0].Body)
+		assert.Equal(t, "second", records[1].Error)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).
+			Return(&sqs.GetQueueUrlOutput{QueueUrl: ptr.String("bad-url")}, nil)
+		sqsClient.EXPECT().SendMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+				assert.Equal(t, "bad-url", *in.QueueUrl)
+				assert.Equal(t, "body", *in.MessageBody)
+				assert.Equal(t, "boom", *in.MessageAttributes[ErrorAttributeName].StringValue)
+				return &sqs.SendMessageOutput{}, nil
+			})
+
+		q, err := NewQuarantine(ctx, sqsClient, "queue:bad-queue")
+		assert.NoError(t, err)
+		assert.NoError(t, q.Put(ctx, types.Message{Body: ptr.String("body")}, errors.New("boom")))
+	})
+}
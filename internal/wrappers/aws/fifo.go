@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const fifoSuffix = ".fifo"
+
+// IsFIFOQueue checks whether a queue name or URL is a FIFO queue
+func IsFIFOQueue(queue string) bool {
+	return strings.HasSuffix(queue, fifoSuffix)
+}
+
+// MessageGroupID returns the message group id of a FIFO queue message
+func MessageGroupID(msg types.Message) string {
+	return msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+}
+
+// MessageDeduplicationID returns the deduplication id of a FIFO queue message
+func MessageDeduplicationID(msg types.Message) string {
+	return msg.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]
+}
+
+// SequenceNumber returns the sequence number of a FIFO queue message
+func SequenceNumber(msg types.Message) string {
+	return msg.Attributes[string(types.MessageSystemAttributeNameSequenceNumber)]
+}
+
+// CompareSequenceNumbers compares two FIFO sequence numbers,
+// they are up to 128-bit decimals so can't be parsed to an int64
+func CompareSequenceNumbers(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// GroupFilter returns a filter accepting only the messages of the given groups
+func GroupFilter(groups []string) MessageFilter {
+	accepted := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		accepted[group] = struct{}{}
+	}
+
+	return func(msg types.Message) bool {
+		_, ok := accepted[MessageGroupID(msg)]
+		return ok
+	}
+}
+
+// NewSendMessageInput returns an input to send the received message to another queue,
+// the message attributes are kept as well as the group and deduplication ids for a FIFO target
+func NewSendMessageInput(queueURL *string, msg types.Message) *sqs.SendMessageInput {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          queueURL,
+		MessageBody:       msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+	}
+	if queueURL == nil || !IsFIFOQueue(*queueURL) {
+		return input
+	}
+
+	groupID := MessageGroupID(msg)
+	if groupID == "" {
+		// a message from a standard queue still needs a group
+		groupID = stringValue(msg.MessageId)
+	}
+	input.MessageGroupId = &groupID
+	if dedupID := MessageDeduplicationID(msg); dedupID != "" {
+		input.MessageDeduplicationId = &dedupID
+	} else if msg.MessageId != nil {
+		input.MessageDeduplicationId = msg.MessageId
+	}
+
+	return input
+}
+
+// newReceiveAttemptID returns a new ReceiveRequestAttemptId for FIFO receives
+func newReceiveAttemptID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,116 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func fifoMessage(id, group, sequence string) types.Message {
+	return types.Message{
+		MessageId: ptr.String(id),
+		Body:      ptr.String(id),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameMessageGroupId):         group,
+			string(types.MessageSystemAttributeNameSequenceNumber):         sequence,
+			string(types.MessageSystemAttributeNameMessageDeduplicationId): "dedup-" + id,
+		},
+	}
+}
+
+func TestCompareSequenceNumbers(t *testing.T) {
+	assert.Equal(t, -1, CompareSequenceNumbers("9", "10"))
+	assert.Equal(t, 1, CompareSequenceNumbers("18849496460467696128", "18849496460467696127"))
+	assert.Equal(t, 0, CompareSequenceNumbers("42", "42"))
+}
+
+func TestGroupFilter(t *testing.T) {
+	filter := GroupFilter([]string{"a", "b"})
+	assert.True(t, filter(fifoMessage("#1", "a", "1")))
+	assert.False(t, filter(fifoMessage("#2", "c", "2")))
+	assert.False(t, filter(types.Message{}))
+}
+
+func TestNewSendMessageInput(t *testing.T) {
+	msg := fifoMessage("#1", "a", "1")
+
+	input := NewSendMessageInput(ptr.String("https://sqs/queue"), msg)
+	assert.Nil(t, input.MessageGroupId)
+	assert.Nil(t, input.MessageDeduplicationId)
+
+	input = NewSendMessageInput(ptr.String("https://sqs/queue.fifo"), msg)
+	assert.Equal(t, "a", *input.MessageGroupId)
+	assert.Equal(t, "dedup-#1", *input.MessageDeduplicationId)
+
+	// from a standard queue to a FIFO one
+	input = NewSendMessageInput(ptr.String("https://sqs/queue.fifo"), types.Message{MessageId: ptr.String("#2")})
+	assert.Equal(t, "#2", *input.MessageGroupId)
+	assert.Equal(t, "#2", *input.MessageDeduplicationId)
+}
+
+func TestSqsPoller_PollMessagesFIFO(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueUrlOutput{QueueUrl: ptr.String("queue.fifo")}, nil)
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages): "2",
+				string(types.QueueAttributeNameFifoQueue):                   "true",
+			},
+		}, nil)
+
+	var attemptIDs []string
+	receive := func(output *sqs.ReceiveMessageOutput, err error) func(context.Context, *sqs.ReceiveMessageInput, ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+		return func(_ context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+			assert.Equal(t, []types.QueueAttributeName{types.QueueAttributeNameAll}, in.AttributeNames)
+			attemptIDs = append(attemptIDs, *in.ReceiveRequestAttemptId)
+			return output, err
+		}
+	}
+	gomock.InOrder(
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(receive(nil, errors.New("timeout"))),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(receive(&sqs.ReceiveMessageOutput{
+				Messages: []types.Message{fifoMessage("#1", "a", "1")},
+			}, nil)),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(receive(&sqs.ReceiveMessageOutput{
+				Messages: []types.Message{fifoMessage("#2", "b", "2")},
+			}, nil)),
+	)
+
+	poller, err := NewSQSPoller(SQSParam{
+		Client:      sqsClient,
+		Logger:      log,
+		StopOnTotal: true,
+		Filters:     []MessageFilter{GroupFilter([]string{"a"})},
+		Clock:       instantClock{},
+	})
+	assert.NoError(t, err)
+	assert.True(t, poller.IsFIFO())
+
+	var handled []string
+	err = poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+		handled = append(handled, *msg.MessageId)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"#1"}, handled)
+	assert.Equal(t, 1, poller.GetSummary().Filtered)
+
+	// the failed receive is retried with the same attempt id
+	assert.Len(t, attemptIDs, 3)
+	assert.Equal(t, attemptIDs[0], attemptIDs[1])
+	assert.NotEqual(t, attemptIDs[1], attemptIDs[2])
+}
@@ -0,0 +1,94 @@
+package aws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// DefaultMaxHold is the default limit of the visibility extensions of a message being processed
+const DefaultMaxHold = 10 * time.Minute
+
+// Clock provides the time for the timers, it's replaced in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// startHeartbeat extends the visibility of the message being processed and the messages of the batch waiting behind it
+// every half of the visibility timeout until the returned stop func is called or the max hold time is reached
+func (s *sqsPoller) startHeartbeat(ctx context.Context, messages []types.Message) (stop func()) {
+	if s.maxHold <= 0 || s.visibility <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.heartbeat(ctx, messages)
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// Hold extends the visibility of a message handled in the background after its handler returned,
+// until the returned release func is called or the max hold time is reached
+func (s *sqsPoller) Hold(ctx context.Context, msg types.Message) (release func()) {
+	return s.startHeartbeat(ctx, []types.Message{msg})
+}
+
+func (s *sqsPoller) heartbeat(ctx context.Context, messages []types.Message) {
+	timeout := time.Duration(s.visibility) * time.Second
+	deadline := s.clock.Now().Add(s.maxHold)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(timeout / 2):
+		}
+
+		remaining := deadline.Sub(s.clock.Now())
+		if remaining <= 0 {
+			s.logger.Warn().Str("messageId", MessageID(messages[0])).
+				Msgf("the message is held for %s, it's not extended anymore and may be received again", s.maxHold)
+			return
+		}
+		// the message gets visible at the deadline at the latest
+		extension := timeout
+		if remaining < extension {
+			extension = remaining
+		}
+
+		for _, msg := range messages {
+			if _, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          s.queueURL,
+				ReceiptHandle:     msg.ReceiptHandle,
+				VisibilityTimeout: int32((extension + time.Second - 1) / time.Second),
+			}); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s.logger.Err(err).Str("messageId", MessageID(msg)).Msg("error extending the message visibility")
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock fires the timers when the time is advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	waiting chan struct{}
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0), waiting: make(chan struct{}, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	c.waiting <- struct{}{}
+
+	return ch
+}
+
+// Advance moves the time after a timer is set and fires the expired timers
+func (c *fakeClock) Advance(d time.Duration) {
+	<-c.waiting
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			timers = append(timers, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = timers
+}
+
+func TestSqsPoller_Heartbeat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	single := []types.Message{{MessageId: stringPtr("#1"), ReceiptHandle: stringPtr("rh")}}
+	newPoller := func(t *testing.T, sqsClient *mock_aws.MockSQSAPI, clock Clock, maxHold time.Duration, messages []types.Message) SQSPoller {
+		return newTestPoller(t, sqsClient, strconv.Itoa(len(messages)), messages, SQSParam{MaxHold: maxHold, Clock: clock})
+	}
+
+	t.Run("extended until the handler completes", func(t *testing.T) {
+		clock := newFakeClock()
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		poller := newPoller(t, sqsClient, clock, time.Minute, single)
+
+		extended := make(chan int32, 10)
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+				assert.Equal(t, "url", *in.QueueUrl)
+				assert.Equal(t, "rh", *in.ReceiptHandle)
+				extended <- in.VisibilityTimeout
+				return &sqs.ChangeMessageVisibilityOutput{}, nil
+			}).Times(2)
+
+		err := poller.PollMessages(context.Background(), func(_ SQSPoller, _ types.Message) error {
+			clock.Advance(15 * time.Second)
+			assert.Equal(t, int32(30), <-extended)
+			clock.Advance(15 * time.Second)
+			assert.Equal(t, int32(30), <-extended)
+			// the next beat is set, the handler completes before it
+			<-clock.waiting
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("limited by the max hold", func(t *testing.T) {
+		clock := newFakeClock()
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		poller := newPoller(t, sqsClient, clock, 40*time.Second, single)
+
+		extended := make(chan int32, 10)
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+				extended <- in.VisibilityTimeout
+				return &sqs.ChangeMessageVisibilityOutput{}, nil
+			}).Times(2)
+
+		err := poller.PollMessages(context.Background(), func(_ SQSPoller, _ types.Message) error {
+			// the message gets visible at the max hold
+			clock.Advance(15 * time.Second)
+			assert.Equal(t, int32(25), <-extended)
+			clock.Advance(15 * time.Second)
+			assert.Equal(t, int32(10), <-extended)
+			// the max hold is reached, the heartbeat stops
+			clock.Advance(15 * time.Second)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("the waiting messages of the batch", func(t *testing.T) {
+		clock := newFakeClock()
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		poller := newPoller(t, sqsClient, clock, time.Minute, []types.Message{
+			{MessageId: stringPtr("#1"), ReceiptHandle: stringPtr("rh-1")},
+			{MessageId: stringPtr("#2"), ReceiptHandle: stringPtr("rh-2")},
+		})
+
+		extended := make(chan string, 10)
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+				extended <- *in.ReceiptHandle
+				return &sqs.ChangeMessageVisibilityOutput{}, nil
+			}).Times(2)
+
+		var handled int
+		err := poller.PollMessages(context.Background(), func(_ SQSPoller, _ types.Message) error {
+			handled++
+			if handled == 1 {
+				// the second message waits behind the slow first one
+				clock.Advance(15 * time.Second)
+				assert.Equal(t, "rh-1", <-extended)
+				assert.Equal(t, "rh-2", <-extended)
+			}
+			<-clock.waiting
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, handled)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		poller := newPoller(t, sqsClient, nil, 0, single)
+
+		err := poller.PollMessages(context.Background(), func(_ SQSPoller, _ types.Message) error {
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+}
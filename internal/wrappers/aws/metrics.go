@@ -0,0 +1,225 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace prefixes the metric names
+const metricsNamespace = "sqsdumper"
+
+// operationProcess labels the message handler errors
+const operationProcess = "Process"
+
+// queue depth states
+var depthAttributes = map[types.QueueAttributeName]string{
+	types.QueueAttributeNameApproximateNumberOfMessages:           "visible",
+	types.QueueAttributeNameApproximateNumberOfMessagesNotVisible: "in_flight",
+	types.QueueAttributeNameApproximateNumberOfMessagesDelayed:    "delayed",
+}
+
+// Metrics holds the Prometheus metrics of a polled queue, a nil instance doesn't collect anything
+type Metrics struct {
+	received prometheus.Counter
+	deleted  prometheus.Counter
+	errors   *prometheus.CounterVec
+	latency  prometheus.Histogram
+	depth    *prometheus.GaugeVec
+
+	mu         sync.Mutex
+	receiveErr error
+}
+
+// NewMetrics returns the metrics of the queue registered with the registerer
+func NewMetrics(reg prometheus.Registerer, queue string) *Metrics {
+	labels := prometheus.Labels{"queue": queue}
+	m := &Metrics{
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "messages_received_total",
+			Help:        "The number of the received messages.",
+			ConstLabels: labels,
+		}),
+		deleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "messages_deleted_total",
+			Help:        "The number of the deleted messages.",
+			ConstLabels: labels,
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "errors_total",
+			Help:        "The number of the failed SQS API calls and message handlings by the operation.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "receive_duration_seconds",
+			Help:        "The duration of the ReceiveMessage calls including the long polling wait.",
+			ConstLabels: labels,
+			Buckets:     []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20},
+		}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "queue_messages",
+			Help:        "The approximate number of the queue messages by the state: visible, in_flight or delayed.",
+			ConstLabels: labels,
+		}, []string{"state"}),
+	}
+	reg.MustRegister(m.received, m.deleted, m.errors, m.latency, m.depth)
+
+	return m
+}
+
+// WrapSQSAPI returns the client which collects the metrics of the calls
+func (m *Metrics) WrapSQSAPI(client SQSAPI) SQSAPI {
+	if m == nil {
+		return client
+	}
+
+	return &instrumentedSQSAPI{client: client, metrics: m}
+}
+
+// WrapHandler returns the handler which counts the handling errors
+func (m *Metrics) WrapHandler(handler MessageHandler) MessageHandler {
+	if m == nil {
+		return handler
+	}
+
+	return func(poller SQSPoller, msg types.Message) error {
+		err := handler(poller, msg)
+		if err != nil && !errors.Is(err, ErrStopPolling) {
+			m.errors.WithLabelValues(operationProcess).Inc()
+		}
+
+		return err
+	}
+}
+
+// WatchDepth updates the queue depth every interval until ctx is done
+func (m *Metrics) WatchDepth(ctx context.Context, poller SQSPoller, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.updateDepth(ctx, poller)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Metrics) updateDepth(ctx context.Context, poller SQSPoller) {
+	input := &sqs.GetQueueAttributesInput{}
+	for name := range depthAttributes {
+		input.AttributeNames = append(input.AttributeNames, name)
+	}
+
+	// the calls of the poller are counted by the wrapped client
+	out, err := poller.GetQueueAttrs(ctx, input)
+	if err != nil {
+		return
+	}
+	for name, state := range depthAttributes {
+		if value, err := strconv.ParseFloat(out.Attributes[string(name)], 64); err == nil {
+			m.depth.WithLabelValues(state).Set(value)
+		}
+	}
+}
+
+// Healthy returns the error of the last ReceiveMessage call
+func (m *Metrics) Healthy() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.receiveErr
+}
+
+func (m *Metrics) observe(operation string, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		m.errors.WithLabelValues(operation).Inc()
+	}
+}
+
+type instrumentedSQSAPI struct {
+	client  SQSAPI
+	metrics *Metrics
+}
+
+func (c *instrumentedSQSAPI) GetQueueUrl(ctx context.Context,
+	params *sqs.GetQueueUrlInput,
+	optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	out, err := c.client.GetQueueUrl(ctx, params, optFns...)
+	c.metrics.observe("GetQueueUrl", err)
+
+	return out, err
+}
+
+func (c *instrumentedSQSAPI) ReceiveMessage(ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	start := time.Now()
+	out, err := c.client.ReceiveMessage(ctx, params, optFns...)
+	c.metrics.latency.Observe(time.Since(start).Seconds())
+	c.metrics.observe("ReceiveMessage", err)
+	if err == nil {
+		c.metrics.received.Add(float64(len(out.Messages)))
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		c.metrics.mu.Lock()
+		c.metrics.receiveErr = err
+		c.metrics.mu.Unlock()
+	}
+
+	return out, err
+}
+
+func (c *instrumentedSQSAPI) GetQueueAttributes(ctx context.Context,
+	params *sqs.GetQueueAttributesInput,
+	optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	out, err := c.client.GetQueueAttributes(ctx, params, optFns...)
+	c.metrics.observe("GetQueueAttributes", err)
+
+	return out, err
+}
+
+func (c *instrumentedSQSAPI) DeleteMessage(ctx context.Context,
+	params *sqs.DeleteMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	out, err := c.client.DeleteMessage(ctx, params, optFns...)
+	c.metrics.observe("DeleteMessage", err)
+	if err == nil {
+		c.metrics.deleted.Inc()
+	}
+
+	return out, err
+}
+
+func (c *instrumentedSQSAPI) SendMessage(ctx context.Context,
+	params *sqs.SendMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	out, err := c.client.SendMessage(ctx, params, optFns...)
+	c.metrics.observe("SendMessage", err)
+
+	return out, err
+}
+
+func (c *instrumentedSQSAPI) ChangeMessageVisibility(ctx context.Context,
+	params *sqs.ChangeMessageVisibilityInput,
+	optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	out, err := c.client.ChangeMessageVisibility(ctx, params, optFns...)
+	c.metrics.observe("ChangeMessageVisibility", err)
+
+	return out, err
+}
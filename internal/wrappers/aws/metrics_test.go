@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl := gomock.NewController(t)
+
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).Return(&sqs.GetQueueUrlOutput{}, nil)
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages): "2",
+			},
+		}, nil)
+
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg, "dlq")
+	poller, err := NewSQSPoller(SQSParam{Client: metrics.WrapSQSAPI(sqsClient), Logger: log, Quiet: true, Clock: instantClock{}})
+	assert.NoError(t, err)
+
+	// a failed receive makes the daemon unhealthy until the next successful one
+	gomock.InOrder(
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).Return(nil, errors.New("expired token")),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+				assert.EqualError(t, metrics.Healthy(), "expired token")
+				return &sqs.ReceiveMessageOutput{Messages: []types.Message{
+					{MessageId: stringPtr("#1"), ReceiptHandle: stringPtr("rh-1")},
+					{MessageId: stringPtr("#2"), ReceiptHandle: stringPtr("rh-2")},
+				}}, nil
+			}),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+				cancel()
+				return nil, ctx.Err()
+			}),
+	)
+	sqsClient.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+
+	err = poller.PollMessages(ctx, metrics.WrapHandler(func(poller SQSPoller, msg types.Message) error {
+		if MessageID(msg) == "#2" {
+			return errors.New("bad payload")
+		}
+		_, err := poller.DeleteMessage(ctx, &sqs.DeleteMessageInput{ReceiptHandle: msg.ReceiptHandle})
+		return err
+	}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, metrics.Healthy())
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.received))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.deleted))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.errors.WithLabelValues("ReceiveMessage")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.errors.WithLabelValues(operationProcess)))
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "sqsdumper_receive_duration_seconds" {
+			assert.Equal(t, uint64(3), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+
+	// queue depth
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages):           "5",
+				string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible): "3",
+				string(types.QueueAttributeNameApproximateNumberOfMessagesDelayed):    "0",
+			},
+		}, nil)
+	metrics.updateDepth(context.Background(), poller)
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.depth.WithLabelValues("visible")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.depth.WithLabelValues("in_flight")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.depth.WithLabelValues("delayed")))
+
+	count, err := testutil.GatherAndCount(reg, "sqsdumper_messages_received_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+// instantClock doesn't wait
+type instantClock struct {
+	realClock
+}
+
+func (instantClock) After(time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- time.Now()
+	return c
+}
+
+func TestMetrics_nil(t *testing.T) {
+	var metrics *Metrics
+	client := mock_aws.NewMockSQSAPI(gomock.NewController(t))
+	assert.Equal(t, SQSAPI(client), metrics.WrapSQSAPI(client))
+}
@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// S3URLPrefix starts an S3 location like s3://bucket/prefix/
+const S3URLPrefix = "s3://"
+
+// ParseS3URL splits s3://bucket/prefix into the bucket and the key prefix, false is returned for any other value
+func ParseS3URL(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, S3URLPrefix) {
+		return "", "", false
+	}
+
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(url, S3URLPrefix), "/")
+	if bucket == "" {
+		return "", "", false
+	}
+
+	return bucket, prefix, true
+}
+
+// ObjectStore keeps the objects of a bucket
+type ObjectStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, body []byte) error
+}
+
+// NewS3ObjectStore returns a store of the S3 bucket objects
+func NewS3ObjectStore(client S3API, bucket string) ObjectStore {
+	return &s3ObjectStore{client: client, bucket: bucket}
+}
+
+type s3ObjectStore struct {
+	client S3API
+	bucket string
+}
+
+// Get downloads the object
+func (s *s3ObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting s3://%s/%s", s.bucket, key)
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading s3://%s/%s", s.bucket, key)
+	}
+
+	return body, nil
+}
+
+// Put uploads the object
+func (s *s3ObjectStore) Put(ctx context.Context, key string, body []byte) error {
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(body),
+	}); err != nil {
+		return errors.Wrapf(err, "error uploading s3://%s/%s", s.bucket, key)
+	}
+
+	return nil
+}
+
+// NewDirObjectStore returns a store of the <dir>/<bucket>/<key> files,
+// it stands in for S3 in tests and offline runs
+func NewDirObjectStore(dir, bucket string) ObjectStore {
+	return &dirObjectStore{dir: filepath.Join(dir, bucket)}
+}
+
+type dirObjectStore struct {
+	dir string
+}
+
+// Get reads the object file
+func (s *dirObjectStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the object file")
+	}
+
+	return body, nil
+}
+
+// Put writes the object file, a partial file is never left under the key
+func (s *dirObjectStore) Put(_ context.Context, key string, body []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "error creating the object dir")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return errors.Wrap(err, "error writing the object file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "error writing the object file")
+	}
+
+	return nil
+}
+
+func (s *dirObjectStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(s.dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", errors.Errorf("the object %s is outside of the dir", key)
+	}
+
+	return path, nil
+}
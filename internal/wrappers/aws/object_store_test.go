@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseS3URL(t *testing.T) {
+	for url, want := range map[string][]string{
+		"s3://bucket/dumps/orders/": {"bucket", "dumps/orders/"},
+		"s3://bucket":               {"bucket", ""},
+		"s3:///prefix":              nil,
+		"archive.jsonl":             nil,
+	} {
+		bucket, prefix, ok := ParseS3URL(url)
+		assert.Equal(t, want != nil, ok, url)
+		if ok {
+			assert.Equal(t, want, []string{bucket, prefix}, url)
+		}
+	}
+}
+
+func TestS3ObjectStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	s3Client := mock_aws.NewMockS3API(ctrl)
+	s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "bucket", *in.Bucket)
+			assert.Equal(t, "dumps/chunk-00001.jsonl.gz", *in.Key)
+			body, err := io.ReadAll(in.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "chunk", string(body))
+			return &s3.PutObjectOutput{}, nil
+		})
+	s3Client.EXPECT().GetObject(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			assert.Equal(t, "dumps/manifest.json", *in.Key)
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("{}"))}, nil
+		})
+
+	store := NewS3ObjectStore(s3Client, "bucket")
+	assert.NoError(t, store.Put(context.Background(), "dumps/chunk-00001.jsonl.gz", []byte("chunk")))
+	body, err := store.Get(context.Background(), "dumps/manifest.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(body))
+}
+
+func TestDirObjectStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := NewDirObjectStore(dir, "bucket")
+	assert.NoError(t, store.Put(ctx, "dumps/manifest.json", []byte("{}")))
+	assert.NoError(t, store.Put(ctx, "dumps/manifest.json", []byte(`{"chunks":[]}`)))
+	content, err := os.ReadFile(filepath.Join(dir, "bucket", "dumps", "manifest.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"chunks":[]}`, string(content))
+	body, err := store.Get(ctx, "dumps/manifest.json")
+	assert.NoError(t, err)
+	assert.Equal(t, content, body)
+
+	assert.Error(t, store.Put(ctx, "../../etc/passwd", []byte("x")))
+}
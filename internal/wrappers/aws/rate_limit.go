@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is a client-side token-bucket limiter for processed messages and SQS API calls,
+// a single instance is safe to share between concurrent workers, a nil limiter doesn't limit anything
+type RateLimiter struct {
+	messages *rate.Limiter
+	apiCalls *rate.Limiter
+}
+
+// NewRateLimiter returns a limiter for messages and API calls per second, zero means unlimited
+func NewRateLimiter(messagesPerSec, callsPerSec float64) *RateLimiter {
+	if messagesPerSec <= 0 && callsPerSec <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		messages: newLimiter(messagesPerSec),
+		apiCalls: newLimiter(callsPerSec),
+	}
+}
+
+func newLimiter(perSec float64) *rate.Limiter {
+	if perSec <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(perSec), int(math.Max(1, math.Ceil(perSec))))
+}
+
+// WaitMessage blocks until the next message is allowed to be processed
+func (r *RateLimiter) WaitMessage(ctx context.Context) error {
+	if r == nil || r.messages == nil {
+		return nil
+	}
+
+	return r.messages.Wait(ctx)
+}
+
+// WaitCall blocks until the next API call is allowed
+func (r *RateLimiter) WaitCall(ctx context.Context) error {
+	if r == nil || r.apiCalls == nil {
+		return nil
+	}
+
+	return r.apiCalls.Wait(ctx)
+}
+
+// String describes the limits for the progress output
+func (r *RateLimiter) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var limits []string
+	if r.messages != nil {
+		limits = append(limits, fmt.Sprintf("%g msg/s", float64(r.messages.Limit())))
+	}
+	if r.apiCalls != nil {
+		limits = append(limits, fmt.Sprintf("%g call/s", float64(r.apiCalls.Limit())))
+	}
+
+	return strings.Join(limits, ", ")
+}
+
+// WrapSQSAPI returns the client which waits for the API calls limit before every call
+func (r *RateLimiter) WrapSQSAPI(client SQSAPI) SQSAPI {
+	if r == nil || r.apiCalls == nil {
+		return client
+	}
+
+	return &rateLimitedSQSAPI{client: client, limiter: r}
+}
+
+type rateLimitedSQSAPI struct {
+	client  SQSAPI
+	limiter *RateLimiter
+}
+
+func (c *rateLimitedSQSAPI) GetQueueUrl(ctx context.Context,
+	params *sqs.GetQueueUrlInput,
+	optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.GetQueueUrl(ctx, params, optFns...)
+}
+
+func (c *rateLimitedSQSAPI) ReceiveMessage(ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.ReceiveMessage(ctx, params, optFns...)
+}
+
+func (c *rateLimitedSQSAPI) GetQueueAttributes(ctx context.Context,
+	params *sqs.GetQueueAttributesInput,
+	optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.GetQueueAttributes(ctx, params, optFns...)
+}
+
+func (c *rateLimitedSQSAPI) DeleteMessage(ctx context.Context,
+	params *sqs.DeleteMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.DeleteMessage(ctx, params, optFns...)
+}
+
+func (c *rateLimitedSQSAPI) SendMessage(ctx context.Context,
+	params *sqs.SendMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.SendMessage(ctx, params, optFns...)
+}
+
+func (c *rateLimitedSQSAPI) ChangeMessageVisibility(ctx context.Context,
+	params *sqs.ChangeMessageVisibilityInput,
+	optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if err := c.limiter.WaitCall(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.client.ChangeMessageVisibility(ctx, params, optFns...)
+}
@@ -0,0 +1,52 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0, 0))
+
+	var unlimited *RateLimiter
+	assert.NoError(t, unlimited.WaitMessage(context.Background()))
+	assert.NoError(t, unlimited.WaitCall(context.Background()))
+	assert.Equal(t, "", unlimited.String())
+
+	assert.Equal(t, "10 msg/s", NewRateLimiter(10, 0).String())
+	assert.Equal(t, "0.5 msg/s, 2 call/s", NewRateLimiter(0.5, 2).String())
+}
+
+func TestRateLimiter_WaitCall(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	sqsClient.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).
+		Return(&sqs.DeleteMessageOutput{}, nil).Times(3)
+
+	limiter := NewRateLimiter(0, 20)
+	client := limiter.WrapSQSAPI(sqsClient)
+	assert.Same(t, sqsClient, NewRateLimiter(5, 0).WrapSQSAPI(sqsClient))
+
+	// the burst is used up by the first 20 calls, the rest are spread over 50ms each
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, limiter.WaitCall(context.Background()))
+	}
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{})
+		assert.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{})
+	assert.Error(t, err)
+}
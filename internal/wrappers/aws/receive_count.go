@@ -0,0 +1,18 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ReceiveCountFilter returns a filter accepting the messages which ApproximateReceiveCount,
+// including the current receive, is within the bounds, a zero bound is open
+func ReceiveCountFilter(minCount, maxCount int) MessageFilter {
+	return func(msg types.Message) bool {
+		count, ok := ReceiveCount(msg)
+		if !ok {
+			return false
+		}
+
+		return count >= minCount && (maxCount == 0 || count <= maxCount)
+	}
+}
@@ -0,0 +1,31 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveCountFilter(t *testing.T) {
+	received := func(count string) types.Message {
+		return types.Message{Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): count,
+		}}
+	}
+
+	parking := ReceiveCountFilter(11, 0)
+	assert.True(t, parking(received("11")))
+	assert.True(t, parking(received("40")))
+	assert.False(t, parking(received("10")))
+	assert.False(t, parking(types.Message{}), "no ApproximateReceiveCount")
+
+	firstTime := ReceiveCountFilter(0, 1)
+	assert.True(t, firstTime(received("1")))
+	assert.False(t, firstTime(received("2")))
+
+	between := ReceiveCountFilter(2, 3)
+	assert.False(t, between(received("1")))
+	assert.True(t, between(received("3")))
+	assert.False(t, between(received("4")))
+}
@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// PayloadS3PointerClass is the class name the SQS Extended Client Library puts in front of the pointer
+const PayloadS3PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// PayloadS3Pointer points to a message payload offloaded to S3 by the SQS Extended Client Library
+type PayloadS3Pointer struct {
+	S3BucketName string `json:"s3BucketName"`
+	S3Key        string `json:"s3Key"`
+}
+
+// ParsePayloadS3Pointer parses a body like ["software.amazon.payloadoffloading.PayloadS3Pointer",{...}],
+// false is returned for any other body
+func ParsePayloadS3Pointer(body string) (PayloadS3Pointer, bool) {
+	var pointer PayloadS3Pointer
+
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "[") || !strings.Contains(body, PayloadS3PointerClass) {
+		return pointer, false
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &parts); err != nil || len(parts) != 2 {
+		return pointer, false
+	}
+
+	var class string
+	if err := json.Unmarshal(parts[0], &class); err != nil || class != PayloadS3PointerClass {
+		return pointer, false
+	}
+	if err := json.Unmarshal(parts[1], &pointer); err != nil || pointer.S3BucketName == "" || pointer.S3Key == "" {
+		return pointer, false
+	}
+
+	return pointer, true
+}
+
+// PayloadStore fetches and deletes the offloaded message payloads
+type PayloadStore interface {
+	Get(ctx context.Context, pointer PayloadS3Pointer) ([]byte, error)
+	Delete(ctx context.Context, pointer PayloadS3Pointer) error
+}
+
+// NewS3PayloadStore returns a store reading the payloads from S3
+func NewS3PayloadStore(client S3API) PayloadStore {
+	return &s3PayloadStore{client: client}
+}
+
+type s3PayloadStore struct {
+	client S3API
+}
+
+// Get downloads the payload object
+func (s *s3PayloadStore) Get(ctx context.Context, pointer PayloadS3Pointer) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &pointer.S3BucketName,
+		Key:    &pointer.S3Key,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting the payload s3://%s/%s", pointer.S3BucketName, pointer.S3Key)
+	}
+	defer output.Body.Close()
+
+	payload, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the payload s3://%s/%s", pointer.S3BucketName, pointer.S3Key)
+	}
+
+	return payload, nil
+}
+
+// Delete removes the payload object
+func (s *s3PayloadStore) Delete(ctx context.Context, pointer PayloadS3Pointer) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &pointer.S3BucketName,
+		Key:    &pointer.S3Key,
+	}); err != nil {
+		return errors.Wrapf(err, "error deleting the payload s3://%s/%s", pointer.S3BucketName, pointer.S3Key)
+	}
+
+	return nil
+}
+
+// NewDirPayloadStore returns a store reading the payloads from the <dir>/<bucket>/<key> files,
+// it stands in for S3 in tests and for payloads downloaded beforehand
+func NewDirPayloadStore(dir string) PayloadStore {
+	return &dirPayloadStore{dir: dir}
+}
+
+type dirPayloadStore struct {
+	dir string
+}
+
+// Get reads the payload file
+func (s *dirPayloadStore) Get(_ context.Context, pointer PayloadS3Pointer) ([]byte, error) {
+	path, err := s.path(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the payload file")
+	}
+
+	return payload, nil
+}
+
+// Delete removes the payload file
+func (s *dirPayloadStore) Delete(_ context.Context, pointer PayloadS3Pointer) error {
+	path, err := s.path(pointer)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return errors.Wrap(err, "error deleting the payload file")
+	}
+
+	return nil
+}
+
+func (s *dirPayloadStore) path(pointer PayloadS3Pointer) (string, error) {
+	path := filepath.Join(s.dir, pointer.S3BucketName, filepath.FromSlash(pointer.S3Key))
+	if rel, err := filepath.Rel(s.dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", errors.Errorf("the payload s3://%s/%s is outside of the payload dir", pointer.S3BucketName, pointer.S3Key)
+	}
+
+	return path, nil
+}
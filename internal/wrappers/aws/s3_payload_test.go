@@ -0,0 +1,74 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const s3PointerBody = `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"dir/key"}]`
+
+func TestParsePayloadS3Pointer(t *testing.T) {
+	pointer, ok := ParsePayloadS3Pointer(s3PointerBody)
+	assert.True(t, ok)
+	assert.Equal(t, PayloadS3Pointer{S3BucketName: "bucket", S3Key: "dir/key"}, pointer)
+
+	for _, body := range []string{
+		`{"foo":"bar"}`,
+		`["software.amazon.payloadoffloading.PayloadS3Pointer"]`,
+		`["other.Class",{"s3BucketName":"bucket","s3Key":"key"}]`,
+		`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket"}]`,
+	} {
+		_, ok := ParsePayloadS3Pointer(body)
+		assert.False(t, ok, body)
+	}
+}
+
+func TestDirPayloadStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "bucket", "dir"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bucket", "dir", "key"), []byte(`{"big":true}`), 0644))
+
+	store := NewDirPayloadStore(dir)
+	pointer := PayloadS3Pointer{S3BucketName: "bucket", S3Key: "dir/key"}
+	payload, err := store.Get(ctx, pointer)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"big":true}`, string(payload))
+
+	assert.NoError(t, store.Delete(ctx, pointer))
+	_, err = store.Get(ctx, pointer)
+	assert.Error(t, err)
+
+	_, err = store.Get(ctx, PayloadS3Pointer{S3BucketName: "bucket", S3Key: "../../etc/passwd"})
+	assert.Error(t, err)
+}
+
+func TestS3PayloadStore(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	s3Client := mock_aws.NewMockS3API(ctrl)
+	s3Client.EXPECT().GetObject(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			assert.Equal(t, "bucket", *in.Bucket)
+			assert.Equal(t, "dir/key", *in.Key)
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("payload"))}, nil
+		})
+	s3Client.EXPECT().DeleteObject(gomock.Any(), gomock.Any()).Return(&s3.DeleteObjectOutput{}, nil)
+
+	store := NewS3PayloadStore(s3Client)
+	pointer, _ := ParsePayloadS3Pointer(s3PointerBody)
+	payload, err := store.Get(ctx, pointer)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(payload))
+	assert.NoError(t, store.Delete(ctx, pointer))
+}
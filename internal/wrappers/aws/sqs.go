@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
@@ -12,31 +13,67 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
+// ErrStopPolling is returned by a MessageHandler to stop the polling without an error after the message
+var ErrStopPolling = errors.New("stop polling")
+
+// ErrReceivedAgain is returned by a scanning MessageHandler for a message it has seen already,
+// the polling stops and the message isn't counted again, the handler holds and releases it
+var ErrReceivedAgain = errors.Wrap(ErrStopPolling, "the message is received again")
+
+// errLeftInQueue is returned by handleMessage when the polling is canceled before the message is handled,
+// the message isn't counted
+var errLeftInQueue = errors.New("the message is left in the queue")
+
 // MessageHandler represents a single SQS message handler
 type MessageHandler func(poller SQSPoller, msg types.Message) error
 
+// MessageFilter reports whether a received message has to be handled,
+// a filtered out message isn't handled and stays invisible until its visibility timeout expires
+type MessageFilter func(msg types.Message) bool
+
 //go:generate mockgen -source=$GOFILE -destination=../../mocks/mock_sqs/mock_$GOFILE
 
 // SQSPoller represents a long-polling Amazon SQS queue
 type SQSPoller interface {
 	GetQueueURL() *string
 	GetTotal() int
+	IsFIFO() bool
+	GetSummary() Summary
 	PollMessages(ctx context.Context, messageHandler MessageHandler) error
 	DeleteMessage(ctx context.Context, input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
 	GetQueueAttrs(ctx context.Context, input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error)
+	Hold(ctx context.Context, msg types.Message) (release func())
 }
 
 type sqsPoller struct {
-	client        SQSAPI
-	logger        zerolog.Logger
-	cfg           ConfigQueue
-	queueURL      *string
-	stopOnTotal   bool
-	stopAfter     int
-	totalMessages int
-	checkReceived map[string]struct{}
-	counterChan   chan int
-	bar           *progressbar.ProgressBar
+	client          SQSAPI
+	logger          zerolog.Logger
+	cfg             ConfigQueue
+	queueURL        *string
+	stopOnTotal     bool
+	stopAfter       int
+	totalMessages   int
+	checkReceived   *receivedKeys
+	deletedHandles  *receivedKeys
+	counterChan     chan int
+	bar             *progressbar.ProgressBar
+	errorPolicy     ErrorPolicy
+	quarantine      Quarantine
+	rateLimiter     *RateLimiter
+	filters         []MessageFilter
+	fifo            bool
+	quiet           bool
+	maxHold         time.Duration
+	clock           Clock
+	visibility      int32
+	dedup           DedupMode
+	dedupStore      *DedupStore
+	deleteDups      bool
+	releaseFiltered bool
+	releasedIDs     map[string]struct{}
+	filteredHandler MessageHandler
+	summary         Summary
 }
 
 // SQSParam holds SQSPoller params
@@ -47,19 +84,70 @@ type SQSParam struct {
 	StopOnTotal bool
 	StopAfter   int
 	CounterChan chan int
+	ErrorPolicy ErrorPolicy
+	RateLimiter *RateLimiter
+	Filters     []MessageFilter
+	// Quiet hides the progress bar, for the commands owning the terminal
+	Quiet bool
+	// MaxHold limits the visibility extensions of a message being processed, 0 disables them
+	MaxHold time.Duration
+	// Clock is the real clock if nil
+	Clock Clock
+	// Dedup skips the messages handled already in this run or found in DedupStore, empty disables it
+	Dedup      DedupMode
+	DedupStore *DedupStore
+	// DeleteDuplicates deletes the skipped duplicates, otherwise they stay invisible until the visibility timeout
+	DeleteDuplicates bool
+	// ReleaseFiltered makes the filtered messages visible shortly, otherwise they stay invisible until the visibility timeout
+	ReleaseFiltered bool
+	// FilteredHandler receives the filtered messages which aren't released, like a scan holding them
+	// to release them with the scanned ones; ErrStopPolling stops the polling
+	FilteredHandler MessageHandler
+}
+
+// Summary holds the polling run results
+type Summary struct {
+	Processed   int
+	Failed      int
+	Retried     int
+	Quarantined int
+	Filtered    int
+	Duplicates  int
+	ErrorPolicy ErrorPolicy
+}
+
+// String returns the summary line
+func (s Summary) String() string {
+	return fmt.Sprintf("total processed: %d, filtered: %d, duplicates: %d, failed: %d, retried: %d, quarantined: %d (on-error: %s)",
+		s.Processed, s.Filtered, s.Duplicates, s.Failed, s.Retried, s.Quarantined, s.ErrorPolicy)
 }
 
 // NewSQSPoller returns an instance of SQSPoller
 func NewSQSPoller(params SQSParam) (SQSPoller, error) {
 	s := &sqsPoller{
-		client:        params.Client,
-		logger:        params.Logger,
-		cfg:           params.QueueConfig,
-		stopOnTotal:   params.StopOnTotal,
-		counterChan:   params.CounterChan,
-		stopAfter:     params.StopAfter,
-		checkReceived: map[string]struct{}{},
+		client:          params.RateLimiter.WrapSQSAPI(params.Client),
+		logger:          params.Logger,
+		cfg:             params.QueueConfig,
+		stopOnTotal:     params.StopOnTotal,
+		counterChan:     params.CounterChan,
+		stopAfter:       params.StopAfter,
+		checkReceived:   newReceivedKeys(maxReceivedKeys),
+		deletedHandles:  newReceivedKeys(maxReceivedKeys),
+		errorPolicy:     params.ErrorPolicy,
+		rateLimiter:     params.RateLimiter,
+		filters:         params.Filters,
+		quiet:           params.Quiet,
+		maxHold:         params.MaxHold,
+		clock:           params.Clock,
+		dedup:           params.Dedup,
+		dedupStore:      params.DedupStore,
+		deleteDups:      params.DeleteDuplicates,
+		releaseFiltered: params.ReleaseFiltered,
+		releasedIDs:     map[string]struct{}{},
+		filteredHandler: params.FilteredHandler,
+		summary:         Summary{ErrorPolicy: params.ErrorPolicy},
 	}
+
 	queueURL, err := s.fetchQueueURL(context.Background(), s.cfg.QueueName)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting AWS SQS queue URL")
@@ -71,15 +159,49 @@ func NewSQSPoller(params SQSParam) (SQSPoller, error) {
 		QueueUrl: queueURL.QueueUrl,
 		AttributeNames: []types.QueueAttributeName{
 			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameFifoQueue,
+			types.QueueAttributeNameVisibilityTimeout,
 		},
 	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting AWS SQS queue attributes")
+	}
 
+	s.fifo = queueAttrs.Attributes[string(types.QueueAttributeNameFifoQueue)] == "true"
 	s.totalMessages, err = strconv.Atoi(queueAttrs.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting total number of messages from AWS SQS queue URL")
 	}
 
-	s.bar = progressbar.Default(int64(s.totalMessages), "Processing..")
+	s.visibility = s.cfg.VisibilityTimeout
+	if s.visibility == 0 {
+		// the queue default, a missing attribute disables the heartbeat
+		timeout, _ := strconv.Atoi(queueAttrs.Attributes[string(types.QueueAttributeNameVisibilityTimeout)])
+		s.visibility = int32(timeout)
+	}
+	if s.clock == nil {
+		s.clock = realClock{}
+	}
+
+	if s.errorPolicy.Action == ErrorActionQuarantine {
+		s.quarantine, err = NewQuarantine(context.Background(), s.client, s.errorPolicy.Target)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating the quarantine")
+		}
+		if file, ok := s.quarantine.(*fileQuarantine); ok {
+			file.redact = s.errorPolicy.Redact
+		}
+	}
+
+	description := "Processing.."
+	if limits := s.rateLimiter.String(); limits != "" {
+		description = fmt.Sprintf("Processing (limited to %s)..", limits)
+	}
+	if s.quiet {
+		s.bar = progressbar.DefaultSilent(int64(s.totalMessages), description)
+	} else {
+		s.bar = progressbar.Default(int64(s.totalMessages), description)
+	}
 
 	return s, nil
 }
@@ -92,11 +214,22 @@ func (s *sqsPoller) GetTotal() int {
 	return s.totalMessages
 }
 
+func (s *sqsPoller) IsFIFO() bool {
+	return s.fifo
+}
+
+func (s *sqsPoller) GetSummary() Summary {
+	return s.summary
+}
+
 func (s *sqsPoller) PollMessages(ctx context.Context, messageHandler MessageHandler) error {
 	if messageHandler == nil {
 		return errors.New("a message handler is nil, stopped")
 	}
-	var processed int
+
+	// a FIFO receive is retried with the same attempt id after a failure,
+	// so SQS returns the same messages instead of hiding them until the visibility timeout
+	var attemptID *string
 
 	// start polling
 	for {
@@ -105,37 +238,211 @@ func (s *sqsPoller) PollMessages(ctx context.Context, messageHandler MessageHand
 			s.logger.Log().Msg("got context.Done signal, exiting processing")
 			return nil
 		default:
-			output, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-				QueueUrl:            s.queueURL,
-				MaxNumberOfMessages: s.cfg.MaxMessagesPerRetrieval,
-				WaitTimeSeconds:     s.cfg.WaitTimeSeconds,
-			})
+			input := &sqs.ReceiveMessageInput{
+				QueueUrl:              s.queueURL,
+				MaxNumberOfMessages:   s.maxMessages(),
+				WaitTimeSeconds:       s.cfg.WaitTimeSeconds,
+				VisibilityTimeout:     s.cfg.VisibilityTimeout,
+				AttributeNames:        []types.QueueAttributeName{types.QueueAttributeNameAll},
+				MessageAttributeNames: []string{string(types.QueueAttributeNameAll)},
+			}
+			if s.fifo {
+				if attemptID == nil {
+					attemptID = stringPtr(newReceiveAttemptID())
+				}
+				input.ReceiveRequestAttemptId = attemptID
+			}
+
+			output, err := s.client.ReceiveMessage(ctx, input)
 			if err != nil {
 				s.logger.Err(err).Msg("can't get new messages from SQS")
+				// don't spin on a lasting failure like expired credentials
+				select {
+				case <-ctx.Done():
+				case <-s.clock.After(receiveErrorDelay):
+				}
 				continue
 			}
-			for _, message := range output.Messages {
-				if err := messageHandler(s, message); err != nil {
-					s.logger.Err(err).Msg("processing error")
+			attemptID = nil
+
+			for i, message := range output.Messages {
+				// the messages after the stop aren't handled, they are released at once
+				rest := output.Messages[i+1:]
+				if s.duplicate(message) {
+					s.skipDuplicate(ctx, message)
+					continue
+				}
+
+				if s.accept(message) {
+					if err := s.rateLimiter.WaitMessage(ctx); err != nil {
+						s.releaseRest(output.Messages[i:])
+						s.logger.Log().Msg("got context.Done signal, exiting processing")
+						return nil
+					}
+					stopHeartbeat := s.startHeartbeat(ctx, output.Messages[i:])
+					handled, err := s.handleMessage(ctx, messageHandler, message)
+					stopHeartbeat()
+					if errors.Is(err, errLeftInQueue) {
+						// canceled while retried, the message isn't counted and is released with the rest
+						s.releaseRest(output.Messages[i:])
+						s.endBar()
+						s.logger.Log().Msg("got context.Done signal, exiting processing")
+						return nil
+					}
+					if errors.Is(err, ErrStopPolling) {
+						if !errors.Is(err, ErrReceivedAgain) {
+							s.summary.Processed++
+							s.markReceived(message)
+							s.bar.Add(1)
+						}
+						s.releaseRest(rest)
+						s.endBar()
+						s.logger.Log().Msgf("stopped by the handler after %d messages processed", s.summary.Processed)
+						return nil
+					}
+					if err != nil {
+						s.releaseRest(rest)
+						s.endBar()
+						return errors.Wrapf(err, "stopped on message %s", stringValue(message.MessageId))
+					}
+					s.summary.Processed++
+					if handled {
+						s.markReceived(message)
+					}
+				} else {
+					// a released message is received again until the others are seen, it's counted once
+					seen := s.released(message)
+					s.release(ctx, message)
+					if seen {
+						continue
+					}
+					if err := s.handleFiltered(message); err != nil {
+						s.releaseRest(rest)
+						s.endBar()
+						if errors.Is(err, ErrStopPolling) {
+							s.logger.Log().Msgf("stopped by the handler after %d messages processed", s.summary.Processed)
+							return nil
+						}
+						return errors.Wrapf(err, "stopped on message %s", stringValue(message.MessageId))
+					}
+					s.summary.Filtered++
 				}
 				s.bar.Add(1)
 
-				if s.stopAfter != 0 && processed >= s.stopAfter {
-					fmt.Printf("\n")
-					s.logger.Log().Msgf("stopped after %d messages processed", processed)
+				if s.stopAfter != 0 && s.summary.Processed >= s.stopAfter {
+					s.releaseRest(rest)
+					s.endBar()
+					s.logger.Log().Msgf("stopped after %d messages processed", s.summary.Processed)
 					return nil
 				}
 
 				if s.bar.IsFinished() && s.stopOnTotal {
-					fmt.Printf("\n")
+					s.releaseRest(rest)
+					s.endBar()
 					s.logger.Log().Msg("all messages processed")
 					return nil
 				}
 			}
 		}
 	}
+}
+
+// maxMessages limits the receive to the messages left before stopAfter
+func (s *sqsPoller) maxMessages() int32 {
+	max := s.cfg.MaxMessagesPerRetrieval
+	if s.stopAfter > 0 && s.stopAfter-s.summary.Processed < int(max) {
+		max = int32(s.stopAfter - s.summary.Processed)
+	}
+
+	return max
+}
+
+// releaseRest makes the received messages left unhandled by the stop visible again,
+// so they aren't hidden from the other consumers until the visibility timeout
+func (s *sqsPoller) releaseRest(messages []types.Message) {
+	for _, msg := range messages {
+		// the polling context may be canceled already
+		if _, err := s.client.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          s.queueURL,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: 0,
+		}); err != nil {
+			s.logger.Err(err).Str("messageId", MessageID(msg)).Msg("error releasing the unhandled message")
+		}
+	}
+}
+
+func (s *sqsPoller) accept(msg types.Message) bool {
+	for _, filter := range s.filters {
+		if !filter(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleMessage runs the handler and applies the error policy on failure, handled reports the handler succeeded,
+// only an error which must stop the polling or errLeftInQueue is returned
+func (s *sqsPoller) handleMessage(ctx context.Context, messageHandler MessageHandler, msg types.Message) (handled bool, err error) {
+	err = messageHandler(s, msg)
+	if err == nil || errors.Is(err, ErrStopPolling) {
+		return true, err
+	}
+
+	if s.errorPolicy.Action == ErrorActionRetry {
+		// the backoff stays within the hold of the message, so it isn't received again while retried
+		holdLimit := s.maxHold
+		if holdLimit <= 0 {
+			holdLimit = time.Duration(s.visibility) * time.Second
+		}
+		delay, waited := retryDelay, time.Duration(0)
+		for i := 0; i < s.errorPolicy.Retries && err != nil; i++ {
+			if holdLimit > 0 && waited+delay > holdLimit {
+				delay = holdLimit - waited
+			}
+			if delay <= 0 {
+				s.logger.Warn().Str("messageId", MessageID(msg)).Msgf("the retries reached the hold limit of %s", holdLimit)
+				break
+			}
+			s.logger.Warn().Err(err).Msgf("processing error, retry %d of %d in %s", i+1, s.errorPolicy.Retries, delay)
+			select {
+			case <-ctx.Done():
+				return false, errLeftInQueue
+			case <-s.clock.After(delay):
+			}
+			waited += delay
+			delay *= 2
+
+			s.summary.Retried++
+			err = messageHandler(s, msg)
+		}
+		if err == nil {
+			return true, nil
+		}
+	}
+
+	s.summary.Failed++
+	s.logger.Err(err).Str("messageId", stringValue(msg.MessageId)).Msg("processing error")
+
+	switch s.errorPolicy.Action {
+	case ErrorActionStop:
+		return false, err
+	case ErrorActionQuarantine:
+		if qErr := s.quarantine.Put(ctx, msg, err); qErr != nil {
+			s.logger.Err(qErr).Msg("error quarantining the message")
+			return false, nil
+		}
+		s.summary.Quarantined++
+		if !s.errorPolicy.Delete {
+			return false, nil
+		}
+		if _, dErr := s.DeleteMessage(ctx, &sqs.DeleteMessageInput{ReceiptHandle: msg.ReceiptHandle}); dErr != nil {
+			s.logger.Err(dErr).Msg("error deleting the quarantined message")
+		}
+	}
 
-	return nil
+	return false, nil
 }
 
 func (s *sqsPoller) fetchQueueURL(ctx context.Context, queue string) (*sqs.GetQueueUrlOutput, error) {
@@ -146,10 +453,27 @@ func (s *sqsPoller) fetchQueueURL(ctx context.Context, queue string) (*sqs.GetQu
 
 func (s *sqsPoller) DeleteMessage(ctx context.Context, input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
 	input.QueueUrl = s.queueURL
-	return s.client.DeleteMessage(ctx, input)
+	out, err := s.client.DeleteMessage(ctx, input)
+	if err == nil {
+		s.markDeleted(input.ReceiptHandle)
+	}
+
+	return out, err
+}
+
+func (s *sqsPoller) ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	input.QueueUrl = s.queueURL
+	return s.client.ChangeMessageVisibility(ctx, input)
 }
 
 func (s *sqsPoller) GetQueueAttrs(ctx context.Context, input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
 	input.QueueUrl = s.queueURL
 	return s.client.GetQueueAttributes(ctx, input)
 }
+
+// endBar ends the progress bar line
+func (s *sqsPoller) endBar() {
+	if !s.quiet {
+		fmt.Printf("\n")
+	}
+}
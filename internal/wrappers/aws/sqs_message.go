@@ -2,7 +2,10 @@ package aws
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +20,14 @@ type EventMessage struct {
 	Signature        string           `json:"Signature"`
 	SigningCertURL   string           `json:"SigningCertURL"`
 	UnsubscribeURL   string           `json:"UnsubscribeURL"`
+
+	MessageAttributes map[string]SNSMessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// SNSMessageAttribute is a message attribute of the SNS envelope, binary values are base64 encoded
+type SNSMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
 }
 
 // ParseEventMessage parses aws types.Message body to an EventMessage
@@ -29,3 +40,33 @@ func ParseEventMessage(body string) (EventMessage, error) {
 
 	return result, nil
 }
+
+// MessageID returns the message id or an empty string
+func MessageID(msg types.Message) string {
+	return stringValue(msg.MessageId)
+}
+
+// MessageBody returns the message body or an empty string
+func MessageBody(msg types.Message) string {
+	return stringValue(msg.Body)
+}
+
+// SentTimestamp returns the time the message was sent at from the SentTimestamp attribute
+func SentTimestamp(msg types.Message) (time.Time, bool) {
+	millis, err := strconv.ParseInt(msg.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.UnixMilli(millis).UTC(), true
+}
+
+// ReceiveCount returns the ApproximateReceiveCount attribute
+func ReceiveCount(msg types.Message) (int, bool) {
+	count, err := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if err != nil {
+		return 0, false
+	}
+
+	return count, true
+}
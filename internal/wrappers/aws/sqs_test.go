@@ -2,8 +2,13 @@ package aws
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"andboson/sqsdumper/internal/mocks/mock_aws"
 
@@ -17,6 +22,30 @@ import (
 
 var log = zerolog.New(os.Stderr).With().Logger()
 
+// newTestPoller returns a poller of the "url" queue with the total number of messages,
+// which receives the messages once and stops on the total
+func newTestPoller(t *testing.T, sqsClient *mock_aws.MockSQSAPI, total string, messages []types.Message, params SQSParam) SQSPoller {
+	sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueUrlOutput{QueueUrl: stringPtr("url")}, nil)
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages): total,
+				string(types.QueueAttributeNameVisibilityTimeout):           "30",
+			},
+		}, nil)
+	sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+		Return(&sqs.ReceiveMessageOutput{Messages: messages}, nil)
+
+	params.Client = sqsClient
+	params.Logger = log
+	params.StopOnTotal = true
+	poller, err := NewSQSPoller(params)
+	assert.NoError(t, err)
+
+	return poller
+}
+
 func TestSqsPoller_fetchQueueURL(t *testing.T) {
 	ctx := context.Background()
 	awsClient := NewAWSClient()
@@ -129,3 +158,221 @@ func TestSqsPoller_PollMessagesErrorProcessing(t *testing.T) {
 		assert.Equal(t, 1, gotMessages)
 	})
 }
+
+func TestSqsPoller_PollMessagesErrorPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	clock := &delayClock{}
+	msgID := "#1"
+	newPoller := func(t *testing.T, policy ErrorPolicy) (*mock_aws.MockSQSAPI, SQSPoller) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		if policy.Action == ErrorActionQuarantine && !strings.HasPrefix(policy.Target, quarantineFilePrefix) {
+			sqsClient.EXPECT().GetQueueUrl(gomock.Any(), &sqs.GetQueueUrlInput{QueueName: stringPtr(policy.Target)}).
+				Return(&sqs.GetQueueUrlOutput{QueueUrl: stringPtr("quarantine-url")}, nil)
+		}
+		poller := newTestPoller(t, sqsClient, "1", []types.Message{{MessageId: &msgID, Body: &msgID}},
+			SQSParam{ErrorPolicy: policy, Clock: clock})
+
+		return sqsClient, poller
+	}
+
+	t.Run("stop", func(t *testing.T) {
+		_, poller := newPoller(t, ErrorPolicy{Action: ErrorActionStop})
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			return errors.New("some error")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, poller.GetSummary().Failed)
+		assert.Equal(t, 0, poller.GetSummary().Processed)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		_, poller := newPoller(t, ErrorPolicy{Action: ErrorActionRetry, Retries: 3})
+		var calls int
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			calls++
+			if calls < 3 {
+				return errors.New("some error")
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+		summary := poller.GetSummary()
+		assert.Equal(t, 2, summary.Retried)
+		assert.Equal(t, 0, summary.Failed)
+		assert.Equal(t, 1, summary.Processed)
+		// the retries back off
+		assert.Equal(t, []time.Duration{retryDelay, 2 * retryDelay}, clock.delays)
+	})
+
+	t.Run("retry within the hold", func(t *testing.T) {
+		clock := &delayClock{}
+		poller := newTestPoller(t, mock_aws.NewMockSQSAPI(ctrl), "1", []types.Message{{MessageId: &msgID, Body: &msgID}},
+			SQSParam{ErrorPolicy: ErrorPolicy{Action: ErrorActionRetry, Retries: 10}, Clock: clock})
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			return errors.New("some error")
+		})
+		assert.NoError(t, err)
+		// the backoff is capped by the 30s visibility without --max-hold
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 15 * time.Second}, clock.delays)
+		assert.Equal(t, 5, poller.GetSummary().Retried)
+		assert.Equal(t, 1, poller.GetSummary().Failed)
+	})
+
+	t.Run("retry canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		// the message is released, not counted
+		sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:      stringPtr("url"),
+			ReceiptHandle: stringPtr("rh-1"),
+		}).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+		poller := newTestPoller(t, sqsClient, "1", []types.Message{{MessageId: &msgID, ReceiptHandle: stringPtr("rh-1")}},
+			SQSParam{ErrorPolicy: ErrorPolicy{Action: ErrorActionRetry, Retries: 3}, Clock: newFakeClock()})
+
+		err := poller.PollMessages(ctx, func(poller SQSPoller, msg types.Message) error {
+			cancel()
+			return errors.New("some error")
+		})
+		assert.NoError(t, err)
+		summary := poller.GetSummary()
+		assert.Equal(t, 0, summary.Processed)
+		assert.Equal(t, 0, summary.Failed)
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		sqsClient, poller := newPoller(t, ErrorPolicy{Action: ErrorActionQuarantine, Target: "bad-queue", Delete: true})
+		sqsClient.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(&sqs.SendMessageOutput{}, nil)
+		sqsClient.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			return errors.New("some error")
+		})
+		assert.NoError(t, err)
+		summary := poller.GetSummary()
+		assert.Equal(t, 1, summary.Failed)
+		assert.Equal(t, 1, summary.Quarantined)
+		assert.Equal(t, 1, summary.Processed)
+	})
+
+	t.Run("redacted quarantine file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bad.jsonl")
+		_, poller := newPoller(t, ErrorPolicy{Action: ErrorActionQuarantine, Target: "file:" + path,
+			Redact: func(msg types.Message) (types.Message, error) {
+				msg.Body = stringPtr("***")
+				return msg, nil
+			}})
+
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			return errors.New("some error")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, poller.GetSummary().Quarantined)
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var record QuarantinedMessage
+		assert.NoError(t, json.Unmarshal(content, &record))
+		assert.Equal(t, "#1", record.MessageID)
+		assert.Equal(t, "***", record.Body)
+	})
+}
+
+func TestSqsPoller_PollMessagesStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	newPoller := func(t *testing.T, params SQSParam) (*mock_aws.MockSQSAPI, SQSPoller) {
+		sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+		sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).
+			Return(&sqs.GetQueueUrlOutput{}, nil)
+		sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+			Return(&sqs.GetQueueAttributesOutput{
+				Attributes: map[string]string{
+					string(types.QueueAttributeNameApproximateNumberOfMessages): "5",
+				},
+			}, nil)
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+				var messages []types.Message
+				for i := int32(1); i <= in.MaxNumberOfMessages; i++ {
+					id := fmt.Sprintf("#%d", i)
+					messages = append(messages, types.Message{MessageId: stringPtr(id), ReceiptHandle: stringPtr("rh-" + id)})
+				}
+				return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+			})
+
+		params.Client = sqsClient
+		params.Logger = log
+		params.StopOnTotal = true
+		params.QueueConfig.MaxMessagesPerRetrieval = 3
+		poller, err := NewSQSPoller(params)
+		assert.NoError(t, err)
+
+		return sqsClient, poller
+	}
+
+	released := func(sqsClient *mock_aws.MockSQSAPI, receiptHandles ...string) {
+		for _, receiptHandle := range receiptHandles {
+			sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), &sqs.ChangeMessageVisibilityInput{
+				ReceiptHandle:     stringPtr(receiptHandle),
+				VisibilityTimeout: 0,
+			}).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+		}
+	}
+
+	t.Run("by the handler", func(t *testing.T) {
+		sqsClient, poller := newPoller(t, SQSParam{ErrorPolicy: ErrorPolicy{Action: ErrorActionRetry, Retries: 3}})
+		released(sqsClient, "rh-#2", "rh-#3")
+
+		var gotMessages int
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			gotMessages++
+			return errors.Wrap(ErrStopPolling, "found")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, gotMessages)
+		assert.Equal(t, 1, poller.GetSummary().Processed)
+		assert.Equal(t, 0, poller.GetSummary().Retried)
+	})
+
+	t.Run("received again", func(t *testing.T) {
+		sqsClient, poller := newPoller(t, SQSParam{})
+		released(sqsClient, "rh-#3")
+
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			if MessageID(msg) == "#2" {
+				return ErrReceivedAgain
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		// the message seen already isn't counted
+		assert.Equal(t, 1, poller.GetSummary().Processed)
+	})
+
+	t.Run("after N messages", func(t *testing.T) {
+		_, poller := newPoller(t, SQSParam{StopAfter: 2})
+
+		var ids []string
+		err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+			ids = append(ids, MessageID(msg))
+			return nil
+		})
+		assert.NoError(t, err)
+		// no more messages are received than handled
+		assert.Equal(t, []string{"#1", "#2"}, ids)
+	})
+}
+
+// delayClock doesn't wait, it records the delays
+type delayClock struct {
+	realClock
+	delays []time.Duration
+}
+
+func (c *delayClock) After(d time.Duration) <-chan time.Time {
+	c.delays = append(c.delays, d)
+	return instantClock{}.After(d)
+}
@@ -0,0 +1,246 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans
+const tracerName = "andboson/sqsdumper"
+
+// span operations, a span is named like "<queue> process"
+const (
+	SpanReceive = "receive"
+	SpanProcess = "process"
+	SpanDelete  = "delete"
+	SpanForward = "forward"
+)
+
+// awsTraceHeader is the system attribute holding the X-Ray trace header
+const awsTraceHeader = "AWSTraceHeader"
+
+// Tracing creates the spans of the polled messages: a process span is a child of the producer trace context
+// found in the W3C traceparent message attribute or the AWSTraceHeader, a nil instance doesn't trace anything
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	queue      string
+
+	mu sync.Mutex
+	// spans are the process spans by the receipt handle, so the deletes are their children
+	spans map[string]trace.SpanContext
+}
+
+// NewTracing returns the tracing of the queue messages
+func NewTracing(provider trace.TracerProvider, queue string) *Tracing {
+	return &Tracing{
+		tracer: provider.Tracer(tracerName),
+		// W3C goes last to win over X-Ray when both are present
+		propagator: propagation.NewCompositeTextMapPropagator(xray.Propagator{}, propagation.TraceContext{}),
+		queue:      queue,
+		spans:      map[string]trace.SpanContext{},
+	}
+}
+
+// MessageContext returns ctx with the producer trace context of the message
+func (t *Tracing) MessageContext(ctx context.Context, msg types.Message) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	return t.propagator.Extract(ctx, newMessageCarrier(msg))
+}
+
+// StartMessageSpan starts the span of the message operation as a child of the producer trace
+func (t *Tracing) StartMessageSpan(ctx context.Context, msg types.Message, operation string) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	return t.tracer.Start(t.MessageContext(ctx, msg), t.queue+" "+operation,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(t.attributes(operation, MessageID(msg))...),
+	)
+}
+
+// WrapHandler returns the handler running in the process span of the message
+func (t *Tracing) WrapHandler(handler MessageHandler) MessageHandler {
+	if t == nil {
+		return handler
+	}
+
+	return func(poller SQSPoller, msg types.Message) error {
+		_, span := t.StartMessageSpan(context.Background(), msg, SpanProcess)
+		defer span.End()
+
+		receipt := stringValue(msg.ReceiptHandle)
+		t.mu.Lock()
+		t.spans[receipt] = span.SpanContext()
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.spans, receipt)
+			t.mu.Unlock()
+		}()
+
+		err := handler(poller, msg)
+		if err != nil && !errors.Is(err, ErrStopPolling) {
+			RecordSpanError(span, err)
+		}
+
+		return err
+	}
+}
+
+// WrapSQSAPI returns the client creating the receive and delete spans
+func (t *Tracing) WrapSQSAPI(client SQSAPI) SQSAPI {
+	if t == nil {
+		return client
+	}
+
+	return &tracedSQSAPI{SQSAPI: client, tracing: t}
+}
+
+// RecordSpanError marks the span failed
+func RecordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func (t *Tracing) attributes(operation, messageID string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("aws_sqs"),
+		semconv.MessagingDestinationKey.String(t.queue),
+		semconv.MessagingDestinationKindQueue,
+		semconv.MessagingOperationKey.String(operation),
+	}
+	if messageID != "" {
+		attrs = append(attrs, semconv.MessagingMessageIDKey.String(messageID))
+	}
+
+	return attrs
+}
+
+// parent returns ctx with the process span of the receipt handle unless ctx has a span already
+func (t *Tracing) parent(ctx context.Context, receiptHandle *string) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sc, ok := t.spans[stringValue(receiptHandle)]; ok {
+		return trace.ContextWithSpanContext(ctx, sc)
+	}
+
+	return ctx
+}
+
+// messageCarrier reads the trace context from the message attributes, the names are case-insensitive
+type messageCarrier map[string]string
+
+func newMessageCarrier(msg types.Message) messageCarrier {
+	c := messageCarrier{}
+	// SNS keeps the published attributes in the envelope
+	if envelope, err := ParseEventMessage(MessageBody(msg)); err == nil {
+		for name, attr := range envelope.MessageAttributes {
+			c.Set(name, attr.Value)
+		}
+	}
+	for name, attr := range msg.MessageAttributes {
+		if attr.StringValue != nil {
+			c.Set(name, *attr.StringValue)
+		}
+	}
+	if header, ok := msg.Attributes[awsTraceHeader]; ok {
+		c.Set("X-Amzn-Trace-Id", header)
+	}
+
+	return c
+}
+
+func (c messageCarrier) Get(key string) string {
+	return c[strings.ToLower(key)]
+}
+
+func (c messageCarrier) Set(key, value string) {
+	c[strings.ToLower(key)] = value
+}
+
+func (c messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+type tracedSQSAPI struct {
+	SQSAPI
+	tracing *Tracing
+}
+
+func (c *tracedSQSAPI) ReceiveMessage(ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	start := time.Now()
+	out, err := c.SQSAPI.ReceiveMessage(ctx, params, optFns...)
+	if errors.Is(err, context.Canceled) || (err == nil && len(out.Messages) == 0) {
+		// nothing to trace
+		return out, err
+	}
+
+	// the span starts after the call to link the received messages
+	var links []trace.Link
+	if out != nil {
+		for _, msg := range out.Messages {
+			if sc := trace.SpanContextFromContext(c.tracing.MessageContext(context.Background(), msg)); sc.IsValid() {
+				links = append(links, trace.Link{SpanContext: sc})
+			}
+		}
+	}
+	_, span := c.tracing.tracer.Start(ctx, c.tracing.queue+" "+SpanReceive,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(start),
+		trace.WithLinks(links...),
+		trace.WithAttributes(c.tracing.attributes(SpanReceive, "")...),
+	)
+	if err != nil {
+		RecordSpanError(span, err)
+	} else {
+		span.SetAttributes(attribute.Int("messaging.batch.message_count", len(out.Messages)))
+	}
+	span.End()
+
+	return out, err
+}
+
+func (c *tracedSQSAPI) DeleteMessage(ctx context.Context,
+	params *sqs.DeleteMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	ctx, span := c.tracing.tracer.Start(c.tracing.parent(ctx, params.ReceiptHandle), c.tracing.queue+" "+SpanDelete,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(c.tracing.attributes(SpanDelete, "")...),
+	)
+	defer span.End()
+
+	out, err := c.SQSAPI.DeleteMessage(ctx, params, optFns...)
+	if err != nil {
+		RecordSpanError(span, err)
+	}
+
+	return out, err
+}
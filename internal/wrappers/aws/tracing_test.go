@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	producerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	producerSpanID  = "00f067aa0ba902b7"
+	xrayTraceID     = "5759e988bd862e3fe1be46a994272793"
+)
+
+func TestTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl := gomock.NewController(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracing := NewTracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), "orders")
+
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).Return(&sqs.GetQueueUrlOutput{}, nil)
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages): "3",
+			},
+		}, nil)
+	poller, err := NewSQSPoller(SQSParam{Client: tracing.WrapSQSAPI(sqsClient), Logger: log, Quiet: true})
+	assert.NoError(t, err)
+
+	gomock.InOrder(
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			Return(&sqs.ReceiveMessageOutput{Messages: []types.Message{
+				{
+					MessageId:     stringPtr("#1"),
+					ReceiptHandle: stringPtr("rh-1"),
+					MessageAttributes: map[string]types.MessageAttributeValue{
+						"traceparent": {
+							DataType:    stringPtr("String"),
+							StringValue: stringPtr("00-" + producerTraceID + "-" + producerSpanID + "-01"),
+						},
+					},
+				},
+				{
+					MessageId:     stringPtr("#2"),
+					ReceiptHandle: stringPtr("rh-2"),
+					Attributes: map[string]string{
+						awsTraceHeader: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+					},
+				},
+				{MessageId: stringPtr("#3"), ReceiptHandle: stringPtr("rh-3")},
+			}}, nil),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+				cancel()
+				return nil, ctx.Err()
+			}),
+	)
+	sqsClient.EXPECT().DeleteMessage(gomock.Any(), gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil)
+
+	err = poller.PollMessages(ctx, tracing.WrapHandler(func(poller SQSPoller, msg types.Message) error {
+		switch MessageID(msg) {
+		case "#1":
+			_, err := poller.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{ReceiptHandle: msg.ReceiptHandle})
+			return err
+		case "#3":
+			return errors.New("bad payload")
+		}
+		return nil
+	}))
+	assert.NoError(t, err)
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	assert.Len(t, spans["orders receive"], 1, "the canceled receive isn't traced")
+	assert.Len(t, spans["orders process"], 3)
+	assert.Len(t, spans["orders delete"], 1)
+
+	receive := spans["orders receive"][0]
+	assert.Equal(t, trace.SpanKindConsumer, receive.SpanKind())
+	if assert.Len(t, receive.Links(), 2) {
+		assert.Equal(t, producerTraceID, receive.Links()[0].SpanContext.TraceID().String())
+		assert.Equal(t, xrayTraceID, receive.Links()[1].SpanContext.TraceID().String())
+	}
+
+	process := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans["orders process"] {
+		for _, attr := range span.Attributes() {
+			if attr.Key == "messaging.message_id" {
+				process[attr.Value.AsString()] = span
+			}
+		}
+	}
+
+	// the W3C context is continued
+	assert.Equal(t, producerTraceID, process["#1"].SpanContext().TraceID().String())
+	assert.Equal(t, producerSpanID, process["#1"].Parent().SpanID().String())
+	assert.True(t, process["#1"].Parent().IsRemote())
+	// the delete is a child of the process span
+	del := spans["orders delete"][0]
+	assert.Equal(t, process["#1"].SpanContext().SpanID(), del.Parent().SpanID())
+	assert.Equal(t, producerTraceID, del.SpanContext().TraceID().String())
+
+	// the X-Ray context is continued
+	assert.Equal(t, xrayTraceID, process["#2"].SpanContext().TraceID().String())
+	assert.Equal(t, "53995c3f42cd8ad8", process["#2"].Parent().SpanID().String())
+
+	// a message without a context starts a new trace
+	assert.False(t, process["#3"].Parent().IsValid())
+	assert.Equal(t, "Error", process["#3"].Status().Code.String())
+	assert.Equal(t, "bad payload", process["#3"].Status().Description)
+}
+
+func TestTracing_snsEnvelope(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing := NewTracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), "orders")
+
+	body := `{"Type":"Notification","Message":"{}","MessageAttributes":{"Traceparent":` +
+		`{"Type":"String","Value":"00-` + producerTraceID + `-` + producerSpanID + `-01"}}}`
+	_, span := tracing.StartMessageSpan(context.Background(), types.Message{Body: &body}, SpanForward)
+	span.End()
+
+	assert.Equal(t, "orders forward", recorder.Ended()[0].Name())
+	assert.Equal(t, producerTraceID, span.SpanContext().TraceID().String())
+}
+
+func TestTracing_nil(t *testing.T) {
+	var tracing *Tracing
+	ctrl := gomock.NewController(t)
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+
+	assert.Equal(t, sqsClient, tracing.WrapSQSAPI(sqsClient))
+	assert.Nil(t, tracing.WrapHandler(nil))
+
+	ctx, span := tracing.StartMessageSpan(context.Background(), types.Message{}, SpanForward)
+	span.End()
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+)
+
+// ParseTimeBound parses an RFC3339 time or a duration like 2h meaning the time before now
+func ParseTimeBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.Errorf("bad time %q, use RFC3339 like 2022-10-19T15:04:05Z or a duration like 2h", value)
+	}
+
+	return now.Add(-d), nil
+}
+
+// TimeWindowFilter returns a filter accepting the messages sent since the time and before the until time,
+// a zero bound is open, a message without the SentTimestamp attribute isn't accepted
+func TimeWindowFilter(since, until time.Time) MessageFilter {
+	return func(msg types.Message) bool {
+		sent, ok := SentTimestamp(msg)
+		if !ok {
+			return false
+		}
+
+		return (since.IsZero() || !sent.Before(since)) && (until.IsZero() || sent.Before(until))
+	}
+}
+
+// releasedVisibility hides a released message for a while, so the run doesn't receive it again at once
+// raising its receive count, yet the other consumers get it soon
+const releasedVisibility = 10
+
+// released reports whether the filtered message was released already, it isn't counted again
+func (s *sqsPoller) released(msg types.Message) bool {
+	if !s.releaseFiltered {
+		return false
+	}
+	_, ok := s.releasedIDs[MessageID(msg)]
+
+	return ok
+}
+
+// release makes the filtered message visible to the other consumers shortly
+func (s *sqsPoller) release(ctx context.Context, msg types.Message) {
+	if !s.releaseFiltered {
+		return
+	}
+	s.releasedIDs[MessageID(msg)] = struct{}{}
+
+	if _, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          s.queueURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: releasedVisibility,
+	}); err != nil {
+		s.logger.Err(err).Str("messageId", MessageID(msg)).Msg("error releasing the filtered message")
+	}
+}
+
+// handleFiltered passes the filtered message which isn't released to the filtered handler
+func (s *sqsPoller) handleFiltered(msg types.Message) error {
+	if s.releaseFiltered || s.filteredHandler == nil {
+		return nil
+	}
+
+	return s.filteredHandler(s, msg)
+}
@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"andboson/sqsdumper/internal/mocks/mock_aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func sentMessage(id string, sent time.Time) types.Message {
+	return types.Message{
+		MessageId:     stringPtr(id),
+		ReceiptHandle: stringPtr("rh-" + id),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(sent.UnixMilli(), 10),
+		},
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2022, 10, 19, 15, 0, 0, 0, time.UTC)
+
+	bound, err := ParseTimeBound("2022-10-19T12:30:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 10, 19, 12, 30, 0, 0, time.UTC), bound.UTC())
+
+	bound, err = ParseTimeBound("2h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 10, 19, 13, 0, 0, 0, time.UTC), bound)
+
+	for _, value := range []string{"yesterday", "-2h", "2022-10-19"} {
+		_, err = ParseTimeBound(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestTimeWindowFilter(t *testing.T) {
+	since := time.Date(2022, 10, 19, 12, 0, 0, 0, time.UTC)
+	until := since.Add(time.Hour)
+
+	filter := TimeWindowFilter(since, until)
+	assert.True(t, filter(sentMessage("#1", since)))
+	assert.True(t, filter(sentMessage("#2", until.Add(-time.Millisecond))))
+	assert.False(t, filter(sentMessage("#3", until)))
+	assert.False(t, filter(sentMessage("#4", since.Add(-time.Millisecond))))
+	assert.False(t, filter(types.Message{MessageId: stringPtr("#5")}), "no SentTimestamp")
+
+	// open bounds
+	assert.True(t, TimeWindowFilter(time.Time{}, until)(sentMessage("#6", time.Unix(0, 0))))
+	assert.True(t, TimeWindowFilter(since, time.Time{})(sentMessage("#7", until.Add(time.Hour))))
+}
+
+func TestSqsPoller_PollMessagesReleaseFiltered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	now := time.Now()
+
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	sqsClient.EXPECT().GetQueueUrl(gomock.Any(), gomock.Any()).Return(&sqs.GetQueueUrlOutput{}, nil)
+	sqsClient.EXPECT().GetQueueAttributes(gomock.Any(), gomock.Any()).
+		Return(&sqs.GetQueueAttributesOutput{
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameApproximateNumberOfMessages): "3",
+			},
+		}, nil)
+
+	stale := sentMessage("#1", now.Add(-3*time.Hour))
+	gomock.InOrder(
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			Return(&sqs.ReceiveMessageOutput{Messages: []types.Message{stale, sentMessage("#2", now)}}, nil),
+		// the released message is received again
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			Return(&sqs.ReceiveMessageOutput{Messages: []types.Message{stale}}, nil),
+		sqsClient.EXPECT().ReceiveMessage(gomock.Any(), gomock.Any()).
+			Return(&sqs.ReceiveMessageOutput{Messages: []types.Message{sentMessage("#3", now)}}, nil),
+	)
+	sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+			assert.Equal(t, "rh-#1", *in.ReceiptHandle)
+			// the released message isn't received again at once
+			assert.Equal(t, int32(releasedVisibility), in.VisibilityTimeout)
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}).Times(2)
+
+	poller, err := NewSQSPoller(SQSParam{
+		Client:          sqsClient,
+		Logger:          log,
+		Quiet:           true,
+		StopOnTotal:     true,
+		Filters:         []MessageFilter{TimeWindowFilter(now.Add(-2*time.Hour), time.Time{})},
+		ReleaseFiltered: true,
+	})
+	assert.NoError(t, err)
+
+	var handled []string
+	err = poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+		handled = append(handled, MessageID(msg))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"#2", "#3"}, handled)
+	assert.Equal(t, 1, poller.GetSummary().Filtered)
+}
+
+func TestSqsPoller_PollMessagesFilteredHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	now := time.Now()
+
+	stale := sentMessage("#1", now.Add(-3*time.Hour))
+	sqsClient := mock_aws.NewMockSQSAPI(ctrl)
+	// the message after the stop is released
+	sqsClient.EXPECT().ChangeMessageVisibility(gomock.Any(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:      stringPtr("url"),
+		ReceiptHandle: stringPtr("rh-#3"),
+	}).Return(&sqs.ChangeMessageVisibilityOutput{}, nil)
+
+	var filtered []string
+	poller := newTestPoller(t, sqsClient, "4", []types.Message{stale, sentMessage("#2", now), stale, sentMessage("#3", now)}, SQSParam{
+		Quiet:   true,
+		Filters: []MessageFilter{TimeWindowFilter(now.Add(-2*time.Hour), time.Time{})},
+		// the filtered message is held by the scan, it's received again once the queue is scanned
+		FilteredHandler: func(_ SQSPoller, msg types.Message) error {
+			for _, id := range filtered {
+				if id == MessageID(msg) {
+					return ErrReceivedAgain
+				}
+			}
+			filtered = append(filtered, MessageID(msg))
+			return nil
+		},
+	})
+
+	var handled []string
+	err := poller.PollMessages(context.Background(), func(poller SQSPoller, msg types.Message) error {
+		handled = append(handled, MessageID(msg))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"#2"}, handled)
+	assert.Equal(t, []string{"#1"}, filtered)
+	assert.Equal(t, 1, poller.GetSummary().Filtered)
+}